@@ -0,0 +1,229 @@
+package weatherstn
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Aggregation is a function used to combine the samples of a metric taken within an observation interval into the
+// single value that is stored.
+type Aggregation string
+
+const (
+	// AggregateMean stores the mean of the samples. Wind direction uses the circular mean so that samples either side
+	// of north average to north rather than south.
+	AggregateMean Aggregation = "mean"
+
+	// AggregateMin stores the lowest sample.
+	AggregateMin Aggregation = "min"
+
+	// AggregateMax stores the highest sample.
+	AggregateMax Aggregation = "max"
+
+	// AggregateSum stores the total of the samples.
+	AggregateSum Aggregation = "sum"
+
+	// AggregateLast stores the most recent sample.
+	AggregateLast Aggregation = "last"
+)
+
+const (
+	metricTemperature   = "temperature"
+	metricHumidity      = "humidity"
+	metricPressure      = "pressure"
+	metricWindSpeed     = "wind_speed"
+	metricWindDirection = "wind_direction"
+	metricWindGust      = "wind_gust"
+	metricRainfall      = "rainfall"
+)
+
+// defaultAggregations are used for any metric which isn't configured. Providers reset their wind and rain readings each
+// time they are read so gusts are the highest of the samples and rainfall is their total.
+var defaultAggregations = map[string]Aggregation{
+	metricTemperature:   AggregateMean,
+	metricHumidity:      AggregateMean,
+	metricPressure:      AggregateMean,
+	metricWindSpeed:     AggregateMean,
+	metricWindDirection: AggregateMean,
+	metricWindGust:      AggregateMax,
+	metricRainfall:      AggregateSum,
+}
+
+// ParseAggregation returns the Aggregation with the given name.
+func ParseAggregation(name string) (Aggregation, error) {
+	switch Aggregation(name) {
+	case AggregateMean, AggregateMin, AggregateMax, AggregateSum, AggregateLast:
+		return Aggregation(name), nil
+	default:
+		return "", fmt.Errorf("unknown aggregation %s", name)
+	}
+}
+
+// Aggregate combines the samples, which must not be empty, into a single value.
+func (a Aggregation) Aggregate(samples []float64) float64 {
+	switch a {
+	case AggregateMin:
+		min := samples[0]
+		for _, sample := range samples[1:] {
+			min = math.Min(min, sample)
+		}
+		return min
+	case AggregateMax:
+		max := samples[0]
+		for _, sample := range samples[1:] {
+			max = math.Max(max, sample)
+		}
+		return max
+	case AggregateSum:
+		sum := 0.0
+		for _, sample := range samples {
+			sum += sample
+		}
+		return sum
+	case AggregateLast:
+		return samples[len(samples)-1]
+	default:
+		return AggregateSum.Aggregate(samples) / float64(len(samples))
+	}
+}
+
+// circularMean returns the mean of the bearings in degrees, in the range [0, 360).
+func circularMean(degrees []float64) float64 {
+	var x, y float64
+	for _, deg := range degrees {
+		rad := deg * math.Pi / 180
+		x += math.Cos(rad)
+		y += math.Sin(rad)
+	}
+
+	mean := math.Atan2(y, x) * 180 / math.Pi
+	if mean < 0 {
+		mean += 360
+	}
+
+	return mean
+}
+
+// Aggregator builds observations from the samples of each metric taken within an observation interval.
+type Aggregator struct {
+	aggregations map[string]Aggregation
+}
+
+// NewAggregator creates a new Aggregator. The aggregations map metric names, as used by export, to the name of the
+// Aggregation applied to them, metrics that aren't included use their default.
+func NewAggregator(aggregations map[string]string) (*Aggregator, error) {
+	resolved := make(map[string]Aggregation, len(defaultAggregations))
+	for metric, aggregation := range defaultAggregations {
+		resolved[metric] = aggregation
+	}
+
+	for metric, name := range aggregations {
+		if _, ok := defaultAggregations[metric]; !ok {
+			metrics := make([]string, 0, len(defaultAggregations))
+			for metric := range defaultAggregations {
+				metrics = append(metrics, metric)
+			}
+			sort.Strings(metrics)
+
+			return nil, fmt.Errorf("unknown aggregation metric %s, valid metrics are %s", metric,
+				strings.Join(metrics, ", "))
+		}
+
+		aggregation, err := ParseAggregation(name)
+		if err != nil {
+			return nil, err
+		}
+		resolved[metric] = aggregation
+	}
+
+	return &Aggregator{aggregations: resolved}, nil
+}
+
+func (a *Aggregator) aggregate(samples map[string][]float64, metric string) (float64, bool) {
+	values := samples[metric]
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	aggregation := a.aggregations[metric]
+	if metric == metricWindDirection && aggregation == AggregateMean {
+		return circularMean(values), true
+	}
+
+	return aggregation.Aggregate(values), true
+}
+
+// Readings aggregates the samples, keyed by metric, into readings. Metrics without any samples are zero, apart from
+// wind direction which is -1 as 0 is a valid bearing.
+func (a *Aggregator) Readings(samples map[string][]float64) (AtmoshphericReadings, WindReadings, RainReadings) {
+	var atmos AtmoshphericReadings
+	atmos.Temperature, _ = a.aggregate(samples, metricTemperature)
+	atmos.Humidity, _ = a.aggregate(samples, metricHumidity)
+	atmos.Pressure, _ = a.aggregate(samples, metricPressure)
+	if temps := samples[metricTemperature]; len(temps) > 0 {
+		min := AggregateMin.Aggregate(temps)
+		max := AggregateMax.Aggregate(temps)
+		atmos.TemperatureMin = &min
+		atmos.TemperatureMax = &max
+	}
+
+	var wind WindReadings
+	wind.Speed, _ = a.aggregate(samples, metricWindSpeed)
+	wind.Gust, _ = a.aggregate(samples, metricWindGust)
+	wind.Direction = -1
+	if direction, ok := a.aggregate(samples, metricWindDirection); ok {
+		wind.Direction = float32(direction)
+	}
+
+	var rain RainReadings
+	rain.Rainfall, _ = a.aggregate(samples, metricRainfall)
+
+	return atmos, wind, rain
+}
+
+// sampleBuffer collects the samples of each metric taken since it was last drained.
+type sampleBuffer struct {
+	samples map[string][]float64
+	lock    sync.Mutex
+}
+
+func newSampleBuffer() *sampleBuffer {
+	return &sampleBuffer{samples: make(map[string][]float64)}
+}
+
+func (sb *sampleBuffer) add(metric string, value float64) {
+	sb.lock.Lock()
+	sb.samples[metric] = append(sb.samples[metric], value)
+	sb.lock.Unlock()
+}
+
+func (sb *sampleBuffer) addAtmos(readings *AtmoshphericReadings) {
+	sb.add(metricTemperature, readings.Temperature)
+	sb.add(metricHumidity, readings.Humidity)
+	sb.add(metricPressure, readings.Pressure)
+}
+
+func (sb *sampleBuffer) addWind(readings *WindReadings) {
+	sb.add(metricWindSpeed, readings.Speed)
+	sb.add(metricWindGust, readings.Gust)
+	if readings.Direction >= 0 { // unrecognised bearings are reported as -1
+		sb.add(metricWindDirection, float64(readings.Direction))
+	}
+}
+
+func (sb *sampleBuffer) addRain(readings *RainReadings) {
+	sb.add(metricRainfall, readings.Rainfall)
+}
+
+// drain returns the samples collected so far and empties the buffer.
+func (sb *sampleBuffer) drain() map[string][]float64 {
+	sb.lock.Lock()
+	samples := sb.samples
+	sb.samples = make(map[string][]float64)
+	sb.lock.Unlock()
+
+	return samples
+}
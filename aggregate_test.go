@@ -0,0 +1,96 @@
+package weatherstn
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAggregation_Aggregate(t *testing.T) {
+	samples := []float64{3, -1.5, 7, 2}
+	tests := map[Aggregation]float64{
+		AggregateMean: 2.625,
+		AggregateMin:  -1.5,
+		AggregateMax:  7,
+		AggregateSum:  10.5,
+		AggregateLast: 2,
+	}
+
+	for aggregation, expected := range tests {
+		if actual := aggregation.Aggregate(samples); actual != expected {
+			t.Fatalf("expected %s to be %f but was %f", aggregation, expected, actual)
+		}
+	}
+}
+
+func TestCircularMean(t *testing.T) {
+	tests := []struct {
+		degrees  []float64
+		expected float64
+	}{
+		{[]float64{350, 10}, 0},
+		{[]float64{337.5, 22.5, 0}, 0},
+		{[]float64{90, 180}, 135},
+		{[]float64{270}, 270},
+	}
+
+	for _, test := range tests {
+		mean := circularMean(test.degrees)
+		if math.Abs(math.Mod(mean-test.expected+540, 360)-180) > 1e-9 {
+			t.Fatalf("expected circular mean of %v to be %f but was %f", test.degrees, test.expected, mean)
+		}
+	}
+}
+
+func TestAggregator_Readings(t *testing.T) {
+	aggregator, err := NewAggregator(map[string]string{"wind_speed": "max"})
+	if err != nil {
+		t.Fatalf("unexpected error creating aggregator: %v", err)
+	}
+
+	buffer := newSampleBuffer()
+	buffer.addAtmos(&AtmoshphericReadings{Temperature: 10, Humidity: 80, Pressure: 1000})
+	buffer.addAtmos(&AtmoshphericReadings{Temperature: 12, Humidity: 70, Pressure: 1002})
+	buffer.addAtmos(&AtmoshphericReadings{Temperature: 8, Humidity: 90, Pressure: 1001})
+	buffer.addWind(&WindReadings{Speed: 5, Direction: 350, Gust: 9})
+	buffer.addWind(&WindReadings{Speed: 7, Direction: -1, Gust: 12})
+	buffer.addWind(&WindReadings{Speed: 3, Direction: 10, Gust: 4})
+	buffer.addRain(&RainReadings{Rainfall: 0.2794})
+	buffer.addRain(&RainReadings{Rainfall: 0.5588})
+
+	atmos, wind, rain := aggregator.Readings(buffer.drain())
+
+	if atmos.Temperature != 10 || atmos.Humidity != 80 || atmos.Pressure != 1001 {
+		t.Fatalf("expected mean atmospheric readings but got %#v", atmos)
+	}
+	if atmos.TemperatureMin == nil || *atmos.TemperatureMin != 8 {
+		t.Fatalf("expected temperature min of 8 but was %v", atmos.TemperatureMin)
+	}
+	if atmos.TemperatureMax == nil || *atmos.TemperatureMax != 12 {
+		t.Fatalf("expected temperature max of 12 but was %v", atmos.TemperatureMax)
+	}
+	if wind.Speed != 7 || wind.Gust != 12 {
+		t.Fatalf("expected max wind speed and gust but got %#v", wind)
+	}
+	// The unrecognised bearing is ignored and the remaining samples either side of north average to north.
+	if wind.Direction > 1e-4 && wind.Direction < 360-1e-4 {
+		t.Fatalf("expected wind direction to be north but was %f", wind.Direction)
+	}
+	if math.Abs(rain.Rainfall-0.8382) > 1e-9 {
+		t.Fatalf("expected total rainfall of 0.8382 but was %f", rain.Rainfall)
+	}
+
+	// The buffer is emptied by draining.
+	atmos, wind, rain = aggregator.Readings(buffer.drain())
+	if atmos.TemperatureMin != nil || wind.Direction != -1 || rain.Rainfall != 0 {
+		t.Fatalf("expected empty readings but got %#v %#v %#v", atmos, wind, rain)
+	}
+}
+
+func TestNewAggregator_Invalid(t *testing.T) {
+	if _, err := NewAggregator(map[string]string{"dew_point": "mean"}); err == nil {
+		t.Fatalf("expected unknown metric to be rejected")
+	}
+	if _, err := NewAggregator(map[string]string{"temperature": "median"}); err == nil {
+		t.Fatalf("expected unknown aggregation to be rejected")
+	}
+}
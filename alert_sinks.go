@@ -0,0 +1,439 @@
+package weatherstn
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of alert sink.
+const (
+	AlertSinkWebhook = "webhook"
+	AlertSinkSMTP    = "smtp"
+	AlertSinkMQTT    = "mqtt"
+)
+
+const (
+	// DefaultSMTPPort is the port that mail is submitted to when none is configured, or DefaultSMTPTLSPort when
+	// connecting over TLS.
+	DefaultSMTPPort    = 587
+	DefaultSMTPTLSPort = 465
+
+	// DefaultMQTTPort is the port of an MQTT broker when the broker URL has none, or DefaultMQTTTLSPort when
+	// connecting over TLS.
+	DefaultMQTTPort    = 1883
+	DefaultMQTTTLSPort = 8883
+)
+
+const (
+	// mqttKeepAliveSecs is the keep alive sent to MQTT brokers, the connection only lasts for a single publish.
+	mqttKeepAliveSecs = 60
+
+	// mqttProtocolVersion pins brokers to MQTT 3.1.1 so that a refused connection isn't retried as MQTT 3.1.
+	mqttProtocolVersion = 4
+
+	// mqttDisconnectQuiesceMillis is how long disconnecting from a broker waits for work in progress to complete.
+	mqttDisconnectQuiesceMillis = 250
+)
+
+// AlertSinkConfig is the set of configuration properties for a sink that alerts are delivered to. Which properties are
+// used depends on the type of the sink.
+type AlertSinkConfig struct {
+	Type string `json:"type"` // webhook, smtp or mqtt
+
+	// webhook: alerts are POSTed as JSON to the URL with the headers.
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+
+	// smtp: alerts are mailed from From to each of To through the server at Host. TLS connects over TLS, as on port
+	// 465, otherwise STARTTLS is used when the server offers it.
+	Host string   `json:"host"`
+	Port int      `json:"port"` // defaults to DefaultSMTPPort or DefaultSMTPTLSPort
+	TLS  bool     `json:"tls"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+
+	// mqtt: alerts are published as JSON to Topic on the broker, a tcp:// or ssl:// URL e.g. ssl://broker.lan:8883.
+	Broker   string `json:"broker"`
+	Topic    string `json:"topic"`
+	ClientID string `json:"clientId"` // defaults to weatherstn-<station id>-<sequence number>
+	QoS      int    `json:"qos"`      // 0 or 1
+	Retain   bool   `json:"retain"`
+
+	// smtp and mqtt: credentials to authenticate with, if any.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// redacted returns a copy of the config which is safe to log, with the URLs, header values and password redacted.
+func (sc AlertSinkConfig) redacted() AlertSinkConfig {
+	sc.URL = redactRawURL(sc.URL)
+	sc.Headers = redactHeaders(sc.Headers)
+	sc.Broker = redactRawURL(sc.Broker)
+	if sc.Password != "" {
+		sc.Password = redactedValue
+	}
+
+	return sc
+}
+
+// Validate checks that the sink can be used. All of the problems found are returned together as a *ConfigError.
+func (sc AlertSinkConfig) Validate() error {
+	var problems configProblems
+	switch sc.Type {
+	case AlertSinkWebhook:
+		target, err := url.Parse(sc.URL)
+		switch {
+		case err != nil:
+			problems.add("url: %v", err)
+		case target.Scheme != "http" && target.Scheme != "https":
+			problems.add("url scheme must be http or https but was %q", target.Scheme)
+		case target.Host == "":
+			problems.add("url %s has no host", sc.URL)
+		}
+	case AlertSinkSMTP:
+		if sc.Host == "" {
+			problems.add("host must be set")
+		}
+		problems.notNegative("port", sc.Port)
+		if _, err := mail.ParseAddress(sc.From); err != nil {
+			problems.add("from: %v", err)
+		}
+		if len(sc.To) == 0 {
+			problems.add("to must have at least one address")
+		}
+		for i, to := range sc.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				problems.add("to[%d]: %v", i, err)
+			}
+		}
+	case AlertSinkMQTT:
+		if _, _, err := sc.brokerAddress(); err != nil {
+			problems.add("broker: %v", err)
+		}
+		if sc.Topic == "" || strings.ContainsAny(sc.Topic, "+#") {
+			problems.add("topic must be set and must not contain wildcards but was %q", sc.Topic)
+		}
+		if sc.QoS != 0 && sc.QoS != 1 {
+			problems.add("qos must be 0 or 1 but was %d", sc.QoS)
+		}
+	default:
+		problems.add("type %q is unknown, valid types are %s, %s and %s", sc.Type, AlertSinkWebhook, AlertSinkSMTP,
+			AlertSinkMQTT)
+	}
+
+	return problems.err()
+}
+
+// brokerAddress returns the address of the MQTT broker and whether to connect to it over TLS.
+func (sc AlertSinkConfig) brokerAddress() (string, bool, error) {
+	broker, err := url.Parse(sc.Broker)
+	if err != nil {
+		return "", false, err
+	}
+
+	var secure bool
+	port := DefaultMQTTPort
+	switch broker.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		secure = true
+		port = DefaultMQTTTLSPort
+	default:
+		return "", false, fmt.Errorf("scheme must be tcp or ssl but was %q", broker.Scheme)
+	}
+	if broker.Hostname() == "" {
+		return "", false, fmt.Errorf("%s has no host", sc.Broker)
+	}
+	if broker.Port() != "" {
+		return broker.Host, secure, nil
+	}
+
+	return net.JoinHostPort(broker.Hostname(), strconv.Itoa(port)), secure, nil
+}
+
+// AlertSink delivers alerts somewhere they will be seen.
+type AlertSink interface {
+	// Notify delivers the alert, returning once it has been delivered or ctx is done.
+	Notify(ctx context.Context, alert Alert) error
+	// String describes the sink for logging, without any credentials.
+	String() string
+}
+
+// NewAlertSink creates the AlertSink described by config.
+func NewAlertSink(config AlertSinkConfig) (AlertSink, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case AlertSinkWebhook:
+		return &WebhookAlertSink{config: config, cli: &http.Client{}}, nil
+	case AlertSinkSMTP:
+		return &SMTPAlertSink{config: config}, nil
+	default:
+		return &MQTTAlertSink{config: config}, nil
+	}
+}
+
+// WebhookAlertSink POSTs alerts as JSON to a URL.
+type WebhookAlertSink struct {
+	config AlertSinkConfig
+	cli    PublisherHTTPClient
+}
+
+// Notify POSTs the alert, any 2xx response is taken as it having been delivered.
+func (ws *WebhookAlertSink) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range ws.config.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ws.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.WithError(err).
+				WithField("component", "WebhookAlertSink").
+				WithField("event", "Notify").
+				Error("failed to close response body")
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// String describes the sink by its URL, with any password redacted.
+func (ws *WebhookAlertSink) String() string {
+	target, err := url.Parse(ws.config.URL)
+	if err != nil {
+		return AlertSinkWebhook
+	}
+
+	return AlertSinkWebhook + " " + target.Redacted()
+}
+
+// SMTPAlertSink mails alerts.
+type SMTPAlertSink struct {
+	config AlertSinkConfig
+}
+
+// Notify mails the alert to each recipient.
+func (ss *SMTPAlertSink) Notify(ctx context.Context, alert Alert) error {
+	port := ss.config.Port
+	if port == 0 {
+		port = DefaultSMTPPort
+		if ss.config.TLS {
+			port = DefaultSMTPTLSPort
+		}
+	}
+	address := net.JoinHostPort(ss.config.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: ss.config.Host}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return closeAfter(conn, err)
+		}
+	}
+	if ss.config.TLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, ss.config.Host)
+	if err != nil {
+		return closeAfter(conn, err)
+	}
+	if err := ss.send(client, tlsConfig, alert); err != nil {
+		return closeAfter(client, err)
+	}
+
+	return client.Quit()
+}
+
+// send sends the alert over an open connection.
+func (ss *SMTPAlertSink) send(client *smtp.Client, tlsConfig *tls.Config, alert Alert) error {
+	if ok, _ := client.Extension("STARTTLS"); ok && !ss.config.TLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if ss.config.Username != "" {
+		// PlainAuth refuses to send the credentials unless the connection is encrypted or to localhost.
+		if err := client.Auth(smtp.PlainAuth("", ss.config.Username, ss.config.Password, ss.config.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(ss.config.From)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range ss.config.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(ss.message(alert)); err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// message returns the mail for the alert, with its summary as the subject and its details in the body.
+func (ss *SMTPAlertSink) message(alert Alert) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", ss.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(ss.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", alert.Summary())
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&b, "%s\r\n\r\n", alert.Summary())
+	fmt.Fprintf(&b, "Station:     %s\r\n", alert.StationID)
+	fmt.Fprintf(&b, "Rule:        %s (%s)\r\n", alert.Rule, alert.Type)
+	fmt.Fprintf(&b, "Severity:    %s\r\n", alert.Severity)
+	fmt.Fprintf(&b, "State:       %s\r\n", alert.State)
+	fmt.Fprintf(&b, "Value:       %g\r\n", alert.Value)
+	fmt.Fprintf(&b, "Threshold:   %s %g\r\n", alert.Condition, alert.Threshold)
+	fmt.Fprintf(&b, "Observation: %s\r\n", time.Unix(alert.Timestamp, 0).UTC().Format(time.RFC3339))
+
+	return b.Bytes()
+}
+
+// String describes the sink by its server.
+func (ss *SMTPAlertSink) String() string {
+	return fmt.Sprintf("%s %s", AlertSinkSMTP, ss.config.Host)
+}
+
+// MQTTAlertSink publishes alerts as JSON to a topic on an MQTT broker, connecting for each alert. A broker drops a
+// connection when another connects with the same client id, so alerts delivered at the same time connect with their
+// own default client id, or take turns when the client id is configured.
+type MQTTAlertSink struct {
+	config AlertSinkConfig
+	lock   sync.Mutex // held while connected with the configured client id
+}
+
+// mqttConnections numbers the connections made with a default client id.
+var mqttConnections uint64
+
+// Notify connects to the broker and publishes the alert. At QoS 1 it waits for the broker to acknowledge the publish.
+func (ms *MQTTAlertSink) Notify(ctx context.Context, alert Alert) error {
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	address, secure, err := ms.config.brokerAddress()
+	if err != nil {
+		return err
+	}
+	scheme := "tcp"
+	if secure {
+		scheme = "ssl"
+	}
+
+	clientID := ms.config.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("weatherstn-%s-%d", alert.StationID, atomic.AddUint64(&mqttConnections, 1))
+	} else {
+		ms.lock.Lock()
+		defer ms.lock.Unlock()
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(scheme + "://" + address).
+		SetClientID(clientID).
+		SetUsername(ms.config.Username).
+		SetPassword(ms.config.Password).
+		SetProtocolVersion(mqttProtocolVersion).
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAliveSecs * time.Second).
+		SetAutoReconnect(false)
+	if deadline, ok := ctx.Deadline(); ok {
+		options.SetConnectTimeout(time.Until(deadline))
+	}
+
+	client := mqtt.NewClient(options)
+	if err := waitMQTT(ctx, client.Connect()); err != nil {
+		return err
+	}
+	defer client.Disconnect(mqttDisconnectQuiesceMillis)
+
+	return waitMQTT(ctx, client.Publish(ms.config.Topic, byte(ms.config.QoS), ms.config.Retain, payload))
+}
+
+// waitMQTT waits for the token to complete, returning its error, or for ctx to be done.
+func waitMQTT(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// String describes the sink by its broker and topic.
+func (ms *MQTTAlertSink) String() string {
+	return fmt.Sprintf("%s %s/%s", AlertSinkMQTT, redactRawURL(ms.config.Broker), ms.config.Topic)
+}
+
+// closeAfter closes c after err has happened using it, logging any error from closing and returning err.
+func closeAfter(c io.Closer, err error) error {
+	if closeErr := c.Close(); closeErr != nil {
+		log.WithError(closeErr).
+			WithField("component", "AlertSink").
+			WithField("event", "Notify").
+			Error("failed to close connection")
+	}
+
+	return err
+}
@@ -0,0 +1,434 @@
+package weatherstn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testAlert returns an alert for a frost rule firing at allotment.
+func testAlert() Alert {
+	return Alert{Rule: "frost", Severity: AlertSeverityCritical, State: AlertStateFiring, StationID: "allotment",
+		Metric: "temperature", Type: AlertRuleThreshold, Condition: AlertBelow, Value: -1.5, Threshold: 0,
+		Timestamp: 1548884100}
+}
+
+// listen returns a listener on a local port and its host and port.
+func listen(t *testing.T) (net.Listener, string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to split address: %v", err)
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("failed to parse port: %v", err)
+	}
+
+	return listener, host, portNumber
+}
+
+func TestAlertEngine_ObserveWebhook(t *testing.T) {
+	received := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- alert
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	engine, err := NewAlertEngine(AlertsConfig{
+		Rules: []AlertRuleConfig{{Name: "gust", Metric: "wind_gust", Condition: AlertAbove, Value: 60}},
+		Sinks: map[string]AlertSinkConfig{
+			"hook": {Type: AlertSinkWebhook, URL: server.URL, Headers: map[string]string{"Authorization": "Bearer secret"}},
+		},
+	}, "allotment")
+	if err != nil {
+		t.Fatalf("failed to create alert engine: %v", err)
+	}
+
+	engine.Observe(WeatherDataRow{Timestamp: 1548884100, WindReadings: WindReadings{Gust: 72}})
+	engine.Wait()
+
+	select {
+	case alert := <-received:
+		if alert.Rule != "gust" || alert.State != AlertStateFiring || alert.StationID != "allotment" || alert.Value != 72 {
+			t.Fatalf("expected the gust alert to be posted but got %#v", alert)
+		}
+	default:
+		t.Fatal("expected the alert to be posted to the webhook")
+	}
+
+	sink, err := NewAlertSink(AlertSinkConfig{Type: AlertSinkWebhook, URL: server.URL})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	if err := sink.Notify(context.Background(), testAlert()); err == nil {
+		t.Fatal("expected an error when the webhook rejects the alert")
+	}
+}
+
+func TestAlertEngine_ObserveRetries(t *testing.T) {
+	backoff := alertRetryBackoff
+	alertRetryBackoff = time.Millisecond
+	defer func() { alertRetryBackoff = backoff }()
+
+	// The webhook is unavailable for the first two attempts of each alert.
+	var lock sync.Mutex
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		attempts[alert.Rule]++
+		attempt := attempts[alert.Rule]
+		lock.Unlock()
+		if attempt <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sinks := map[string]AlertSinkConfig{"hook": {Type: AlertSinkWebhook, URL: server.URL}}
+	rules := []AlertRuleConfig{{Name: "gust", Metric: "wind_gust", Condition: AlertAbove, Value: 60}}
+	for _, test := range []struct {
+		maxAttempts, expected int
+	}{
+		{maxAttempts: 0, expected: 3}, // the default allows enough attempts to get through
+		{maxAttempts: 2, expected: 2},
+	} {
+		attempts = make(map[string]int)
+		engine, err := NewAlertEngine(AlertsConfig{Rules: rules, Sinks: sinks, MaxAttempts: test.maxAttempts},
+			"allotment")
+		if err != nil {
+			t.Fatalf("failed to create alert engine: %v", err)
+		}
+
+		engine.Observe(WeatherDataRow{Timestamp: 1548884100, WindReadings: WindReadings{Gust: 72}})
+		engine.Wait()
+		if attempts["gust"] != test.expected {
+			t.Fatalf("expected %d attempts with maxAttempts %d but got %d", test.expected, test.maxAttempts,
+				attempts["gust"])
+		}
+	}
+}
+
+func TestAlertEngine_Close(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	engine, err := NewAlertEngine(AlertsConfig{
+		Rules: []AlertRuleConfig{{Name: "gust", Metric: "wind_gust", Condition: AlertAbove, Value: 60}},
+		Sinks: map[string]AlertSinkConfig{"hook": {Type: AlertSinkWebhook, URL: server.URL}},
+	}, "allotment")
+	if err != nil {
+		t.Fatalf("failed to create alert engine: %v", err)
+	}
+
+	engine.Observe(WeatherDataRow{Timestamp: 1548884100, WindReadings: WindReadings{Gust: 72}})
+	start := time.Now()
+	engine.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected closing to stop retrying the delivery but took %s", elapsed)
+	}
+}
+
+func TestSMTPAlertSink(t *testing.T) {
+	listener, host, port := listen(t)
+	defer listener.Close()
+
+	// Accept a single mail, recording the commands sent and the message.
+	type session struct {
+		commands []string
+		message  string
+	}
+	sessions := make(chan session, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var s session
+		r := bufio.NewReader(conn)
+		reply := func(line string) bool {
+			_, err := conn.Write([]byte(line + "\r\n"))
+			return err == nil
+		}
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimSpace(line)
+			s.commands = append(s.commands, command)
+			switch {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				var message strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					message.WriteString(line)
+				}
+				s.message = message.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				sessions <- s
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	sink, err := NewAlertSink(AlertSinkConfig{Type: AlertSinkSMTP, Host: host, Port: port,
+		From: "Weather Station <station@example.com>", To: []string{"ops@example.com", "farm@example.com"}})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Notify(ctx, testAlert()); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+
+	s := <-sessions
+	expected := []string{"MAIL FROM:<station@example.com>", "RCPT TO:<ops@example.com>", "RCPT TO:<farm@example.com>"}
+	if len(s.commands) < 4 || strings.Join(s.commands[1:4], "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected the mail to be sent to both recipients but the commands were %v", s.commands)
+	}
+	if !strings.Contains(s.message, "Subject: [critical] frost firing at allotment: temperature -1.5 below 0\r\n") {
+		t.Fatalf("expected the subject to summarise the alert but the message was %q", s.message)
+	}
+}
+
+// serveMQTT acts as a broker on the listener, acknowledging connections and publishes and sending the packets
+// received on each connection once the client disconnects. As brokers do, a connection with the client id of one
+// which is already connected drops the earlier connection. Publishes are acknowledged after a delay so that
+// connections made at the same time overlap.
+func serveMQTT(listener net.Listener) <-chan []packets.ControlPacket {
+	received := make(chan []packets.ControlPacket, 10)
+	var lock sync.Mutex
+	connected := make(map[string]net.Conn)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		var clientID string
+		var controlPackets []packets.ControlPacket
+		defer func() {
+			lock.Lock()
+			if connected[clientID] == conn {
+				delete(connected, clientID)
+			}
+			lock.Unlock()
+			received <- controlPackets
+		}()
+		for {
+			packet, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			controlPackets = append(controlPackets, packet)
+			switch p := packet.(type) {
+			case *packets.ConnectPacket:
+				clientID = p.ClientIdentifier
+				lock.Lock()
+				if earlier, ok := connected[clientID]; ok {
+					earlier.Close()
+				}
+				connected[clientID] = conn
+				lock.Unlock()
+
+				connack := packets.NewControlPacket(packets.Connack)
+				if err := connack.Write(conn); err != nil {
+					return
+				}
+			case *packets.PublishPacket:
+				time.Sleep(50 * time.Millisecond)
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				if err := puback.Write(conn); err != nil {
+					return
+				}
+			case *packets.DisconnectPacket:
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return received
+}
+
+func TestMQTTAlertSink(t *testing.T) {
+	listener, host, port := listen(t)
+	defer listener.Close()
+	received := serveMQTT(listener)
+
+	sink, err := NewAlertSink(AlertSinkConfig{Type: AlertSinkMQTT, Broker: "tcp://" + net.JoinHostPort(host,
+		strconv.Itoa(port)), Topic: "weather/alerts", QoS: 1, Username: "station", Password: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Notify(ctx, testAlert()); err != nil {
+		t.Fatalf("failed to notify: %v", err)
+	}
+
+	controlPackets := <-received
+	if len(controlPackets) != 3 {
+		t.Fatalf("expected connect, publish and disconnect but got %v", controlPackets)
+	}
+	connect, ok := controlPackets[0].(*packets.ConnectPacket)
+	if !ok || !strings.HasPrefix(connect.ClientIdentifier, "weatherstn-allotment-") ||
+		connect.Username != "station" || string(connect.Password) != "secret" {
+		t.Fatalf("expected to connect with the client id and credentials but got %v", controlPackets[0])
+	}
+	publish, ok := controlPackets[1].(*packets.PublishPacket)
+	if !ok || publish.TopicName != "weather/alerts" || publish.Qos != 1 {
+		t.Fatalf("expected to publish to weather/alerts at QoS 1 but got %v", controlPackets[1])
+	}
+	var alert Alert
+	if err := json.Unmarshal(publish.Payload, &alert); err != nil || alert != testAlert() {
+		t.Fatalf("expected the alert to be published but got %s (%v)", publish.Payload, err)
+	}
+	if _, ok := controlPackets[2].(*packets.DisconnectPacket); !ok {
+		t.Fatalf("expected to disconnect but got %v", controlPackets[2])
+	}
+}
+
+func TestMQTTAlertSink_Concurrent(t *testing.T) {
+	tests := map[string]string{
+		"default client id":    "",
+		"configured client id": "allotment-alerts",
+	}
+	for name, clientID := range tests {
+		t.Run(name, func(t *testing.T) {
+			listener, host, port := listen(t)
+			defer listener.Close()
+			received := serveMQTT(listener)
+
+			sink, err := NewAlertSink(AlertSinkConfig{Type: AlertSinkMQTT, Broker: "tcp://" + net.JoinHostPort(host,
+				strconv.Itoa(port)), Topic: "weather/alerts", ClientID: clientID, QoS: 1})
+			if err != nil {
+				t.Fatalf("failed to create sink: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// Two rules firing on the same observation are delivered at the same time.
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					errs <- sink.Notify(ctx, testAlert())
+				}()
+			}
+			for i := 0; i < 2; i++ {
+				if err := <-errs; err != nil {
+					t.Fatalf("expected both alerts to be published but got %v", err)
+				}
+			}
+
+			// The broker can drop a connection for the next with its client id before reading its disconnect.
+			clientIDs := make(map[string]bool)
+			for i := 0; i < 2; i++ {
+				controlPackets := <-received
+				if len(controlPackets) < 2 {
+					t.Fatalf("expected connect and publish but got %v", controlPackets)
+				}
+				if _, ok := controlPackets[1].(*packets.PublishPacket); !ok {
+					t.Fatalf("expected to publish but got %v", controlPackets[1])
+				}
+				clientIDs[controlPackets[0].(*packets.ConnectPacket).ClientIdentifier] = true
+			}
+			if clientID == "" && len(clientIDs) != 2 {
+				t.Fatalf("expected each connection to have its own client id but got %v", clientIDs)
+			}
+		})
+	}
+}
+
+func TestMQTTAlertSink_Refused(t *testing.T) {
+	listener, host, port := listen(t)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := packets.ReadPacket(conn); err != nil {
+			return
+		}
+		connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		connack.ReturnCode = packets.ErrRefusedNotAuthorised
+		if err := connack.Write(conn); err != nil {
+			return
+		}
+		_, _ = ioutil.ReadAll(conn)
+	}()
+
+	sink, err := NewAlertSink(AlertSinkConfig{Type: AlertSinkMQTT, Broker: "mqtt://" + net.JoinHostPort(host,
+		strconv.Itoa(port)), Topic: "weather/alerts"})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Notify(ctx, testAlert()); !errors.Is(err, packets.ErrorRefusedNotAuthorised) {
+		t.Fatalf("expected the broker to refuse the connection but got %v", err)
+	}
+}
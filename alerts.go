@@ -0,0 +1,571 @@
+package weatherstn
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of alert rule.
+const (
+	// AlertRuleThreshold compares each observed reading with the rule's value.
+	AlertRuleThreshold = "threshold"
+
+	// AlertRuleRate compares how much a reading has changed over the rule's window with the rule's value. Rainfall,
+	// which is observed as the amount fallen in each interval, is instead totalled over the window.
+	AlertRuleRate = "rate"
+)
+
+// Conditions under which an alert rule fires.
+const (
+	AlertAbove = "above"
+	AlertBelow = "below"
+)
+
+// Severities of alerts.
+const (
+	AlertSeverityInfo     = "info"
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
+
+// States of an alert: firing when its rule is breached and resolved once the reading has recovered.
+const (
+	AlertStateFiring   = "firing"
+	AlertStateResolved = "resolved"
+)
+
+const (
+	// DefaultAlertRateWindowSecs is the window of rate rules when none is configured.
+	DefaultAlertRateWindowSecs = 3600
+
+	// DefaultAlertTimeoutSecs bounds how long delivering an alert to a sink can take when no timeout is configured.
+	DefaultAlertTimeoutSecs = 10
+
+	// DefaultAlertMaxAttempts is how many times delivering an alert to a sink is tried when no limit is configured.
+	DefaultAlertMaxAttempts = 5
+)
+
+// alertRetryBackoff is the wait before retrying a failed delivery, which doubles with each retry up to
+// alertRetryMaxBackoff.
+var (
+	alertRetryBackoff    = 2 * time.Second
+	alertRetryMaxBackoff = time.Minute
+)
+
+// alertMetric reads a metric from an observation, sampled returns whether the observation has samples of it.
+type alertMetric struct {
+	value   func(row WeatherDataRow) float64
+	sampled func(row WeatherDataRow) bool
+}
+
+// alertMetrics are the readings which alert rules can be written against, named as for aggregations and exports. An
+// observation without atmospheric samples has no temperature range and one without wind samples has no direction, so
+// that the zeros they are left with aren't mistaken for readings. Rain gauges count tips, so an observation without
+// rain samples is no rain.
+var alertMetrics = map[string]alertMetric{
+	metricTemperature: {value: func(row WeatherDataRow) float64 { return row.AtmosReadings.Temperature },
+		sampled: hasAtmosSamples},
+	metricHumidity: {value: func(row WeatherDataRow) float64 { return row.AtmosReadings.Humidity },
+		sampled: hasAtmosSamples},
+	metricPressure: {value: func(row WeatherDataRow) float64 { return row.AtmosReadings.Pressure },
+		sampled: hasAtmosSamples},
+	metricWindSpeed: {value: func(row WeatherDataRow) float64 { return row.WindReadings.Speed },
+		sampled: hasWindSamples},
+	metricWindGust: {value: func(row WeatherDataRow) float64 { return row.WindReadings.Gust },
+		sampled: hasWindSamples},
+	metricRainfall: {value: func(row WeatherDataRow) float64 { return row.RainReadings.Rainfall },
+		sampled: func(row WeatherDataRow) bool { return true }},
+}
+
+func hasAtmosSamples(row WeatherDataRow) bool {
+	return row.AtmosReadings.TemperatureMin != nil
+}
+
+func hasWindSamples(row WeatherDataRow) bool {
+	return row.WindReadings.Direction >= 0
+}
+
+// AlertsConfig is the set of configuration properties for alerting. Rules are evaluated against each observation and
+// notify the sinks, keyed by name, when they fire and resolve.
+type AlertsConfig struct {
+	Rules       []AlertRuleConfig          `json:"rules"`
+	Sinks       map[string]AlertSinkConfig `json:"sinks"`
+	TimeoutSecs int                        `json:"timeoutSecs"` // defaults to DefaultAlertTimeoutSecs
+	MaxAttempts int                        `json:"maxAttempts"` // defaults to DefaultAlertMaxAttempts
+}
+
+// AlertRuleConfig is the set of configuration properties for a single alert rule. Values are in the metric units that
+// observations are stored in: °C, hPa, km/h and mm.
+type AlertRuleConfig struct {
+	Name       string  `json:"name"`
+	Metric     string  `json:"metric"`     // temperature, humidity, pressure, wind_speed, wind_gust or rainfall
+	Type       string  `json:"type"`       // threshold or rate, defaults to threshold
+	Condition  string  `json:"condition"`  // above or below
+	Value      float64 `json:"value"`      // the reading, or for rate rules its change or total, the rule fires past
+	WindowSecs int     `json:"windowSecs"` // rate rules only, defaults to DefaultAlertRateWindowSecs
+	// Hysteresis is how far the reading has to come back past the value before a firing rule resolves, so that a
+	// reading hovering around the value doesn't repeatedly fire.
+	Hysteresis float64 `json:"hysteresis"`
+	// CooldownSecs is the least time between the rule's notifications that it has fired, a rule which fires again
+	// sooner is only notified once the cooldown has passed and it is still firing.
+	CooldownSecs int      `json:"cooldownSecs"`
+	Severity     string   `json:"severity"` // info, warning or critical, defaults to warning
+	Sinks        []string `json:"sinks"`    // names of the sinks to notify, defaults to all of them
+}
+
+// ruleType returns the type of the rule, falling back to a threshold.
+func (rc AlertRuleConfig) ruleType() string {
+	if rc.Type == "" {
+		return AlertRuleThreshold
+	}
+
+	return rc.Type
+}
+
+// severity returns the severity of the rule, falling back to a warning.
+func (rc AlertRuleConfig) severity() string {
+	if rc.Severity == "" {
+		return AlertSeverityWarning
+	}
+
+	return rc.Severity
+}
+
+// window returns the window of a rate rule, threshold rules only look at the latest observation.
+func (rc AlertRuleConfig) window() int64 {
+	if rc.ruleType() != AlertRuleRate {
+		return 0
+	}
+
+	return int64(defaultInt(rc.WindowSecs, DefaultAlertRateWindowSecs))
+}
+
+// value returns what the rule compares with its value given the observations so far, the last of which is the latest.
+// Observations without samples of the rule's metric are left out, it returns false if the latest is one of them.
+func (rc AlertRuleConfig) value(history []WeatherDataRow) (float64, bool) {
+	metric := alertMetrics[rc.Metric]
+	latest := history[len(history)-1]
+	if !metric.sampled(latest) {
+		return 0, false
+	}
+	if rc.ruleType() != AlertRuleRate {
+		return metric.value(latest), true
+	}
+
+	sampled := make([]WeatherDataRow, 0, len(history))
+	for _, row := range history {
+		if metric.sampled(row) {
+			sampled = append(sampled, row)
+		}
+	}
+
+	// Each observation's rainfall fell during the interval up to its timestamp, so the total excludes the observation
+	// at the start of the window whereas the change is measured from it.
+	from := latest.Timestamp - rc.window()
+	if rc.Metric == metricRainfall {
+		start := sort.Search(len(sampled), func(i int) bool { return sampled[i].Timestamp > from })
+		var total float64
+		for _, row := range sampled[start:] {
+			total += metric.value(row)
+		}
+		return total, true
+	}
+
+	start := sort.Search(len(sampled), func(i int) bool { return sampled[i].Timestamp >= from })
+	return metric.value(latest) - metric.value(sampled[start]), true
+}
+
+// breached returns whether value is past the rule's value.
+func (rc AlertRuleConfig) breached(value float64) bool {
+	if rc.Condition == AlertBelow {
+		return value < rc.Value
+	}
+
+	return value > rc.Value
+}
+
+// recovered returns whether value has come back past the rule's value by at least its hysteresis.
+func (rc AlertRuleConfig) recovered(value float64) bool {
+	if rc.Condition == AlertBelow {
+		return value >= rc.Value+rc.Hysteresis
+	}
+
+	return value <= rc.Value-rc.Hysteresis
+}
+
+// Validate checks that the rules and sinks can be used. All of the problems found are returned together as a
+// *ConfigError.
+func (ac AlertsConfig) Validate() error {
+	var problems configProblems
+	problems.notNegative("timeoutSecs", ac.TimeoutSecs)
+	problems.notNegative("maxAttempts", ac.MaxAttempts)
+
+	names := make(map[string]bool)
+	for i, rule := range ac.Rules {
+		path := fmt.Sprintf("rules[%d]", i)
+		if rule.Name == "" {
+			problems.add("%s.name must be set", path)
+		} else if names[rule.Name] {
+			problems.add("%s: duplicate rule name %s", path, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if _, ok := alertMetrics[rule.Metric]; !ok {
+			problems.add("%s.metric %q is unknown, valid metrics are %s", path, rule.Metric, alertMetricNames())
+		}
+		switch rule.ruleType() {
+		case AlertRuleThreshold:
+			if rule.WindowSecs != 0 {
+				problems.add("%s.windowSecs is only used by %s rules", path, AlertRuleRate)
+			}
+		case AlertRuleRate:
+			problems.notNegative(path+".windowSecs", rule.WindowSecs)
+		default:
+			problems.add("%s.type %q is unknown, valid types are %s and %s", path, rule.Type, AlertRuleThreshold,
+				AlertRuleRate)
+		}
+		if rule.Condition != AlertAbove && rule.Condition != AlertBelow {
+			problems.add("%s.condition must be %s or %s but was %q", path, AlertAbove, AlertBelow, rule.Condition)
+		}
+		if rule.Hysteresis < 0 {
+			problems.add("%s.hysteresis must not be negative but was %g", path, rule.Hysteresis)
+		}
+		problems.notNegative(path+".cooldownSecs", rule.CooldownSecs)
+		switch rule.severity() {
+		case AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical:
+		default:
+			problems.add("%s.severity %q is unknown, valid severities are %s, %s and %s", path, rule.Severity,
+				AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical)
+		}
+		for _, sink := range rule.Sinks {
+			if _, ok := ac.Sinks[sink]; !ok {
+				problems.add("%s.sinks: unknown sink %s", path, sink)
+			}
+		}
+	}
+
+	for name, sink := range ac.Sinks {
+		if err := sink.Validate(); err != nil {
+			problems.addErr("sinks."+name, err)
+		}
+	}
+
+	return problems.err()
+}
+
+// alertMetricNames returns the metrics which rules can use, for error messages.
+func alertMetricNames() string {
+	metrics := make([]string, 0, len(alertMetrics))
+	for metric := range alertMetrics {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	return strings.Join(metrics, ", ")
+}
+
+// Alert is the notification sent to sinks when a rule fires or resolves.
+type Alert struct {
+	Rule       string  `json:"rule"`
+	Severity   string  `json:"severity"`
+	State      string  `json:"state"` // firing or resolved
+	StationID  string  `json:"stationId"`
+	Metric     string  `json:"metric"`
+	Type       string  `json:"type"`
+	Condition  string  `json:"condition"`
+	Value      float64 `json:"value"` // the reading, or for rate rules its change or total over the window
+	Threshold  float64 `json:"threshold"`
+	WindowSecs int64   `json:"windowSecs,omitempty"`
+	Timestamp  int64   `json:"timestamp"` // of the observation which fired or resolved the rule
+}
+
+// Summary describes the alert in a line, e.g. "[critical] wind-safety firing at allotment: wind_gust 72.4 above 60".
+func (a Alert) Summary() string {
+	what := a.Metric
+	if a.Type == AlertRuleRate {
+		what = fmt.Sprintf("%s change over %s", a.Metric, time.Duration(a.WindowSecs)*time.Second)
+		if a.Metric == metricRainfall {
+			what = fmt.Sprintf("%s over %s", a.Metric, time.Duration(a.WindowSecs)*time.Second)
+		}
+	}
+
+	return fmt.Sprintf("[%s] %s %s at %s: %s %.1f %s %g", a.Severity, a.Rule, a.State, a.StationID, what, a.Value,
+		a.Condition, a.Threshold)
+}
+
+// alertRuleState is what an AlertEngine remembers about a rule between observations.
+type alertRuleState struct {
+	firing     bool
+	notified   bool  // whether the current firing has been notified, only notified firings are notified as resolved
+	lastRaised int64 // timestamp of the observation which was last notified as firing, 0 if there hasn't been one
+}
+
+// alertDelivery is an alert along with the sinks it is for.
+type alertDelivery struct {
+	alert Alert
+	sinks []AlertSink
+}
+
+// AlertEngine evaluates alert rules against each observation, keeping the recent observations which rate rules need
+// and whether each rule is firing, and notifies sinks as rules fire and resolve. Its config can be changed while it
+// is in use.
+type AlertEngine struct {
+	lock      sync.Mutex
+	stationID string
+	config    AlertsConfig
+	sinks     map[string]AlertSink
+	states    map[string]*alertRuleState
+	history   []WeatherDataRow
+
+	deliveries sync.WaitGroup
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewAlertEngine creates a new AlertEngine for the station. It returns an error if the config is invalid.
+func NewAlertEngine(config AlertsConfig, stationID string) (*AlertEngine, error) {
+	ae := &AlertEngine{stationID: stationID, states: make(map[string]*alertRuleState), closed: make(chan struct{})}
+	if err := ae.SetConfig(config); err != nil {
+		return nil, err
+	}
+
+	return ae, nil
+}
+
+// SetConfig changes the rules and sinks, taking effect from the next observation. Rules which are unchanged keep
+// whether they are firing and when they were last notified, changed rules start again as not firing. It returns an
+// error, without changing anything, if the config is invalid.
+func (ae *AlertEngine) SetConfig(config AlertsConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	sinks := make(map[string]AlertSink, len(config.Sinks))
+	for name, sinkConfig := range config.Sinks {
+		sink, err := NewAlertSink(sinkConfig)
+		if err != nil {
+			return fmt.Errorf("sink %s: %v", name, err)
+		}
+		sinks[name] = sink
+	}
+
+	ae.lock.Lock()
+	defer ae.lock.Unlock()
+
+	current := make(map[string]AlertRuleConfig, len(ae.config.Rules))
+	for _, rule := range ae.config.Rules {
+		current[rule.Name] = rule
+	}
+	states := make(map[string]*alertRuleState, len(config.Rules))
+	for _, rule := range config.Rules {
+		state, ok := ae.states[rule.Name]
+		if !ok || !reflect.DeepEqual(current[rule.Name], rule) {
+			state = &alertRuleState{}
+		}
+		states[rule.Name] = state
+	}
+
+	ae.config = config
+	ae.sinks = sinks
+	ae.states = states
+
+	return nil
+}
+
+// SetStation changes the station ID that alerts are sent with.
+func (ae *AlertEngine) SetStation(stationID string) {
+	ae.lock.Lock()
+	ae.stationID = stationID
+	ae.lock.Unlock()
+}
+
+// Observe evaluates the rules against an observation and delivers any alerts to their sinks in the background, so
+// that a slow or unreachable sink doesn't hold up the next observation. Failed deliveries are retried with backoff up
+// to the configured number of attempts and logged.
+func (ae *AlertEngine) Observe(row WeatherDataRow) {
+	ae.lock.Lock()
+	deliveries := ae.evaluate(row)
+	timeout := time.Duration(defaultInt(ae.config.TimeoutSecs, DefaultAlertTimeoutSecs)) * time.Second
+	maxAttempts := defaultInt(ae.config.MaxAttempts, DefaultAlertMaxAttempts)
+	ae.lock.Unlock()
+
+	for _, delivery := range deliveries {
+		log.WithField("component", "AlertEngine").
+			WithField("event", "Observe").
+			WithField("rule", delivery.alert.Rule).
+			WithField("state", delivery.alert.State).
+			Warn(delivery.alert.Summary())
+
+		for _, sink := range delivery.sinks {
+			ae.deliveries.Add(1)
+			go func(alert Alert, sink AlertSink) {
+				defer ae.deliveries.Done()
+				ae.deliver(alert, sink, timeout, maxAttempts)
+			}(delivery.alert, sink)
+		}
+	}
+}
+
+// Evaluate evaluates the rules against an observation, which must be later than any evaluated before, and returns the
+// alerts for the rules which have fired or resolved. The alerts aren't delivered, Observe does that.
+func (ae *AlertEngine) Evaluate(row WeatherDataRow) []Alert {
+	ae.lock.Lock()
+	defer ae.lock.Unlock()
+
+	deliveries := ae.evaluate(row)
+	alerts := make([]Alert, len(deliveries))
+	for i, delivery := range deliveries {
+		alerts[i] = delivery.alert
+	}
+
+	return alerts
+}
+
+// deliver notifies the sink of the alert, retrying with backoff until it succeeds, maxAttempts have failed or the
+// engine is closed.
+func (ae *AlertEngine) deliver(alert Alert, sink AlertSink, timeout time.Duration, maxAttempts int) {
+	backoff := alertRetryBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := sink.Notify(ctx, alert)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		entry := log.WithError(err).
+			WithField("component", "AlertEngine").
+			WithField("event", "Observe").
+			WithField("rule", alert.Rule).
+			WithField("sink", sink.String()).
+			WithField("attempt", attempt)
+		if attempt >= maxAttempts {
+			entry.Error("failed to deliver alert, giving up")
+			return
+		}
+		entry.WithField("backoff", backoff.String()).Warn("failed to deliver alert, retrying")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ae.closed:
+			timer.Stop()
+			entry.Error("failed to deliver alert before closing")
+			return
+		}
+
+		backoff *= 2
+		if backoff > alertRetryMaxBackoff {
+			backoff = alertRetryMaxBackoff
+		}
+	}
+}
+
+// Wait waits for the alerts being delivered in the background to be delivered or to fail every attempt.
+func (ae *AlertEngine) Wait() {
+	ae.deliveries.Wait()
+}
+
+// Close stops retrying failed deliveries and waits for those in progress to be delivered or time out.
+func (ae *AlertEngine) Close() {
+	ae.closeOnce.Do(func() {
+		close(ae.closed)
+	})
+	ae.deliveries.Wait()
+}
+
+// evaluate evaluates the rules against the observation, returning the alerts to deliver. ae.lock must be held.
+func (ae *AlertEngine) evaluate(row WeatherDataRow) []alertDelivery {
+	ae.remember(row)
+
+	var deliveries []alertDelivery
+	for _, rule := range ae.config.Rules {
+		state := ae.states[rule.Name]
+		value, ok := rule.value(ae.history)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !state.firing && rule.breached(value):
+			state.firing = true
+			state.notified = false
+		case state.firing && rule.recovered(value):
+			state.firing = false
+			if state.notified {
+				deliveries = append(deliveries, ae.delivery(rule, AlertStateResolved, value, row.Timestamp))
+			}
+			continue
+		}
+
+		cooldown := int64(rule.CooldownSecs)
+		if state.firing && !state.notified && (state.lastRaised == 0 || row.Timestamp-state.lastRaised >= cooldown) {
+			state.notified = true
+			state.lastRaised = row.Timestamp
+			deliveries = append(deliveries, ae.delivery(rule, AlertStateFiring, value, row.Timestamp))
+		}
+	}
+
+	return deliveries
+}
+
+// remember adds the observation to the history, dropping observations older than the longest window of any rule.
+// ae.lock must be held.
+func (ae *AlertEngine) remember(row WeatherDataRow) {
+	var longest int64
+	for _, rule := range ae.config.Rules {
+		if window := rule.window(); window > longest {
+			longest = window
+		}
+	}
+
+	ae.history = append(ae.history, row)
+	from := row.Timestamp - longest
+	start := sort.Search(len(ae.history), func(i int) bool { return ae.history[i].Timestamp >= from })
+	if start > 0 {
+		ae.history = append(ae.history[:0], ae.history[start:]...)
+	}
+}
+
+// delivery returns the alert for the rule changing to state, along with the sinks to notify. ae.lock must be held.
+func (ae *AlertEngine) delivery(rule AlertRuleConfig, state string, value float64, timestamp int64) alertDelivery {
+	var sinks []AlertSink
+	if len(rule.Sinks) == 0 {
+		names := make([]string, 0, len(ae.sinks))
+		for name := range ae.sinks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sinks = append(sinks, ae.sinks[name])
+		}
+	} else {
+		for _, name := range rule.Sinks {
+			sinks = append(sinks, ae.sinks[name])
+		}
+	}
+
+	return alertDelivery{
+		alert: Alert{
+			Rule:       rule.Name,
+			Severity:   rule.severity(),
+			State:      state,
+			StationID:  ae.stationID,
+			Metric:     rule.Metric,
+			Type:       rule.ruleType(),
+			Condition:  rule.Condition,
+			Value:      value,
+			Threshold:  rule.Value,
+			WindowSecs: rule.window(),
+			Timestamp:  timestamp,
+		},
+		sinks: sinks,
+	}
+}
@@ -0,0 +1,244 @@
+package weatherstn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// alertStates returns the rule and state of each alert, e.g. "frost firing".
+func alertStates(alerts []Alert) []string {
+	states := make([]string, len(alerts))
+	for i, alert := range alerts {
+		states[i] = alert.Rule + " " + alert.State
+	}
+
+	return states
+}
+
+// sampledAtmos returns the atmospheric readings of an observation which was sampled, so has a temperature range.
+func sampledAtmos(temperature, pressure float64) AtmoshphericReadings {
+	return AtmoshphericReadings{Temperature: temperature, Pressure: pressure, TemperatureMin: &temperature,
+		TemperatureMax: &temperature}
+}
+
+func TestAlertEngine_Threshold(t *testing.T) {
+	engine, err := NewAlertEngine(AlertsConfig{
+		Rules: []AlertRuleConfig{
+			{Name: "frost", Metric: "temperature", Condition: AlertBelow, Value: 0, Hysteresis: 1, CooldownSecs: 1800,
+				Severity: AlertSeverityCritical},
+			{Name: "gust", Metric: "wind_gust", Condition: AlertAbove, Value: 60},
+		},
+	}, "allotment")
+	if err != nil {
+		t.Fatalf("failed to create alert engine: %v", err)
+	}
+
+	observations := []struct {
+		temperature, gust float64
+		expected          []string
+	}{
+		{temperature: 2, gust: 30},
+		{temperature: -0.5, gust: 65, expected: []string{"frost firing", "gust firing"}},
+		{temperature: -1, gust: 70},
+		{temperature: 0.5, gust: 60, expected: []string{"gust resolved"}}, // within the frost hysteresis
+		{temperature: 1.2, gust: 40, expected: []string{"frost resolved"}},
+		{temperature: -0.2, gust: 40}, // within the frost cooldown
+		{temperature: -0.4, gust: 40},
+		{temperature: -0.6, gust: 40, expected: []string{"frost firing"}}, // the cooldown has passed
+		{temperature: 3, gust: 40, expected: []string{"frost resolved"}},
+		{temperature: -0.2, gust: 40},
+		{temperature: 3, gust: 40}, // the firing during the cooldown wasn't notified so neither is its resolution
+	}
+	for i, observation := range observations {
+		alerts := engine.Evaluate(WeatherDataRow{
+			Timestamp:     1548884100 + int64(i)*300,
+			AtmosReadings: sampledAtmos(observation.temperature, 0),
+			WindReadings:  WindReadings{Gust: observation.gust},
+		})
+		if states := alertStates(alerts); len(states) != len(observation.expected) ||
+			(len(states) > 0 && !reflect.DeepEqual(states, observation.expected)) {
+			t.Fatalf("expected observation %d to raise %v but got %v", i, observation.expected, states)
+		}
+	}
+}
+
+func TestAlertEngine_Rate(t *testing.T) {
+	engine, err := NewAlertEngine(AlertsConfig{
+		Rules: []AlertRuleConfig{
+			{Name: "pressure drop", Metric: "pressure", Type: AlertRuleRate, Condition: AlertBelow, Value: -3,
+				WindowSecs: 3 * 3600},
+			{Name: "heavy rain", Metric: "rainfall", Type: AlertRuleRate, Condition: AlertAbove, Value: 10},
+		},
+	}, "allotment")
+	if err != nil {
+		t.Fatalf("failed to create alert engine: %v", err)
+	}
+
+	// Pressure falls by 0.5 hPa every half hour and then by 1 hPa from the eleventh observation, so by 3.5 hPa over the
+	// 3 hours to it. It rains 6 mm every half hour from the third observation to the eighth.
+	const start, interval = 1548884100, 1800
+	var raised []Alert
+	for i := 0; i < 12; i++ {
+		row := WeatherDataRow{
+			Timestamp:     start + int64(i)*interval,
+			AtmosReadings: sampledAtmos(0, 1015-0.5*float64(i)),
+		}
+		if i > 10 {
+			row.AtmosReadings.Pressure -= 0.5 * float64(i-10)
+		}
+		if i >= 2 && i < 8 {
+			row.RainReadings.Rainfall = 6
+		}
+		raised = append(raised, engine.Evaluate(row)...)
+	}
+
+	expected := []string{"heavy rain firing", "heavy rain resolved", "pressure drop firing"}
+	if states := alertStates(raised); !reflect.DeepEqual(states, expected) {
+		t.Fatalf("expected %v but got %v", expected, states)
+	}
+	observation := func(alert Alert) int64 { return (alert.Timestamp - start) / interval }
+	if observation(raised[0]) != 3 || !closeTo(raised[0].Value, 12) ||
+		raised[0].WindowSecs != DefaultAlertRateWindowSecs {
+		t.Fatalf("expected heavy rain to fire at observation 3 with 12 mm in the hour but got %#v", raised[0])
+	}
+	if observation(raised[1]) != 8 || !closeTo(raised[1].Value, 6) {
+		t.Fatalf("expected heavy rain to resolve at observation 8 with 6 mm in the hour but got %#v", raised[1])
+	}
+	if observation(raised[2]) != 11 || !closeTo(raised[2].Value, -3.5) {
+		t.Fatalf("expected pressure drop to fire at observation 11 with -3.5 hPa but got %#v", raised[2])
+	}
+}
+
+func TestAlertEngine_Dropout(t *testing.T) {
+	engine, err := NewAlertEngine(AlertsConfig{
+		Rules: []AlertRuleConfig{
+			{Name: "cold", Metric: "temperature", Condition: AlertBelow, Value: 2},
+			{Name: "calm", Metric: "wind_speed", Condition: AlertBelow, Value: 1},
+			{Name: "pressure drop", Metric: "pressure", Type: AlertRuleRate, Condition: AlertBelow, Value: -3},
+		},
+	}, "allotment")
+	if err != nil {
+		t.Fatalf("failed to create alert engine: %v", err)
+	}
+
+	// The sensors drop out for the second and third observations, which are left with zero readings and no wind
+	// direction.
+	rows := []WeatherDataRow{
+		{AtmosReadings: sampledAtmos(5, 1015), WindReadings: WindReadings{Speed: 10, Direction: 90}},
+		{WindReadings: WindReadings{Direction: -1}},
+		{WindReadings: WindReadings{Direction: -1}},
+		{AtmosReadings: sampledAtmos(4.5, 1014), WindReadings: WindReadings{Speed: 12, Direction: 180}},
+	}
+	for i, row := range rows {
+		row.Timestamp = 1548884100 + int64(i)*300
+		if alerts := engine.Evaluate(row); len(alerts) != 0 {
+			t.Fatalf("expected observation %d not to raise any alerts but got %v", i, alertStates(alerts))
+		}
+	}
+}
+
+func TestAlertEngine_SetConfig(t *testing.T) {
+	frost := AlertRuleConfig{Name: "frost", Metric: "temperature", Condition: AlertBelow, Value: 0}
+	gust := AlertRuleConfig{Name: "gust", Metric: "wind_gust", Condition: AlertAbove, Value: 60}
+	engine, err := NewAlertEngine(AlertsConfig{Rules: []AlertRuleConfig{frost, gust}}, "allotment")
+	if err != nil {
+		t.Fatalf("failed to create alert engine: %v", err)
+	}
+
+	row := WeatherDataRow{Timestamp: 1548884100, AtmosReadings: sampledAtmos(-1, 0),
+		WindReadings: WindReadings{Gust: 70}}
+	if alerts := engine.Evaluate(row); len(alerts) != 2 {
+		t.Fatalf("expected both rules to fire but got %v", alertStates(alerts))
+	}
+
+	// frost is unchanged so it is still firing, gust has changed so fires again.
+	gust.Value = 65
+	if err := engine.SetConfig(AlertsConfig{Rules: []AlertRuleConfig{frost, gust}}); err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+	engine.SetStation("roof")
+	row.Timestamp += 60
+	alerts := engine.Evaluate(row)
+	if states := alertStates(alerts); !reflect.DeepEqual(states, []string{"gust firing"}) ||
+		alerts[0].StationID != "roof" {
+		t.Fatalf("expected only the changed rule to fire again for roof but got %#v", alerts)
+	}
+
+	if err := engine.SetConfig(AlertsConfig{Rules: []AlertRuleConfig{{Name: "frost"}}}); err == nil {
+		t.Fatal("expected an error setting an invalid config")
+	}
+}
+
+func TestAlertsConfig_Validate(t *testing.T) {
+	valid := func() AlertsConfig {
+		return AlertsConfig{
+			Rules: []AlertRuleConfig{
+				{Name: "frost", Metric: "temperature", Condition: AlertBelow, Value: 0, Hysteresis: 0.5,
+					CooldownSecs: 3600, Severity: AlertSeverityCritical, Sinks: []string{"email"}},
+				{Name: "pressure drop", Metric: "pressure", Type: AlertRuleRate, Condition: AlertBelow, Value: -3,
+					WindowSecs: 10800},
+			},
+			Sinks: map[string]AlertSinkConfig{
+				"email": {Type: AlertSinkSMTP, Host: "mail.example.com", From: "Station <station@example.com>",
+					To: []string{"ops@example.com"}},
+				"hook": {Type: AlertSinkWebhook, URL: "https://hooks.example.com/alerts"},
+				"mqtt": {Type: AlertSinkMQTT, Broker: "ssl://broker.lan", Topic: "weather/alerts", QoS: 1},
+			},
+		}
+	}
+	config := valid()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("unexpected error validating config: %v", err)
+	}
+
+	invalid := map[string]func(config *AlertsConfig){
+		"timeout":          func(config *AlertsConfig) { config.TimeoutSecs = -1 },
+		"max attempts":     func(config *AlertsConfig) { config.MaxAttempts = -1 },
+		"name":             func(config *AlertsConfig) { config.Rules[0].Name = "" },
+		"duplicate name":   func(config *AlertsConfig) { config.Rules[1].Name = "frost" },
+		"metric":           func(config *AlertsConfig) { config.Rules[0].Metric = "visibility" },
+		"type":             func(config *AlertsConfig) { config.Rules[0].Type = "trend" },
+		"threshold window": func(config *AlertsConfig) { config.Rules[0].WindowSecs = 60 },
+		"rate window":      func(config *AlertsConfig) { config.Rules[1].WindowSecs = -1 },
+		"condition":        func(config *AlertsConfig) { config.Rules[0].Condition = "under" },
+		"hysteresis":       func(config *AlertsConfig) { config.Rules[0].Hysteresis = -1 },
+		"cooldown":         func(config *AlertsConfig) { config.Rules[0].CooldownSecs = -1 },
+		"severity":         func(config *AlertsConfig) { config.Rules[0].Severity = "urgent" },
+		"unknown sink":     func(config *AlertsConfig) { config.Rules[0].Sinks = []string{"pager"} },
+		"sink type":        func(config *AlertsConfig) { config.Sinks["hook"] = AlertSinkConfig{Type: "pager"} },
+		"webhook url": func(config *AlertsConfig) {
+			config.Sinks["hook"] = AlertSinkConfig{Type: AlertSinkWebhook, URL: "ftp://hooks.example.com"}
+		},
+		"smtp to": func(config *AlertsConfig) {
+			email := config.Sinks["email"]
+			email.To = []string{"ops"}
+			config.Sinks["email"] = email
+		},
+		"mqtt broker": func(config *AlertsConfig) {
+			mqtt := config.Sinks["mqtt"]
+			mqtt.Broker = "ws://broker.lan"
+			config.Sinks["mqtt"] = mqtt
+		},
+		"mqtt topic": func(config *AlertsConfig) {
+			mqtt := config.Sinks["mqtt"]
+			mqtt.Topic = "weather/#"
+			config.Sinks["mqtt"] = mqtt
+		},
+		"mqtt qos": func(config *AlertsConfig) {
+			mqtt := config.Sinks["mqtt"]
+			mqtt.QoS = 2
+			config.Sinks["mqtt"] = mqtt
+		},
+	}
+	for name, invalidate := range invalid {
+		t.Run(name, func(t *testing.T) {
+			config := valid()
+			invalidate(&config)
+			var configErr *ConfigError
+			if err := config.Validate(); !errors.As(err, &configErr) || len(configErr.Problems) != 1 {
+				t.Fatalf("expected config to have a single problem but got %v", err)
+			}
+		})
+	}
+}
@@ -1,22 +1,60 @@
 package weatherstn
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
 	"github.com/maciej/bme280"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/exp/io/i2c"
 )
 
+// SensorTypeBME280 is the config type of the BME280SensorProvider.
+const SensorTypeBME280 = "bme280"
+
+// Defaults for the BME280SensorProviderConfig.
+const (
+	DefaultBME280I2cAddr      = 0x76
+	DefaultBME280I2cBusDevice = "/dev/i2c-1"
+)
+
+func init() {
+	RegisterSensorType(SensorTypeBME280, func(id string, settings json.RawMessage) (SensorProvider, error) {
+		var config BME280SensorProviderConfig
+		if err := decodeSensorSettings(settings, &config); err != nil {
+			return nil, err
+		}
+
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+
+		return NewBME280SensorProvider(config), nil
+	})
+}
+
+// ErrReadInProgress is returned by the BME280SensorProvider when an earlier read, which may have timed out, has not
+// returned yet.
+var ErrReadInProgress = errors.New("an earlier read is still in progress")
+
 // AtmosphericSensorProvider provides a way to setup and collect atmospheric data readings.
 type AtmosphericSensorProvider interface {
 	SensorProvider
-	Readings() (*AtmoshphericReadings, error)
+	Readings(ctx context.Context) (*AtmoshphericReadings, error)
 }
 
-// AtmoshphericReadings are the sensor readings about measurements such as air temperature.
+// AtmoshphericReadings are the sensor readings about measurements such as air temperature. TemperatureMin and
+// TemperatureMax are the extremes seen across the samples aggregated into an observation, they are nil for single
+// readings from a provider and for observations which were not sampled by the SensorProducer, e.g. imported history.
 type AtmoshphericReadings struct {
-	Temperature float64 `json:"temperature"`
-	Pressure    float64 `json:"pressure"`
-	Humidity    float64 `json:"humidity"`
+	Temperature    float64  `json:"temperature"`
+	Pressure       float64  `json:"pressure"`
+	Humidity       float64  `json:"humidity"`
+	TemperatureMin *float64 `json:"temperatureMin,omitempty"`
+	TemperatureMax *float64 `json:"temperatureMax,omitempty"`
 }
 
 // BME280SensorProvider provides temperature, pressure, and humidity readings using the BME280 chip.
@@ -24,30 +62,115 @@ type BME280SensorProvider struct {
 	i2cAddr int
 	i2cBus  string
 
-	driver *bme280.Driver
+	driver bme280Driver
+	open   func() (bme280Driver, error)
+
+	// lock guards reading and closing. reading is the driver being read, a read which is started after an earlier
+	// one timed out fails rather than waiting to use the device, and a driver disconnected or abandoned while being
+	// read is only closed once the read returns.
+	lock         sync.Mutex
+	reading      bme280Driver
+	pendingClose bme280Driver
+}
+
+// bme280Driver is the part of the bme280.Driver used once connected, so that tests can replace it.
+type bme280Driver interface {
+	Read() (bme280.Response, error)
+	Close() error
 }
 
-// BME280SensorProviderConfig is used for configuring the BME280.
+// BME280SensorProviderConfig is used for configuring the BME280. Zero values use DefaultBME280I2cAddr and
+// DefaultBME280I2cBusDevice.
 type BME280SensorProviderConfig struct {
-	I2cAddr      int    `json:"i2cAddr"`
+	I2cAddr      int    `json:"i2cAddr"` // 118 (0x76) or 119 (0x77)
 	I2cBusDevice string `json:"i2cBusDevice"`
 }
 
+// Validate returns an error if the address is out of range.
+func (c BME280SensorProviderConfig) Validate() error {
+	if c.I2cAddr < 0 || c.I2cAddr > 0x7f {
+		return fmt.Errorf("i2cAddr must be a 7 bit address but was %d", c.I2cAddr)
+	}
+
+	return nil
+}
+
 // NewBME280SensorProvider creates and returns a BME280SensorProvider.
 func NewBME280SensorProvider(config BME280SensorProviderConfig) *BME280SensorProvider {
-	return &BME280SensorProvider{
-		i2cAddr: config.I2cAddr,
-		i2cBus:  config.I2cBusDevice,
+	bus := config.I2cBusDevice
+	if bus == "" {
+		bus = DefaultBME280I2cBusDevice
+	}
+
+	bme := &BME280SensorProvider{
+		i2cAddr: defaultInt(config.I2cAddr, DefaultBME280I2cAddr),
+		i2cBus:  bus,
 	}
+	bme.open = bme.openDriver
+
+	return bme
 }
 
-// Connect initialises the BME280 connection and ensures that readings work correctly.
-func (bme *BME280SensorProvider) Connect() error {
-	device, err := i2c.Open(&i2c.Devfs{Dev: bme.i2cBus}, bme.i2cAddr)
+// Resources returns the I2C address used by the BME280.
+func (bme *BME280SensorProvider) Resources() []string {
+	return []string{fmt.Sprintf("i2c %s 0x%02x", bme.i2cBus, bme.i2cAddr)}
+}
+
+// Connect initialises the BME280 connection and ensures that readings work correctly. The device is left to an
+// earlier read which hasn't returned, and a check read which times out keeps the device until it returns.
+func (bme *BME280SensorProvider) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// A read left hanging from before a reconnect still has the device.
+	bme.lock.Lock()
+	if bme.reading != nil {
+		bme.lock.Unlock()
+		return ErrReadInProgress
+	}
+	bme.lock.Unlock()
+
+	driver, err := bme.open()
 	if err != nil {
 		return err
 	}
 
+	// Check that a read succeeds on the driver
+	bme.lock.Lock()
+	bme.reading = driver
+	bme.lock.Unlock()
+	err = callWithContext(ctx, func() error {
+		_, err := driver.Read()
+		bme.readDone(driver)
+		return err
+	})
+	if err != nil {
+		bme.lock.Lock()
+		if bme.reading == driver {
+			bme.pendingClose = driver
+		} else {
+			closeBME280Driver(driver)
+		}
+		bme.lock.Unlock()
+
+		return err
+	}
+
+	bme.lock.Lock()
+	bme.driver = driver
+	bme.lock.Unlock()
+
+	return nil
+}
+
+// openDriver opens the I2C device and initialises the BME280 on it.
+func (bme *BME280SensorProvider) openDriver() (bme280Driver, error) {
+	device, err := i2c.Open(&i2c.Devfs{Dev: bme.i2cBus}, bme.i2cAddr)
+	if err != nil {
+		return nil, err
+	}
+
 	driver := bme280.New(device)
 
 	// IBM recommended settings for weather stations
@@ -64,29 +187,39 @@ func (bme *BME280SensorProvider) Connect() error {
 				Error("device failed to close")
 		}
 
-		return err
+		return nil, err
 	}
 
-	// Check that a read succeeds on the driver
-	_, err = driver.Read()
-	if err != nil {
-		if driverErr := driver.Close(); driverErr != nil {
-			log.WithError(driverErr).
-				WithField("component", "atmospheric provider").
-				Error("driver failed to close")
-		}
+	return driver, nil
+}
 
-		return err
+// Readings returns the set of AtmoshphericReadings provided by the BME280. ErrReadInProgress is returned without
+// waiting if an earlier read hasn't returned.
+func (bme *BME280SensorProvider) Readings(ctx context.Context) (*AtmoshphericReadings, error) {
+	bme.lock.Lock()
+	if bme.reading != nil {
+		bme.lock.Unlock()
+		return nil, ErrReadInProgress
 	}
+	driver := bme.driver
+	if driver == nil {
+		bme.lock.Unlock()
+		return nil, errors.New("not connected")
+	}
+	bme.reading = driver
+	bme.lock.Unlock()
 
-	bme.driver = driver
-
-	return nil
-}
+	var response bme280.Response
+	err := callWithContext(ctx, func() error {
+		r, err := driver.Read()
+		bme.readDone(driver)
+		if err != nil {
+			return err
+		}
 
-// Readings returns the set of AtmoshphericReadings provided by the BME280.
-func (bme *BME280SensorProvider) Readings() (*AtmoshphericReadings, error) {
-	response, err := bme.driver.Read()
+		response = r
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -98,15 +231,40 @@ func (bme *BME280SensorProvider) Readings() (*AtmoshphericReadings, error) {
 	}, nil
 }
 
-// Disconnect closes the connection to the BME280.
+// readDone allows the next read, closing the driver if it was disconnected during the read.
+func (bme *BME280SensorProvider) readDone(driver bme280Driver) {
+	bme.lock.Lock()
+	defer bme.lock.Unlock()
+
+	bme.reading = nil
+	if bme.pendingClose == driver {
+		bme.pendingClose = nil
+		closeBME280Driver(driver)
+	}
+}
+
+// Disconnect closes the connection to the BME280, or leaves it to be closed when a read in progress returns.
 func (bme *BME280SensorProvider) Disconnect() {
+	bme.lock.Lock()
+	defer bme.lock.Unlock()
+
 	if bme.driver == nil {
 		log.WithField("component", "atmospheric provider").
 			Debug("attempted to disconnect not connected provider")
 		return
 	}
 
-	if driverErr := bme.driver.Close(); driverErr != nil {
+	if bme.reading == bme.driver {
+		bme.pendingClose = bme.driver
+	} else {
+		closeBME280Driver(bme.driver)
+	}
+	bme.driver = nil
+}
+
+// closeBME280Driver closes the driver, logging any error.
+func closeBME280Driver(driver bme280Driver) {
+	if driverErr := driver.Close(); driverErr != nil {
 		log.WithError(driverErr).
 			WithField("component", "atmospheric provider").
 			Error("driver failed to close")
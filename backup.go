@@ -0,0 +1,328 @@
+package weatherstn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// backupStepPages is the number of pages copied by each step of an online backup, the source database is only
+	// locked for the duration of a step so writers can get in between them.
+	backupStepPages = 64
+	backupStepPause = 10 * time.Millisecond
+
+	backupTimeFormat = "20060102T150405Z"
+)
+
+// backupRsyncTimeout bounds how long mirroring the backups with rsync may take, so that an unreachable target doesn't
+// hold up later backups or shutdown.
+var backupRsyncTimeout = 5 * time.Minute
+
+// BackupConfig is the set of configuration properties for setting up database backups.
+type BackupConfig struct {
+	IntervalSecs int      `json:"intervalSecs"` // 0 disables periodic backups
+	Dir          string   `json:"dir"`          // directory that backups are written to
+	Keep         int      `json:"keep"`         // number of backups to keep in each location, 0 keeps all
+	CopyTo       []string `json:"copyTo"`       // further existing directories on other drives, e.g. a USB drive
+	RsyncTarget  string   `json:"rsyncTarget"`  // optional rsync destination that Dir is mirrored to
+}
+
+// SqliteBackuper periodically takes online backups of a SQLite database, verifying each one before rotating it into
+// the configured locations.
+type SqliteBackuper struct {
+	dbPath     string
+	config     BackupConfig
+	now        func() time.Time
+	rsync      string // the rsync command
+	sameDevice func(a, b string) (bool, error)
+}
+
+// NewSqliteBackuper creates a new SqliteBackuper for the database at dbPath.
+func NewSqliteBackuper(dbPath string, config BackupConfig) *SqliteBackuper {
+	return &SqliteBackuper{
+		dbPath:     dbPath,
+		config:     config,
+		now:        time.Now,
+		rsync:      "rsync",
+		sameDevice: sameDevice,
+	}
+}
+
+// Run starts the backup loop, returning once ctx is done. A backup which is in progress is abandoned.
+func (sb *SqliteBackuper) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		path, err := sb.Backup(ctx)
+		if err != nil {
+			log.WithError(err).
+				WithField("component", "SqliteBackuper").
+				WithField("event", "Run").
+				Error("failed to back up database")
+			continue
+		}
+
+		log.WithField("component", "SqliteBackuper").
+			WithField("event", "Run").
+			WithField("path", path).
+			Info("backed up database")
+	}
+}
+
+// Backup takes a single backup of the database, returning the path to it. The backup is checked with
+// integrity_check before being copied to any other locations and rotated. It is abandoned if ctx is done first.
+func (sb *SqliteBackuper) Backup(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(sb.config.Dir, 0700); err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s-%s.db", sb.backupPrefix(), sb.now().UTC().Format(backupTimeFormat))
+	path := filepath.Join(sb.config.Dir, name)
+
+	if err := sb.onlineBackup(ctx, path); err != nil {
+		sb.remove(path)
+		return "", err
+	}
+
+	if err := checkIntegrity(path); err != nil {
+		sb.remove(path)
+		return "", err
+	}
+
+	if err := sb.rotate(sb.config.Dir); err != nil {
+		return "", err
+	}
+
+	// Failures to copy to the other locations are logged rather than failing the backup, the primary copy is fine
+	// and these are often removable drives that may not be mounted.
+	for _, dir := range sb.config.CopyTo {
+		if err := sb.checkCopyDir(dir); err != nil {
+			log.WithError(err).
+				WithField("component", "SqliteBackuper").
+				WithField("dir", dir).
+				Error("not copying backup")
+			continue
+		}
+
+		if err := copyFile(path, filepath.Join(dir, name)); err != nil {
+			log.WithError(err).
+				WithField("component", "SqliteBackuper").
+				WithField("dir", dir).
+				Error("failed to copy backup")
+			continue
+		}
+
+		if err := sb.rotate(dir); err != nil {
+			log.WithError(err).
+				WithField("component", "SqliteBackuper").
+				WithField("dir", dir).
+				Error("failed to rotate backups")
+		}
+	}
+
+	if sb.config.RsyncTarget != "" {
+		rsyncCtx, cancel := context.WithTimeout(ctx, backupRsyncTimeout)
+		out, err := exec.CommandContext(rsyncCtx, sb.rsync, "-a", "--delete", filepath.Clean(sb.config.Dir)+"/",
+			sb.config.RsyncTarget).CombinedOutput()
+		cancel()
+		if err != nil {
+			log.WithError(err).
+				WithField("component", "SqliteBackuper").
+				WithField("target", sb.config.RsyncTarget).
+				WithField("output", string(out)).
+				Error("failed to rsync backups")
+		}
+	}
+
+	return path, nil
+}
+
+// checkCopyDir returns an error unless dir exists on a different filesystem to the backup dir. When a drive isn't
+// mounted its mount point is a directory on the same filesystem, which copying to would fill.
+func (sb *SqliteBackuper) checkCopyDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+
+	same, err := sb.sameDevice(dir, sb.config.Dir)
+	if err != nil {
+		return err
+	}
+	if same {
+		return fmt.Errorf("%s is on the same filesystem as %s, is the drive mounted?", dir, sb.config.Dir)
+	}
+
+	return nil
+}
+
+// sameDevice returns whether the files at a and b are on the same filesystem.
+func sameDevice(a, b string) (bool, error) {
+	device := func(path string) (uint64, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return 0, fmt.Errorf("no device for %s", path)
+		}
+		return uint64(stat.Dev), nil
+	}
+
+	deviceA, err := device(a)
+	if err != nil {
+		return false, err
+	}
+	deviceB, err := device(b)
+	if err != nil {
+		return false, err
+	}
+
+	return deviceA == deviceB, nil
+}
+
+func (sb *SqliteBackuper) backupPrefix() string {
+	return strings.TrimSuffix(filepath.Base(sb.dbPath), filepath.Ext(sb.dbPath))
+}
+
+// onlineBackup copies the database into path using the SQLite backup API, stopping between steps if ctx is done.
+func (sb *SqliteBackuper) onlineBackup(ctx context.Context, path string) error {
+	src, err := sqlx.Open("sqlite3", sb.dbPath)
+	if err != nil {
+		return err
+	}
+	defer closeDB(src)
+
+	conn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.WithError(err).WithField("component", "SqliteBackuper").Error("failed to close connection")
+		}
+	}()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		srcConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("database connection is not a sqlite connection")
+		}
+
+		destDriverConn, err := (&sqlite3.SQLiteDriver{}).Open(path)
+		if err != nil {
+			return err
+		}
+		destConn, ok := destDriverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("backup connection is not a sqlite connection")
+		}
+		defer func() {
+			if err := destConn.Close(); err != nil {
+				log.WithError(err).WithField("component", "SqliteBackuper").Error("failed to close backup")
+			}
+		}()
+
+		backup, err := destConn.Backup("main", srcConn, "main")
+		if err != nil {
+			return err
+		}
+
+		for {
+			done, err := backup.Step(backupStepPages)
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				if closeErr := backup.Close(); closeErr != nil {
+					log.WithError(closeErr).WithField("component", "SqliteBackuper").Error("failed to finish backup")
+				}
+				return err
+			}
+			if done {
+				break
+			}
+			time.Sleep(backupStepPause)
+		}
+
+		return backup.Finish()
+	})
+}
+
+// checkIntegrity runs integrity_check against the database at path.
+func checkIntegrity(path string) error {
+	db, err := sqlx.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	var results []string
+	if err := db.Select(&results, "PRAGMA integrity_check;"); err != nil {
+		return err
+	}
+
+	if len(results) != 1 || results[0] != "ok" {
+		return fmt.Errorf("integrity check of %s failed: %s", path, strings.Join(results, "; "))
+	}
+
+	return nil
+}
+
+// rotate removes the oldest backups in dir so that only the configured number remain.
+func (sb *SqliteBackuper) rotate(dir string) error {
+	if sb.config.Keep <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, sb.backupPrefix()+"-*.db"))
+	if err != nil {
+		return err
+	}
+
+	// The timestamp format sorts lexically.
+	sort.Strings(backups)
+	for len(backups) > sb.config.Keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+func (sb *SqliteBackuper) remove(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).
+			WithField("component", "SqliteBackuper").
+			WithField("path", path).
+			Error("failed to remove failed backup")
+	}
+}
+
+func closeDB(db *sqlx.DB) {
+	if err := db.Close(); err != nil {
+		log.WithError(err).Error("failed to close database")
+	}
+}
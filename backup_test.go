@@ -0,0 +1,196 @@
+package weatherstn
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// newBackupDatabase creates a database in dir holding the conformance dataset, returning its path and the dataset.
+func newBackupDatabase(t *testing.T, dir string) (string, []WeatherDataRow) {
+	dbPath := filepath.Join(dir, "weather.db")
+	migrator, err := NewMigrator(DatabaseConfig{Path: dbPath})
+	if err != nil {
+		t.Fatalf("unexpected error creating migrator: %v", err)
+	}
+	if err := migrator.EnsureLatest(); err != nil {
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+	migrator.Close()
+
+	db, err := sqlx.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %v", err)
+	}
+	defer db.Close()
+
+	store := NewSqliteDataStore(db)
+	dataset := loadConformanceDataset(t)
+	if _, err := store.WriteBatch(context.Background(), dataset, false); err != nil {
+		t.Fatalf("failed to write to data store: %v", err)
+	}
+
+	return dbPath, dataset
+}
+
+func TestSqliteBackuper_Backup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath, dataset := newBackupDatabase(t, dir)
+
+	// usb is a mounted drive and unmounted the mount point of a drive which isn't mounted, on the same filesystem.
+	usb, unmounted := filepath.Join(dir, "usb"), filepath.Join(dir, "unmounted")
+	for _, d := range []string{usb, unmounted} {
+		if err := os.Mkdir(d, 0700); err != nil {
+			t.Fatalf("unexpected error creating dir: %v", err)
+		}
+	}
+
+	config := BackupConfig{
+		Dir:    filepath.Join(dir, "backups"),
+		Keep:   2,
+		CopyTo: []string{usb, unmounted, filepath.Join(dir, "missing")},
+	}
+	backuper := NewSqliteBackuper(dbPath, config)
+	backuper.sameDevice = func(a, b string) (bool, error) {
+		return a != usb, nil
+	}
+
+	now := time.Date(2020, 1, 30, 12, 0, 0, 0, time.UTC)
+	backuper.now = func() time.Time {
+		now = now.Add(time.Hour)
+		return now
+	}
+
+	var path string
+	for i := 0; i < 3; i++ {
+		path, err = backuper.Backup(context.Background())
+		if err != nil {
+			t.Fatalf("failed to back up: %v", err)
+		}
+	}
+
+	if filepath.Base(path) != "weather-20200130T150000Z.db" {
+		t.Fatalf("unexpected backup name %s", path)
+	}
+
+	for _, d := range []string{config.Dir, usb} {
+		backups, err := filepath.Glob(filepath.Join(d, "weather-*.db"))
+		if err != nil {
+			t.Fatalf("unexpected error listing backups: %v", err)
+		}
+
+		if len(backups) != 2 || filepath.Base(backups[0]) != "weather-20200130T140000Z.db" {
+			t.Fatalf("expected the 2 newest backups to be kept in %s but had %v", d, backups)
+		}
+	}
+
+	if backups, err := ioutil.ReadDir(unmounted); err != nil || len(backups) != 0 {
+		t.Fatalf("expected no backups to be copied to an unmounted drive but got %d (%v)", len(backups), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing copy dir not to be created but got %v", err)
+	}
+
+	backupDB, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("unexpected error opening backup: %v", err)
+	}
+	defer backupDB.Close()
+
+	rows, err := NewSqliteDataStore(backupDB).ReadUnpublished(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read backup: %v", err)
+	}
+
+	assertRows(t, rows, dataset)
+}
+
+func TestSqliteBackuper_BackupCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbPath, _ := newBackupDatabase(t, dir)
+	config := BackupConfig{Dir: filepath.Join(dir, "backups")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewSqliteBackuper(dbPath, config).Backup(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the backup to be abandoned but got %v", err)
+	}
+	if backups, err := ioutil.ReadDir(config.Dir); err != nil || len(backups) != 0 {
+		t.Fatalf("expected the abandoned backup to be removed but got %d (%v)", len(backups), err)
+	}
+}
+
+func TestSqliteBackuper_BackupRsyncHung(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsync := filepath.Join(dir, "rsync")
+	if err := ioutil.WriteFile(rsync, []byte("#!/bin/sh\nexec sleep 60\n"), 0700); err != nil {
+		t.Fatalf("unexpected error writing rsync: %v", err)
+	}
+	defer func(timeout time.Duration) { backupRsyncTimeout = timeout }(backupRsyncTimeout)
+	backupRsyncTimeout = 100 * time.Millisecond
+
+	dbPath, _ := newBackupDatabase(t, dir)
+	backuper := NewSqliteBackuper(dbPath, BackupConfig{Dir: filepath.Join(dir, "backups"), RsyncTarget: "nas:backups"})
+	backuper.rsync = rsync
+
+	start := time.Now()
+	if _, err := backuper.Backup(context.Background()); err != nil {
+		t.Fatalf("expected the backup to succeed without the rsync but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the hung rsync to be stopped but the backup took %s", elapsed)
+	}
+}
+
+func TestSameDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if same, err := sameDevice(dir, filepath.Join(dir, ".")); err != nil || !same {
+		t.Fatalf("expected a directory to be on its own filesystem but got %t (%v)", same, err)
+	}
+	if _, err := sameDevice(dir, filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
+
+func TestCheckIntegrity_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "corrupt.db")
+	if err := ioutil.WriteFile(path, []byte("SQLite format 3\x00 but not really"), 0600); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	if err := checkIntegrity(path); err == nil {
+		t.Fatalf("expected integrity check to fail")
+	}
+}
@@ -0,0 +1,81 @@
+package weatherstn
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CalibrationConfig corrects the readings of a single sensor for known errors. Offsets are added to readings and
+// factors multiply them, a zero factor is treated as 1 so that an empty config leaves readings unchanged.
+type CalibrationConfig struct {
+	TemperatureOffset float64 `json:"temperatureOffset"` // °C
+	HumidityOffset    float64 `json:"humidityOffset"`    // %
+	PressureOffset    float64 `json:"pressureOffset"`    // hPa
+	WindSpeedFactor   float64 `json:"windSpeedFactor"`   // applied to both speed and gust
+	RainfallFactor    float64 `json:"rainfallFactor"`
+}
+
+// Validate returns an error if any of the factors are negative.
+func (cc CalibrationConfig) Validate() error {
+	if cc.WindSpeedFactor < 0 {
+		return fmt.Errorf("windSpeedFactor must not be negative but was %f", cc.WindSpeedFactor)
+	}
+	if cc.RainfallFactor < 0 {
+		return fmt.Errorf("rainfallFactor must not be negative but was %f", cc.RainfallFactor)
+	}
+
+	return nil
+}
+
+func calibrationFactor(factor float64) float64 {
+	if factor == 0 {
+		return 1
+	}
+
+	return factor
+}
+
+// calibration holds the calibration of a sensor, which can be changed while the sensor is being sampled.
+type calibration struct {
+	config CalibrationConfig
+	lock   sync.Mutex
+}
+
+func (c *calibration) set(config CalibrationConfig) {
+	c.lock.Lock()
+	c.config = config
+	c.lock.Unlock()
+}
+
+func (c *calibration) get() CalibrationConfig {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.config
+}
+
+func (c *calibration) atmos(readings *AtmoshphericReadings) *AtmoshphericReadings {
+	config := c.get()
+	calibrated := *readings
+	calibrated.Temperature += config.TemperatureOffset
+	calibrated.Humidity += config.HumidityOffset
+	calibrated.Pressure += config.PressureOffset
+
+	return &calibrated
+}
+
+func (c *calibration) wind(readings *WindReadings) *WindReadings {
+	factor := calibrationFactor(c.get().WindSpeedFactor)
+	calibrated := *readings
+	calibrated.Speed *= factor
+	calibrated.Gust *= factor
+
+	return &calibrated
+}
+
+func (c *calibration) rain(readings *RainReadings) *RainReadings {
+	calibrated := *readings
+	calibrated.Rainfall *= calibrationFactor(c.get().RainfallFactor)
+
+	return &calibrated
+}
@@ -0,0 +1,92 @@
+package weatherstn
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestSensorProducer_Calibrate(t *testing.T) {
+	atmos := &fakeAtmosProvider{readings: []AtmoshphericReadings{{Temperature: 10, Humidity: 50, Pressure: 1000}}}
+	wind := &fakeWindProvider{readings: []WindReadings{{Speed: 10, Gust: 20, Direction: 90}}}
+	rain := &fakeRainProvider{readings: []RainReadings{{Rainfall: 2}}}
+	producer, err := NewSensorProducer([]Sensor{
+		{ID: "screen", Provider: atmos},
+		{ID: "mast", Provider: wind},
+		{ID: "gauge", Provider: rain},
+	}, NewMemoryDataStore(), nil, SamplingConfig{}, SupervisorConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating producer: %v", err)
+	}
+
+	err = producer.Calibrate(map[string]CalibrationConfig{
+		"screen": {TemperatureOffset: -0.5, HumidityOffset: 2, PressureOffset: 1.5},
+		"mast":   {WindSpeedFactor: 1.1},
+		"gauge":  {RainfallFactor: 0.5},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error calibrating: %v", err)
+	}
+
+	for _, ps := range producer.sensors {
+		ps.sample(context.Background())
+	}
+	atmosReadings, windReadings, rainReadings, _ := producer.observe()
+
+	expected := []struct {
+		name             string
+		actual, expected float64
+	}{
+		{"temperature", atmosReadings.Temperature, 9.5},
+		{"humidity", atmosReadings.Humidity, 52},
+		{"pressure", atmosReadings.Pressure, 1001.5},
+		{"wind speed", windReadings.Speed, 11},
+		{"wind gust", windReadings.Gust, 22},
+		{"wind direction", float64(windReadings.Direction), 90},
+		{"rainfall", rainReadings.Rainfall, 1},
+	}
+	for _, e := range expected {
+		if math.Abs(e.actual-e.expected) > 1e-9 {
+			t.Fatalf("expected calibrated %s to be %f but was %f", e.name, e.expected, e.actual)
+		}
+	}
+
+	// Removing the calibration returns readings to their raw values.
+	if err := producer.Calibrate(nil); err != nil {
+		t.Fatalf("unexpected error calibrating: %v", err)
+	}
+	for _, ps := range producer.sensors {
+		ps.sample(context.Background())
+	}
+	atmosReadings, _, _, _ = producer.observe()
+	if atmosReadings.Temperature != 10 {
+		t.Fatalf("expected uncalibrated temperature to be 10 but was %f", atmosReadings.Temperature)
+	}
+}
+
+func TestSensorProducer_CalibrateInvalid(t *testing.T) {
+	producer, err := NewSensorProducer([]Sensor{{ID: "screen", Provider: &fakeAtmosProvider{}}},
+		NewMemoryDataStore(), nil, SamplingConfig{}, SupervisorConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating producer: %v", err)
+	}
+
+	if err := producer.Calibrate(map[string]CalibrationConfig{"screen": {TemperatureOffset: 1}}); err != nil {
+		t.Fatalf("unexpected error calibrating: %v", err)
+	}
+
+	invalid := []map[string]CalibrationConfig{
+		{"screen": {TemperatureOffset: 2}, "ground": {TemperatureOffset: 1}},
+		{"screen": {WindSpeedFactor: -1}},
+		{"screen": {RainfallFactor: -1}},
+	}
+	for _, calibrations := range invalid {
+		if err := producer.Calibrate(calibrations); err == nil {
+			t.Fatalf("expected calibration %#v to be rejected", calibrations)
+		}
+	}
+
+	if offset := producer.sensors[0].calibration.get().TemperatureOffset; offset != 1 {
+		t.Fatalf("expected rejected calibrations to leave the existing one in place but offset was %f", offset)
+	}
+}
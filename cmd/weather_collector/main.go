@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// shutdownTimeout bounds how long shutdown waits for requests in progress to finish.
+	shutdownTimeout = 20 * time.Second
+
+	// fleetPrefix is the path the fleet API is served under.
+	fleetPrefix = "/fleet/"
+
+	// readHeaderTimeout bounds how long a station may take to send its request headers.
+	readHeaderTimeout = 10 * time.Second
+)
+
+func init() {
+	log.SetFormatter(&log.JSONFormatter{})
+	log.SetOutput(os.Stdout)
+
+	// Log everything until the config has been read, its logLevel then takes over.
+	log.SetLevel(log.DebugLevel)
+}
+
+func main() {
+	configPath := flag.String("config", "collector.json", "path to the config file")
+	flag.Parse()
+
+	config := weatherstn.NewCollectorConfig(*configPath)
+	if err := config.Parse(); err != nil {
+		log.WithError(err).Panic("failed to parse config")
+	}
+	if err := config.Validate(); err != nil {
+		log.WithError(err).Panic("invalid config")
+	}
+	level, err := config.Level()
+	if err != nil {
+		log.WithError(err).Panic("invalid config")
+	}
+	log.SetLevel(level)
+
+	datastore, err := weatherstn.OpenDataStore(config.DatabaseConfig)
+	if err != nil {
+		log.WithError(err).Panic("failed to connect to datastore")
+	}
+	store, ok := datastore.(weatherstn.CollectorStore)
+	if !ok {
+		log.WithField("driver", config.DatabaseConfig.DriverName()).Panic("database can't store many stations")
+	}
+
+	path := config.Path
+	if path == "" {
+		path = weatherstn.DefaultCollectorPath
+	}
+	listen := config.Listen
+	if listen == "" {
+		listen = weatherstn.DefaultCollectorListen
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, weatherstn.NewCollectorHandler(store, *config))
+	mux.Handle(fleetPrefix, weatherstn.NewFleetHandler(store, *config, fleetPrefix))
+	server := &http.Server{Addr: listen, Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Fatal("collector server failed")
+		}
+	}()
+	log.WithField("listen", listen).WithField("path", path).Info("collecting observations")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	signal.Stop(signals)
+	log.WithField("signal", sig.String()).Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("failed to shut down collector server")
+		return
+	}
+	fmt.Println("Graceful shutdown completed")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func runBackup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path to the config file")
+	if err := flags.Parse(args); err != nil {
+		log.WithError(err).Fatal("failed to parse flags")
+	}
+
+	config := weatherstn.NewAppConfig(*configPath)
+	if err := config.Parse(); err != nil {
+		log.WithError(err).Fatal("failed to parse config")
+	}
+
+	if config.DatabaseConfig.DriverName() != weatherstn.DatabaseDriverSqlite {
+		log.Fatal("backups are only supported for sqlite databases")
+	}
+
+	path, err := weatherstn.NewSqliteBackuper(config.DatabaseConfig.Path, config.BackupConfig).Backup(context.Background())
+	if err != nil {
+		log.WithError(err).Fatal("failed to back up database")
+	}
+
+	log.WithField("path", path).Info("backed up database")
+}
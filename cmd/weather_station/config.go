@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		log.Fatal("usage: weather_station config check [-config path]")
+	}
+
+	flags := flag.NewFlagSet("config check", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path to the config file")
+	if err := flags.Parse(args[1:]); err != nil {
+		log.WithError(err).Fatal("failed to parse flags")
+	}
+
+	problems, err := checkConfig(*configPath)
+	if err != nil {
+		log.WithError(err).Fatal("failed to read config")
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("%s is valid\n", *configPath)
+		return
+	}
+
+	fmt.Printf("%s is invalid:\n", *configPath)
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	os.Exit(1)
+}
+
+// checkConfig returns every problem found with the config at path, both unknown fields and invalid values. An error is
+// only returned if the config couldn't be read at all.
+func checkConfig(path string) ([]string, error) {
+	config := weatherstn.NewAppConfig(path)
+
+	var problems []string
+	var configErr *weatherstn.ConfigError
+	if err := config.Parse(); err != nil {
+		if !errors.As(err, &configErr) {
+			return nil, err
+		}
+		problems = append(problems, configErr.Problems...)
+	}
+
+	if err := config.Validate(); err != nil {
+		if !errors.As(err, &configErr) {
+			return nil, err
+		}
+		problems = append(problems, configErr.Problems...)
+	}
+
+	return problems, nil
+}
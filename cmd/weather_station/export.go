@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var exportTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+func runExport(args []string) {
+	// Output may be written to stdout so keep logs out of the way.
+	log.SetOutput(os.Stderr)
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path to the config file")
+	from := flags.String("from", "", "start of the time range (inclusive), RFC3339 or date, defaults to 24 hours ago")
+	to := flags.String("to", "", "end of the time range (exclusive), RFC3339 or date, defaults to now")
+	format := flags.String("format", string(weatherstn.ExportFormatCSV), "output format: csv, jsonl or parquet")
+	fields := flags.String("fields", "", "comma separated fields to export, defaults to all of: "+
+		strings.Join(weatherstn.ExportFields(), ","))
+	units := flags.String("units", weatherstn.UnitSystemMetric,
+		"units: metric or imperial, optionally followed by overrides such as ,speed=knots,pressure=mmHg")
+	tz := flags.String("tz", "Local", "timezone used for times without an offset and for the time field")
+	out := flags.String("out", "-", "file to write to, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		log.WithError(err).Fatal("failed to parse flags")
+	}
+
+	if err := doExport(*configPath, *from, *to, *format, *fields, *units, *tz, *out); err != nil {
+		log.WithError(err).Fatal("failed to export observations")
+	}
+}
+
+func doExport(configPath, fromArg, toArg, formatArg, fieldsArg, unitsArg, tzArg, out string) error {
+	loc, err := time.LoadLocation(tzArg)
+	if err != nil {
+		return err
+	}
+
+	format, err := weatherstn.ParseExportFormat(formatArg)
+	if err != nil {
+		return err
+	}
+
+	units, err := weatherstn.ParseUnits(unitsArg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fromTime, err := parseExportTime(fromArg, loc, now.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	toTime, err := parseExportTime(toArg, loc, now)
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	if fieldsArg != "" {
+		fields = strings.Split(fieldsArg, ",")
+	}
+
+	config := weatherstn.NewAppConfig(configPath)
+	if err := config.Parse(); err != nil {
+		return err
+	}
+
+	datastore, err := weatherstn.OpenDataStore(config.DatabaseConfig)
+	if err != nil {
+		return err
+	}
+
+	rows, err := datastore.ReadRange(context.Background(), fromTime.Unix(), toTime.Unix())
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.WithError(err).Error("failed to close export file")
+			}
+		}()
+		w = f
+	}
+
+	return weatherstn.Export(w, format, rows, weatherstn.ExportOptions{
+		Fields:   fields,
+		Units:    units,
+		Location: loc,
+	})
+}
+
+func parseExportTime(value string, loc *time.Location, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+
+	for _, layout := range exportTimeLayouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised time %s, expected RFC3339 or YYYY-MM-DD", value)
+}
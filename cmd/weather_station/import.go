@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := flags.String("config", "config.json", "path to the config file")
+	format := flags.String("format", "", "format of the files being imported: cumulus, weewx or weatherlink")
+	publish := flags.Bool("publish", false, "send the imported observations upstream, by default they are "+
+		"stored as already published")
+	tz := flags.String("tz", "Local", "timezone of the times in cumulus and weatherlink files")
+	units := weatherstn.DefaultImportUnits()
+	flags.StringVar(&units.Temperature, "temperature-unit", units.Temperature, "cumulus temperature unit: C or F")
+	flags.StringVar(&units.Pressure, "pressure-unit", units.Pressure, "cumulus pressure unit: hPa, mbar, inHg or mmHg")
+	flags.StringVar(&units.Speed, "wind-unit", units.Speed, "cumulus wind speed unit: km/h, m/s, mph or knots")
+	flags.StringVar(&units.Rainfall, "rain-unit", units.Rainfall, "cumulus rainfall unit: mm or in")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import -format <format> [flags] file...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		log.WithError(err).Fatal("failed to parse flags")
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := doImport(*configPath, *format, *tz, units, *publish, flags.Args()); err != nil {
+		log.WithError(err).Fatal("failed to import observations")
+	}
+}
+
+func doImport(configPath, format, tz string, units weatherstn.ImportUnits, publish bool, paths []string) error {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return err
+	}
+
+	config := weatherstn.NewAppConfig(configPath)
+	if err := config.Parse(); err != nil {
+		return err
+	}
+
+	datastore, err := weatherstn.OpenDataStore(config.DatabaseConfig)
+	if err != nil {
+		return err
+	}
+
+	// Each file is read and written separately so that memory use is bounded by the largest file rather than the
+	// whole history.
+	for _, path := range paths {
+		source, closeSource, err := openImportSource(format, path, units, loc)
+		if err != nil {
+			return err
+		}
+
+		read, written, err := weatherstn.Import(context.Background(), datastore, source, publish)
+		closeSource()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		log.WithField("file", path).
+			WithField("read", read).
+			WithField("written", written).
+			WithField("duplicates", read-written).
+			Info("imported observations")
+	}
+
+	return nil
+}
+
+func openImportSource(format, path string, units weatherstn.ImportUnits,
+	loc *time.Location) (weatherstn.ImportSource, func(), error) {
+	format = strings.ToLower(format)
+	switch format {
+	case "weewx":
+		db, err := sqlx.Open("sqlite3", path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return weatherstn.NewWeeWXSource(db), closer(path, db), nil
+	case "cumulus", "weatherlink":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var source weatherstn.ImportSource = weatherstn.NewCumulusLogSource(f, units, loc)
+		if format == "weatherlink" {
+			source = weatherstn.NewWeatherLinkCSVSource(f, loc)
+		}
+
+		return source, closer(path, f), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown import format %s", format)
+	}
+}
+
+func closer(path string, c io.Closer) func() {
+	return func() {
+		if err := c.Close(); err != nil {
+			log.WithError(err).WithField("file", path).Error("failed to close import file")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chvck/weatherstn"
+)
+
+func TestOpenImportSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "export.csv")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("unexpected error writing export: %v", err)
+	}
+
+	formats := map[string]string{
+		"cumulus":     "*weatherstn.CumulusLogSource",
+		"Cumulus":     "*weatherstn.CumulusLogSource",
+		"weatherlink": "*weatherstn.WeatherLinkCSVSource",
+		"WeatherLink": "*weatherstn.WeatherLinkCSVSource",
+		"WeeWX":       "*weatherstn.WeeWXSource",
+	}
+	for format, expected := range formats {
+		t.Run(format, func(t *testing.T) {
+			source, closeSource, err := openImportSource(format, path, weatherstn.DefaultImportUnits(), time.UTC)
+			if err != nil {
+				t.Fatalf("unexpected error opening source: %v", err)
+			}
+			defer closeSource()
+
+			if actual := fmt.Sprintf("%T", source); actual != expected {
+				t.Fatalf("expected format %s to be read by %s but got %s", format, expected, actual)
+			}
+		})
+	}
+
+	if _, _, err := openImportSource("csv", path, weatherstn.DefaultImportUnits(), time.UTC); err == nil {
+		t.Fatal("expected an error opening an unknown format")
+	}
+}
@@ -1,18 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/chvck/weatherstn"
@@ -21,8 +20,11 @@ import (
 )
 
 const (
-	readInterval    = 30 * time.Second
-	publishInterval = 60 * time.Second
+	// shutdownTimeout bounds how long shutdown waits for the producer, publisher, backuper and alerts to finish.
+	shutdownTimeout = 20 * time.Second
+
+	// readyPollInterval is how often startup checks whether the producer is running before notifying systemd.
+	readyPollInterval = 100 * time.Millisecond
 )
 
 func init() {
@@ -33,13 +35,34 @@ func init() {
 	// Can be any io.Writer, see below for File example
 	log.SetOutput(os.Stdout)
 
-	// Only log the warning severity or above.
+	// Log everything until the config has been read, its logLevel then takes over.
 	log.SetLevel(log.DebugLevel)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "publish":
+			runPublish(os.Args[2:])
+			return
+		}
+	}
+
 	migrations := flag.Int("migrations", 0, "specifies to run n migrations (can be negative) and then exit")
-	migrateAll := flag.Bool("migrateall", false, "specifies to run all migrations and then exit")
+	migrateAll := flag.Bool("migrateall", false, "specifies to run all migrations and then exit, pending "+
+		"migrations are otherwise applied automatically on startup")
 	configPath := flag.String("config", "config.json", "path to the config file")
 	flag.Parse()
 
@@ -47,108 +70,242 @@ func main() {
 	if err := config.Parse(); err != nil {
 		log.WithError(err).Panic("failed to parse config")
 	}
+	if err := config.Validate(); err != nil {
+		log.WithError(err).Panic("invalid config")
+	}
+	level, err := config.Level()
+	if err != nil {
+		log.WithError(err).Panic("invalid config")
+	}
+	log.SetLevel(level)
 
-	log.Info(fmt.Sprintf("Running with config: %#v", config))
+	log.Info(fmt.Sprintf("Running with config: %#v", config.Redacted()))
 
 	if *migrations != 0 || *migrateAll {
 		if *migrations != 0 && *migrateAll {
 			panic("migrations and migrateall cannot be run together")
 		}
-		if err := doMigrate(config.DatabaseConfig.Path, config.DatabaseConfig.Migrations, *migrations,
-			*migrateAll); err != nil {
+		if err := doMigrate(config.DatabaseConfig, *migrations, *migrateAll); err != nil {
 			log.WithError(err).Panic("failed to perform migrations")
 		}
 
 		return
 	}
 
-	atmosProvider := weatherstn.NewBME280SensorProvider(weatherstn.BME280SensorProviderConfig{
-		I2cAddr:      config.ProducerConfig.Atmos.I2cAddr,
-		I2cBusDevice: config.ProducerConfig.Atmos.I2cBusDevice,
-	})
-	if err := atmosProvider.Connect(); err != nil {
-		log.WithError(err).Panic("failed to connect to atmospherics provider")
+	sensorConfigs, err := config.ProducerConfig.SensorConfigs()
+	if err != nil {
+		log.WithError(err).Panic("failed to read sensor config")
 	}
 
-	windProvider := weatherstn.NewSEN08942WindSensorProvider(weatherstn.SEN08942WindSensorProviderConfig{
-		AnemPinNumber:     config.ProducerConfig.Wind.AnemPinNumber,
-		AnemInterval:      config.ProducerConfig.Wind.AnemInterval,
-		VaneCSPinNumber:   config.ProducerConfig.Wind.VaneCSPinNumber,
-		VaneDOutPinNumber: config.ProducerConfig.Wind.VaneDOutPinNumber,
-		VaneDInPinNumber:  config.ProducerConfig.Wind.VaneDInPinNumber,
-		VaneClkPinNumber:  config.ProducerConfig.Wind.VaneClkPinNumber,
-		VaneChannel:       config.ProducerConfig.Wind.VaneChannel,
-	})
-	if err := windProvider.Connect(); err != nil {
-		log.WithError(err).Panic("failed to connect to wind provider")
+	sensors, err := weatherstn.NewSensors(sensorConfigs)
+	if err != nil {
+		log.WithError(err).Panic("failed to create sensors")
 	}
 
-	rainProvider := weatherstn.NewSEN08942RainSensorProvider(weatherstn.SEN08942RainSensorProviderConfig{
-		PinNumber: config.ProducerConfig.Rain.PinNumber,
-		Interval:  config.ProducerConfig.Rain.Interval,
-	})
-	if err := rainProvider.Connect(); err != nil {
-		log.WithError(err).Panic("failed to connect to rain provider")
+	datastore, err := weatherstn.OpenDataStore(config.DatabaseConfig)
+	if err != nil {
+		log.WithError(err).Panic("failed to connect to datastore")
 	}
 
-	db, err := sqlx.Open("sqlite3", config.DatabaseConfig.Path)
+	spool, err := weatherstn.NewSpool(config.ProducerConfig.Spool)
 	if err != nil {
-		log.WithError(err).Panic("failed to connect to datastore")
+		log.WithError(err).Panic("failed to load spool")
 	}
 
-	datastore := weatherstn.NewSqliteDataStore(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	var wg sync.WaitGroup
 
-	producer := weatherstn.NewSensorProducer(atmosProvider, windProvider, rainProvider, datastore)
+	producer, err := weatherstn.NewSensorProducer(sensors, datastore, spool, config.ProducerConfig.Sampling,
+		config.ProducerConfig.Supervisor)
+	if err != nil {
+		log.WithError(err).Panic("failed to create producer")
+	}
+	if err := producer.Calibrate(config.ProducerConfig.Calibration); err != nil {
+		log.WithError(err).Panic("failed to calibrate sensors")
+	}
+
+	station, err := config.StationConfig.Info()
+	if err != nil {
+		log.WithError(err).Panic("failed to identify station")
+	}
+
+	alerts, err := weatherstn.NewAlertEngine(config.AlertsConfig, station.ID)
+	if err != nil {
+		log.WithError(err).Panic("failed to create alerts")
+	}
+	producer.SetObserver(alerts.Observe)
+	wg.Add(1)
 	go func() {
-		producer.Run(time.Duration(config.ProducerConfig.PollIntervalSecs) * time.Second)
+		defer wg.Done()
+		producer.Run(ctx, config.ProducerConfig.PollInterval())
 	}()
-	wg.Add(1)
 
-	publisher := weatherstn.NewPublisher(datastore, config.PublisherConfig.EndpointConfig, &http.Client{})
+	publisher := weatherstn.NewPublisher(datastore, station, config.PublisherConfig.EndpointConfig,
+		weatherstn.NewPublisherHTTPClient(), time.Duration(config.PublisherConfig.RequestTimeoutSecs)*time.Second)
+	publisher.SetPublishLogKeepDays(config.PublisherConfig.PublishLogKeepDays)
+	wg.Add(1)
 	go func() {
-		publisher.Run(time.Duration(config.PublisherConfig.PushIntervalSecs) * time.Second)
+		defer wg.Done()
+		publisher.Run(ctx, config.PublisherConfig.PushInterval())
 	}()
-	wg.Add(1)
 
-	stopSig := make(chan os.Signal, 1)
-	signal.Notify(stopSig, os.Interrupt)
-	defer signal.Stop(stopSig)
+	if config.BackupConfig.IntervalSecs > 0 {
+		if config.DatabaseConfig.DriverName() == weatherstn.DatabaseDriverSqlite {
+			backuper := weatherstn.NewSqliteBackuper(config.DatabaseConfig.Path, config.BackupConfig)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				backuper.Run(ctx, time.Duration(config.BackupConfig.IntervalSecs)*time.Second)
+			}()
+		} else {
+			log.Warn("backups are only supported for sqlite databases")
+		}
+	}
 
-	<-stopSig
+	var statusServer *http.Server
+	if config.StatusConfig.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/status", weatherstn.NewStatusHandler(producer, config.StatusConfig.Units))
+		statusServer = &http.Server{Addr: config.StatusConfig.Listen, Handler: mux}
+		go func() {
+			if err := statusServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("status server failed")
+			}
+		}()
+	}
 
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	go notifySystemd(ctx, producer)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			notify(weatherstn.SystemdReloading)
+			reloaded, err := reloadConfig(*configPath, config, producer, publisher, alerts)
+			if err != nil {
+				log.WithError(err).Error("failed to reload config, keeping the current config")
+			} else {
+				config = reloaded
+			}
+			notify(weatherstn.SystemdReady)
+			continue
+		}
+
+		log.WithField("signal", sig.String()).Info("shutting down")
+		break
+	}
+	cancel()
+	notify(weatherstn.SystemdStopping)
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	if statusServer != nil {
+		if err := statusServer.Shutdown(shutdownCtx); err != nil {
+			log.WithError(err).Error("failed to shut down status server")
+		}
+	}
+
+	stopped := make(chan struct{})
 	go func() {
-		producer.Stop()
-		wg.Done()
-	}()
-	go func() {
-		publisher.Stop()
-		wg.Done()
+		wg.Wait()
+		alerts.Close()
+		close(stopped)
 	}()
 
-	wg.Wait()
-	atmosProvider.Disconnect()
-	windProvider.Disconnect()
-	rainProvider.Disconnect()
-	fmt.Println("Graceful shutdown completed")
+	select {
+	case <-stopped:
+		fmt.Println("Graceful shutdown completed")
+	case <-shutdownCtx.Done():
+		log.WithField("timeout", shutdownTimeout.String()).Error("timed out waiting for shutdown, exiting")
+		os.Exit(1)
+	}
+}
+
+// notifySystemd tells systemd that the station is ready once the producer is running and then, if the watchdog is
+// enabled, keeps the watchdog from restarting the station for as long as the producer is alive. A stuck producer stops
+// the notifications so that systemd restarts the station.
+func notifySystemd(ctx context.Context, producer *weatherstn.SensorProducer) {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for !producer.Alive(time.Now()) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	if !notify(weatherstn.SystemdReady) {
+		return
+	}
+
+	interval, err := weatherstn.SystemdWatchdogInterval()
+	if err != nil {
+		log.WithError(err).Error("failed to read systemd watchdog interval")
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	// Notify at half the interval, as recommended by sd_watchdog_enabled(3), so that one late notification doesn't
+	// trigger a restart.
+	watchdog := time.NewTicker(interval / 2)
+	defer watchdog.Stop()
+	alive := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-watchdog.C:
+			if !producer.Alive(now) {
+				if alive {
+					log.WithField("component", "Watchdog").Error("producer is not making progress, " +
+						"withholding watchdog notification")
+				}
+				alive = false
+				continue
+			}
+
+			alive = true
+			notify(weatherstn.SystemdWatchdog)
+		}
+	}
+}
+
+// notify sends the state to systemd, returning whether it was sent.
+func notify(state string) bool {
+	sent, err := weatherstn.SystemdNotify(state)
+	if err != nil {
+		log.WithError(err).WithField("state", state).Error("failed to notify systemd")
+	}
+
+	return sent
 }
 
-func doMigrate(dbPath, migrationsPath string, n int, all bool) error {
-	m, err := migrate.New(
-		"file://"+migrationsPath,
-		"sqlite3://"+dbPath)
+func doMigrate(config weatherstn.DatabaseConfig, n int, all bool) error {
+	migrator, err := weatherstn.NewMigrator(config)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := migrator.Close(); err != nil {
+			log.WithError(err).Error("failed to close migrator")
+		}
+	}()
 
 	if all {
-		err = m.Up()
+		err = migrator.Up()
 		if err != nil {
 			return err
 		}
 	}
 	if n != 0 {
-		err = m.Steps(n)
+		err = migrator.Steps(n)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const publishUsage = "usage: weather_station publish log|requeue [flags]"
+
+func runPublish(args []string) {
+	if len(args) == 0 {
+		log.Fatal(publishUsage)
+	}
+
+	// Output is written to stdout so keep logs out of the way.
+	log.SetOutput(os.Stderr)
+
+	switch args[0] {
+	case "log":
+		flags := flag.NewFlagSet("publish log", flag.ExitOnError)
+		configPath := flags.String("config", "config.json", "path to the config file")
+		from := flags.String("from", "", "start of the time range (inclusive), RFC3339 or date, defaults to 24 hours ago")
+		to := flags.String("to", "", "end of the time range (exclusive), RFC3339 or date, defaults to now")
+		tz := flags.String("tz", "Local", "timezone used for times without an offset and for printed times")
+		if err := flags.Parse(args[1:]); err != nil {
+			log.WithError(err).Fatal("failed to parse flags")
+		}
+
+		if err := doPublishLog(*configPath, *from, *to, *tz); err != nil {
+			log.WithError(err).Fatal("failed to read publish log")
+		}
+	case "requeue":
+		flags := flag.NewFlagSet("publish requeue", flag.ExitOnError)
+		configPath := flags.String("config", "config.json", "path to the config file")
+		attempt := flags.Int64("attempt", 0, "id of a publish attempt whose observations are requeued")
+		from := flags.String("from", "", "start of the observations to requeue (inclusive), RFC3339 or date")
+		to := flags.String("to", "", "end of the observations to requeue (exclusive), RFC3339 or date")
+		tz := flags.String("tz", "Local", "timezone used for times without an offset")
+		if err := flags.Parse(args[1:]); err != nil {
+			log.WithError(err).Fatal("failed to parse flags")
+		}
+
+		if err := doRequeue(*configPath, *attempt, *from, *to, *tz); err != nil {
+			log.WithError(err).Fatal("failed to requeue observations")
+		}
+	default:
+		log.Fatal(publishUsage)
+	}
+}
+
+// openPublishHistory opens the configured database as a PublishHistory.
+func openPublishHistory(configPath string) (weatherstn.PublishHistory, error) {
+	config := weatherstn.NewAppConfig(configPath)
+	if err := config.Parse(); err != nil {
+		return nil, err
+	}
+
+	datastore, err := weatherstn.OpenDataStore(config.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	history, ok := datastore.(weatherstn.PublishHistory)
+	if !ok {
+		return nil, fmt.Errorf("%s databases don't keep a publish log", config.DatabaseConfig.DriverName())
+	}
+
+	return history, nil
+}
+
+func doPublishLog(configPath, fromArg, toArg, tzArg string) error {
+	loc, err := time.LoadLocation(tzArg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	fromTime, err := parseExportTime(fromArg, loc, now.Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	toTime, err := parseExportTime(toArg, loc, now)
+	if err != nil {
+		return err
+	}
+
+	history, err := openPublishHistory(configPath)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := history.ReadPublishLog(context.Background(), fromTime.Unix(), toTime.Unix())
+	if err != nil {
+		return err
+	}
+
+	formatTime := func(unix int64) string {
+		return time.Unix(unix, 0).In(loc).Format(time.RFC3339)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tTARGET\tROWS\tIDS\tOBSERVATIONS\tBYTES\tSTATUS\tLATENCY\tERROR")
+	for _, attempt := range attempts {
+		status := "-"
+		if attempt.StatusCode != 0 {
+			status = fmt.Sprint(attempt.StatusCode)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d-%d\t%s - %s\t%d\t%s\t%s\t%s\n",
+			attempt.ID,
+			formatTime(attempt.Time),
+			attempt.Target,
+			attempt.Rows,
+			attempt.FirstID,
+			attempt.LastID,
+			formatTime(attempt.FirstTimestamp),
+			formatTime(attempt.LastTimestamp),
+			attempt.Bytes,
+			status,
+			time.Duration(attempt.LatencyMillis)*time.Millisecond,
+			attempt.Error,
+		)
+	}
+
+	return w.Flush()
+}
+
+func doRequeue(configPath string, attemptID int64, fromArg, toArg, tzArg string) error {
+	if (attemptID == 0) == (fromArg == "" || toArg == "") {
+		return errors.New("either -attempt or both -from and -to must be given")
+	}
+
+	history, err := openPublishHistory(configPath)
+	if err != nil {
+		return err
+	}
+
+	if attemptID != 0 {
+		attempt, err := history.ReadPublishAttempt(context.Background(), attemptID)
+		if err != nil {
+			return fmt.Errorf("publish attempt %d: %w", attemptID, err)
+		}
+
+		requeued, err := history.RequeueAttempt(context.Background(), attempt.ID)
+		if err != nil {
+			return err
+		}
+
+		log.WithField("attempt", attemptID).
+			WithField("sent", attempt.Rows).
+			WithField("rows", requeued).
+			Info("requeued observations, the station publishes them on its next push")
+
+		return nil
+	}
+
+	loc, err := time.LoadLocation(tzArg)
+	if err != nil {
+		return err
+	}
+
+	fromTime, err := parseExportTime(fromArg, loc, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	toTime, err := parseExportTime(toArg, loc, time.Time{})
+	if err != nil {
+		return err
+	}
+	from, to := fromTime.Unix(), toTime.Unix()
+
+	requeued, err := history.Requeue(context.Background(), from, to)
+	if err != nil {
+		return err
+	}
+
+	log.WithField("from", time.Unix(from, 0)).
+		WithField("to", time.Unix(to, 0)).
+		WithField("rows", requeued).
+		Info("requeued observations, the station publishes them on its next push")
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/chvck/weatherstn"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadConfig reads the config at path and applies the parts of it which can be changed while running: the producer
+// and publisher intervals, the publisher endpoints and publish log retention, the station info, sensor calibration, the
+// alerts and the log level. Sensors stay connected so nothing which they have counted so far is lost. Changes to
+// anything else are logged as needing a restart. If the new config is invalid nothing is changed and an error is
+// returned, otherwise the config now running is returned, which keeps the current values of the sections needing a
+// restart so that they are warned about again on the next reload.
+func reloadConfig(path string, current *weatherstn.AppConfig, producer *weatherstn.SensorProducer,
+	publisher *weatherstn.Publisher, alerts *weatherstn.AlertEngine) (*weatherstn.AppConfig, error) {
+	config := weatherstn.NewAppConfig(path)
+	if err := config.Parse(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	level, err := config.Level()
+	if err != nil {
+		return nil, err
+	}
+	station, err := config.StationConfig.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, section := range restartRequired(current, config) {
+		log.WithField("section", section).Warn("config change requires a restart to take effect")
+	}
+
+	// Calibration is checked against the sensors which are running so apply it first, followed by the alerts whose
+	// sinks are created from the config, nothing else can fail once the config is valid.
+	if err := producer.Calibrate(config.ProducerConfig.Calibration); err != nil {
+		return nil, err
+	}
+	if err := alerts.SetConfig(config.AlertsConfig); err != nil {
+		return nil, err
+	}
+
+	if err := producer.SetInterval(config.ProducerConfig.PollInterval()); err != nil {
+		return nil, err
+	}
+	if err := publisher.SetInterval(config.PublisherConfig.PushInterval()); err != nil {
+		return nil, err
+	}
+	publisher.SetEndpoints(config.PublisherConfig.EndpointConfig,
+		time.Duration(config.PublisherConfig.RequestTimeoutSecs)*time.Second)
+	publisher.SetPublishLogKeepDays(config.PublisherConfig.PublishLogKeepDays)
+	publisher.SetStation(station)
+	alerts.SetStation(station.ID)
+	log.SetLevel(level)
+
+	running := keepRunning(current, config)
+	log.Info(fmt.Sprintf("Reloaded config: %#v", running.Redacted()))
+
+	return running, nil
+}
+
+// keepRunning returns next with the sections which restartRequired checks set to their values in current.
+func keepRunning(current, next *weatherstn.AppConfig) *weatherstn.AppConfig {
+	running := *next
+	running.ProducerConfig.Sensors = current.ProducerConfig.Sensors
+	running.ProducerConfig.Wind = current.ProducerConfig.Wind
+	running.ProducerConfig.Rain = current.ProducerConfig.Rain
+	running.ProducerConfig.Atmos = current.ProducerConfig.Atmos
+	running.ProducerConfig.Spool = current.ProducerConfig.Spool
+	running.ProducerConfig.Sampling = current.ProducerConfig.Sampling
+	running.ProducerConfig.Supervisor = current.ProducerConfig.Supervisor
+	running.DatabaseConfig = current.DatabaseConfig
+	running.BackupConfig = current.BackupConfig
+	running.StatusConfig = current.StatusConfig
+
+	return &running
+}
+
+// restartRequired returns the sections of the config which have changed but can't be applied while running.
+// keepRunning must keep each of them.
+func restartRequired(current, next *weatherstn.AppConfig) []string {
+	var changed []string
+	currentSensors, currentErr := current.ProducerConfig.SensorConfigs()
+	nextSensors, nextErr := next.ProducerConfig.SensorConfigs()
+	if currentErr != nil || nextErr != nil || !reflect.DeepEqual(currentSensors, nextSensors) {
+		changed = append(changed, "producer.sensors")
+	}
+
+	sections := []struct {
+		name          string
+		current, next interface{}
+	}{
+		{"producer.spool", current.ProducerConfig.Spool, next.ProducerConfig.Spool},
+		{"producer.sampling", current.ProducerConfig.Sampling, next.ProducerConfig.Sampling},
+		{"producer.supervisor", current.ProducerConfig.Supervisor, next.ProducerConfig.Supervisor},
+		{"database", current.DatabaseConfig, next.DatabaseConfig},
+		{"backup", current.BackupConfig, next.BackupConfig},
+		{"status", current.StatusConfig, next.StatusConfig},
+	}
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.current, section.next) {
+			changed = append(changed, section.name)
+		}
+	}
+
+	return changed
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chvck/weatherstn"
+)
+
+func TestKeepRunning(t *testing.T) {
+	current := weatherstn.NewAppConfig("")
+	current.ProducerConfig.PollIntervalSecs = 300
+	current.DatabaseConfig.Path = "weather.db"
+	current.ProducerConfig.Atmos.I2cAddr = 0x76
+
+	next := *current
+	next.ProducerConfig.PollIntervalSecs = 60
+	next.DatabaseConfig.Path = "other.db"
+	next.ProducerConfig.Atmos.I2cAddr = 0x77
+
+	expected := []string{"producer.sensors", "database"}
+	if changed := restartRequired(current, &next); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected %v to need a restart but got %v", expected, changed)
+	}
+
+	running := keepRunning(current, &next)
+	if running.ProducerConfig.PollIntervalSecs != 60 {
+		t.Fatalf("expected the new poll interval to be running but got %d", running.ProducerConfig.PollIntervalSecs)
+	}
+	if changed := restartRequired(running, &next); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected %v to still need a restart on the next reload but got %v", expected, changed)
+	}
+	if changed := restartRequired(running, current); len(changed) != 0 {
+		t.Fatalf("expected reverting the config to need no restart but got %v", changed)
+	}
+}
@@ -0,0 +1,342 @@
+package weatherstn
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultCollectorListen is the address the collector serves on when none is configured.
+	DefaultCollectorListen = ":8080"
+
+	// DefaultCollectorPath is the path observations are sent to when none is configured.
+	DefaultCollectorPath = "/observations"
+
+	// DefaultCollectorMaxBodyBytes is the largest request body, before and after decompression, that the collector
+	// accepts when no limit is configured.
+	DefaultCollectorMaxBodyBytes = 10 << 20
+)
+
+// CollectorConfig is the set of configuration properties for the collector, which receives the observations of many
+// stations.
+type CollectorConfig struct {
+	Listen string `json:"listen"` // address to serve on, defaults to DefaultCollectorListen
+	Path   string `json:"path"`   // path observations are sent to, defaults to DefaultCollectorPath
+
+	// Tokens are the bearer tokens stations authenticate with, keyed by station ID. A station may only send its own
+	// observations.
+	Tokens map[string]string `json:"tokens"`
+
+	// AllowAnonymous accepts observations from stations which don't have a token, and fleet API requests without a
+	// token, for testing.
+	AllowAnonymous bool `json:"allowAnonymous"`
+
+	// FleetTokens are the bearer tokens which may read the fleet API.
+	FleetTokens []string `json:"fleetTokens"`
+
+	// FleetStaleAfterSecs is how long after its latest observation a station is reported as stale by the fleet API,
+	// defaults to DefaultFleetStaleAfterSecs.
+	FleetStaleAfterSecs int `json:"fleetStaleAfterSecs"`
+
+	// Regions are aggregated by the fleet API, keyed by name.
+	Regions map[string]RegionConfig `json:"regions"`
+
+	MaxBodyBytes   int            `json:"maxBodyBytes"` // defaults to DefaultCollectorMaxBodyBytes
+	DatabaseConfig DatabaseConfig `json:"database"`
+	LogLevel       string         `json:"logLevel"` // e.g. info or warning, defaults to debug
+	path           string
+}
+
+// NewCollectorConfig creates a new CollectorConfig.
+func NewCollectorConfig(path string) *CollectorConfig {
+	return &CollectorConfig{path: path}
+}
+
+// Parse reads and parses the config file, in the same way as AppConfig.Parse.
+func (cc *CollectorConfig) Parse() error {
+	return parseConfigFile(cc.path, cc)
+}
+
+// Level returns the configured log level, falling back to debug when none is set.
+func (cc *CollectorConfig) Level() (log.Level, error) {
+	return parseLevel(cc.LogLevel)
+}
+
+// Validate checks that the config describes a collector which can be run. All of the problems found are returned
+// together as a *ConfigError.
+func (cc *CollectorConfig) Validate() error {
+	var problems configProblems
+	if cc.Listen != "" {
+		if _, _, err := net.SplitHostPort(cc.Listen); err != nil {
+			problems.add("listen: %v", err)
+		}
+	}
+	if cc.Path != "" && !strings.HasPrefix(cc.Path, "/") {
+		problems.add("path must start with / but was %s", cc.Path)
+	}
+	if len(cc.Tokens) == 0 && !cc.AllowAnonymous {
+		problems.add("tokens must be set unless allowAnonymous is")
+	}
+	for id, token := range cc.Tokens {
+		if token == "" {
+			problems.add("tokens.%s must not be empty", id)
+		}
+	}
+	for _, token := range cc.FleetTokens {
+		if token == "" {
+			problems.add("fleetTokens must not be empty")
+		}
+	}
+	problems.notNegative("fleetStaleAfterSecs", cc.FleetStaleAfterSecs)
+	for name, region := range cc.Regions {
+		if name == FleetRegionAll {
+			problems.add("regions.%s: name is reserved for the whole fleet", name)
+		}
+		if err := region.Validate(); err != nil {
+			problems.addErr("regions."+name, err)
+		}
+	}
+	problems.notNegative("maxBodyBytes", cc.MaxBodyBytes)
+	problems.database(cc.DatabaseConfig)
+	if _, err := cc.Level(); err != nil {
+		problems.add("logLevel: %v", err)
+	}
+
+	return problems.err()
+}
+
+// CollectorResponse is the body of the collector's response to a batch of observations.
+type CollectorResponse struct {
+	Received int `json:"received"` // observations in the batch
+	Stored   int `json:"stored"`   // observations which weren't already stored
+}
+
+// collectorError is an error with the status code it is returned to the station with.
+type collectorError struct {
+	status int
+	err    error
+}
+
+func (ce *collectorError) Error() string {
+	return ce.err.Error()
+}
+
+func newCollectorError(status int, format string, args ...interface{}) *collectorError {
+	return &collectorError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// NewCollectorHandler returns an http.Handler which implements the server side of the Publisher protocol. It accepts
+// PublishEnvelopes in any of the encodings and compressions a Publisher sends, from stations authenticated by the
+// config's tokens, and stores their observations in metric in the store. Observations which have already been stored
+// for the station are skipped so that stations can safely resend them. A stored batch is answered with 201 Created.
+func NewCollectorHandler(store CollectorStore, config CollectorConfig) http.Handler {
+	maxBodyBytes := int64(defaultInt(config.MaxBodyBytes, DefaultCollectorMaxBodyBytes))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		response, err := collect(r, store, config, maxBodyBytes)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var collectorErr *collectorError
+			if errors.As(err, &collectorErr) {
+				status = collectorErr.status
+			}
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="weatherstn"`)
+			}
+
+			entry := log.WithError(err).
+				WithField("component", "Collector").
+				WithField("event", "Collect").
+				WithField("remoteAddr", r.RemoteAddr).
+				WithField("statusCode", status)
+			if status == http.StatusInternalServerError {
+				entry.Error("failed to store observations")
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			entry.Warn("rejected observations")
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.WithError(err).
+				WithField("component", "Collector").
+				WithField("event", "Collect").
+				Error("failed to write response")
+		}
+	})
+}
+
+// collect authenticates, decodes and stores a batch of observations.
+func collect(r *http.Request, store CollectorStore, config CollectorConfig,
+	maxBodyBytes int64) (CollectorResponse, error) {
+	stationID, err := authenticateStation(r, config)
+	if err != nil {
+		return CollectorResponse{}, err
+	}
+
+	body, err := readCollectorBody(r, maxBodyBytes)
+	if err != nil {
+		return CollectorResponse{}, err
+	}
+
+	var envelope PublishEnvelope
+	if err := decodeEnvelope(body, r.Header.Get("Content-Type"), &envelope); err != nil {
+		return CollectorResponse{}, err
+	}
+
+	if envelope.SchemaVersion != PublishSchemaVersion {
+		return CollectorResponse{}, newCollectorError(http.StatusBadRequest,
+			"unsupported schema version %d, expected %d", envelope.SchemaVersion, PublishSchemaVersion)
+	}
+	if envelope.Station.ID == "" {
+		return CollectorResponse{}, newCollectorError(http.StatusBadRequest, "station id is missing")
+	}
+	if stationID != "" && envelope.Station.ID != stationID {
+		return CollectorResponse{}, newCollectorError(http.StatusForbidden,
+			"authenticated as station %s but observations are from %s", stationID, envelope.Station.ID)
+	}
+	if stationID == "" && config.Tokens[envelope.Station.ID] != "" {
+		return CollectorResponse{}, newCollectorError(http.StatusUnauthorized,
+			"station %s must authenticate", envelope.Station.ID)
+	}
+
+	rows := make([]WeatherDataRow, len(envelope.Observations))
+	for i, obs := range envelope.Observations {
+		rows[i] = envelope.Units.ToMetric(obs)
+	}
+
+	stored, err := store.WriteStationBatch(r.Context(), envelope.Station, rows)
+	if err != nil {
+		return CollectorResponse{}, err
+	}
+
+	log.WithField("component", "Collector").
+		WithField("event", "Collect").
+		WithField("station", envelope.Station.ID).
+		WithField("received", len(rows)).
+		WithField("stored", stored).
+		Info("stored observations")
+
+	return CollectorResponse{Received: len(rows), Stored: stored}, nil
+}
+
+// authenticateStation returns the ID of the station whose token the request carries, or an empty ID for an
+// anonymous request when they are allowed.
+func authenticateStation(r *http.Request, config CollectorConfig) (string, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		if r.Header.Get("Authorization") == "" && config.AllowAnonymous {
+			return "", nil
+		}
+		return "", newCollectorError(http.StatusUnauthorized, "authorization must be a bearer token")
+	}
+
+	// Every token is compared so that the time taken doesn't reveal which stations exist.
+	stationID := ""
+	for id, expected := range config.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			stationID = id
+		}
+	}
+	if stationID == "" {
+		return "", newCollectorError(http.StatusUnauthorized, "invalid bearer token")
+	}
+
+	return stationID, nil
+}
+
+// bearerToken returns the bearer token the request's Authorization header carries.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return authorization[len(prefix):], true
+}
+
+// readCollectorBody reads the request body, decompressing it according to its Content-Encoding. The body is limited
+// to maxBodyBytes both as sent and once decompressed.
+func readCollectorBody(r *http.Request, maxBodyBytes int64) ([]byte, error) {
+	sent := &io.LimitedReader{R: r.Body, N: maxBodyBytes + 1}
+	var body io.Reader = sent
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+	case PublishCompressionGzip:
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, newCollectorError(http.StatusBadRequest, "invalid gzip body: %v", err)
+		}
+		body = gr
+	case PublishCompressionZstd:
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, newCollectorError(http.StatusBadRequest, "invalid zstd body: %v", err)
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		return nil, newCollectorError(http.StatusUnsupportedMediaType, "unsupported content encoding %s", encoding)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxBodyBytes+1))
+	if sent.N <= 0 || int64(len(data)) > maxBodyBytes {
+		return nil, newCollectorError(http.StatusRequestEntityTooLarge, "body is larger than %d bytes", maxBodyBytes)
+	}
+	if err != nil {
+		return nil, newCollectorError(http.StatusBadRequest, "failed to read body: %v", err)
+	}
+
+	return data, nil
+}
+
+// decodeEnvelope decodes a PublishEnvelope sent with the content type, as JSON when none is given.
+func decodeEnvelope(body []byte, contentType string, envelope *PublishEnvelope) error {
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return newCollectorError(http.StatusUnsupportedMediaType, "invalid content type: %v", err)
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(envelope); err != nil {
+			return newCollectorError(http.StatusBadRequest, "invalid JSON body: %v", err)
+		}
+	case "application/cbor":
+		if err := cbor.Unmarshal(body, envelope); err != nil {
+			return newCollectorError(http.StatusBadRequest, "invalid CBOR body: %v", err)
+		}
+	default:
+		return newCollectorError(http.StatusUnsupportedMediaType, "unsupported content type %s", mediaType)
+	}
+
+	return nil
+}
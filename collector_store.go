@@ -0,0 +1,381 @@
+package weatherstn
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stmtUpsertStation = "INSERT INTO stations (id, name, latitude, longitude, altitude, sensor_heights, timezone, " +
+		"software, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO UPDATE SET name=excluded.name, " +
+		"latitude=excluded.latitude, longitude=excluded.longitude, altitude=excluded.altitude, " +
+		"sensor_heights=excluded.sensor_heights, timezone=excluded.timezone, software=excluded.software, " +
+		"last_seen=excluded.last_seen;"
+	stmtInsertStationDataRow = "INSERT INTO station_observations (station_id, timestamp, wind_speed, wind_direction, " +
+		"wind_gust_speed, rainfall, temperature, humidity, pressure, temperature_min, temperature_max, " +
+		"sensor_readings, interval_secs, received_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT(station_id, timestamp) DO NOTHING;"
+	queryFetchStationRangeDataRow = "SELECT id, timestamp, wind_speed, wind_direction, wind_gust_speed, rainfall, " +
+		"temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, interval_secs " +
+		"FROM station_observations WHERE station_id = ? AND timestamp >= ? AND timestamp < ? ORDER BY timestamp ASC;"
+	queryFetchLatestStationDataRows = "SELECT so.station_id, so.id, so.timestamp, so.wind_speed, so.wind_direction, " +
+		"so.wind_gust_speed, so.rainfall, so.temperature, so.humidity, so.pressure, so.temperature_min, " +
+		"so.temperature_max, so.sensor_readings, so.interval_secs FROM station_observations so JOIN (SELECT " +
+		"station_id, MAX(timestamp) AS timestamp FROM station_observations GROUP BY station_id) latest " +
+		"ON so.station_id = latest.station_id AND so.timestamp = latest.timestamp;"
+	queryFetchStations = "SELECT id, name, latitude, longitude, altitude, sensor_heights, timezone, software, " +
+		"last_seen FROM stations ORDER BY id ASC;"
+)
+
+// CollectedStation is a station which has sent observations to a collector, as it described itself in the last
+// PublishEnvelope it sent.
+type CollectedStation struct {
+	StationInfo
+	LastSeen int64 `json:"lastSeen"` // unix seconds when the station last sent observations
+}
+
+// CollectorStore is implemented by DataStores which can store the observations of many stations, as received by a
+// collector, alongside those of the station itself. Observations are unique by station and timestamp and, as with a
+// DataStore, are returned in ascending timestamp order.
+type CollectorStore interface {
+	// WriteStationBatch records the station as last seen now and stores its rows, skipping any for timestamps already
+	// stored for the station, returning how many were written.
+	WriteStationBatch(ctx context.Context, station StationInfo, rows []WeatherDataRow) (int, error)
+
+	// ReadStationRange returns the station's rows with a timestamp from (inclusive) up to to (exclusive).
+	ReadStationRange(ctx context.Context, stationID string, from, to int64) ([]WeatherDataRow, error)
+
+	// ReadLatestStationRows returns the latest row of every station which has sent observations, keyed by station ID.
+	ReadLatestStationRows(ctx context.Context) (map[string]WeatherDataRow, error)
+
+	// ReadStations returns every station which has sent observations, ordered by ID.
+	ReadStations(ctx context.Context) ([]CollectedStation, error)
+}
+
+type stationDataRow struct {
+	StationID string `db:"station_id"`
+	weatherDataRow
+}
+
+type stationRow struct {
+	ID            string   `db:"id"`
+	Name          string   `db:"name"`
+	Latitude      *float64 `db:"latitude"`
+	Longitude     *float64 `db:"longitude"`
+	Altitude      *float64 `db:"altitude"`
+	SensorHeights *string  `db:"sensor_heights"`
+	Timezone      string   `db:"timezone"`
+	Software      string   `db:"software"`
+	LastSeen      int64    `db:"last_seen"`
+}
+
+func (row stationRow) toCollectedStation() (CollectedStation, error) {
+	var sensorHeights map[string]float64
+	if row.SensorHeights != nil {
+		if err := json.Unmarshal([]byte(*row.SensorHeights), &sensorHeights); err != nil {
+			return CollectedStation{}, err
+		}
+	}
+
+	return CollectedStation{
+		StationInfo: StationInfo{
+			StationConfig: StationConfig{
+				ID:            row.ID,
+				Name:          row.Name,
+				Latitude:      row.Latitude,
+				Longitude:     row.Longitude,
+				Altitude:      row.Altitude,
+				SensorHeights: sensorHeights,
+				Timezone:      row.Timezone,
+			},
+			Software: row.Software,
+		},
+		LastSeen: row.LastSeen,
+	}, nil
+}
+
+func writeStationBatch(ctx context.Context, db *sqlx.DB, station StationInfo, rows []WeatherDataRow) (int, error) {
+	var sensorHeights *string
+	if len(station.SensorHeights) > 0 {
+		encoded, err := json.Marshal(station.SensorHeights)
+		if err != nil {
+			return 0, err
+		}
+		column := string(encoded)
+		sensorHeights = &column
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, tx.Rebind(stmtUpsertStation),
+		station.ID,
+		station.Name,
+		station.Latitude,
+		station.Longitude,
+		station.Altitude,
+		sensorHeights,
+		station.Timezone,
+		station.Software,
+		now,
+	); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	insert, err := tx.PrepareContext(ctx, tx.Rebind(stmtInsertStationDataRow))
+	if err != nil {
+		rollback(tx)
+		return 0, err
+	}
+	closeInsert := func() {
+		if err := insert.Close(); err != nil {
+			log.WithError(err).
+				WithField("component", "DataStore").
+				Error("failed to close statement")
+		}
+	}
+
+	written := 0
+	for _, row := range rows {
+		sensorReadings, err := sensorReadingsColumn(row.SensorReadings)
+		if err != nil {
+			closeInsert()
+			rollback(tx)
+			return 0, err
+		}
+
+		result, err := insert.ExecContext(ctx,
+			station.ID,
+			row.Timestamp,
+			row.WindReadings.Speed,
+			row.WindReadings.Direction,
+			row.WindReadings.Gust,
+			row.RainReadings.Rainfall,
+			row.AtmosReadings.Temperature,
+			row.AtmosReadings.Humidity,
+			row.AtmosReadings.Pressure,
+			row.AtmosReadings.TemperatureMin,
+			row.AtmosReadings.TemperatureMax,
+			sensorReadings,
+			row.IntervalSeconds,
+			now,
+		)
+		if err != nil {
+			closeInsert()
+			rollback(tx)
+			return 0, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			closeInsert()
+			rollback(tx)
+			return 0, err
+		}
+		written += int(affected)
+	}
+	closeInsert()
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return written, nil
+}
+
+func readStationRange(ctx context.Context, db *sqlx.DB, stationID string, from, to int64) ([]WeatherDataRow, error) {
+	var rows []weatherDataRow
+	if err := db.SelectContext(ctx, &rows, db.Rebind(queryFetchStationRangeDataRow), stationID, from, to); err != nil {
+		return nil, err
+	}
+
+	return toWeatherDataRows(rows)
+}
+
+func readLatestStationRows(ctx context.Context, db *sqlx.DB) (map[string]WeatherDataRow, error) {
+	var rows []stationDataRow
+	if err := db.SelectContext(ctx, &rows, queryFetchLatestStationDataRows); err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]WeatherDataRow, len(rows))
+	for _, row := range rows {
+		measurement, err := row.toWeatherDataRow()
+		if err != nil {
+			return nil, err
+		}
+		latest[row.StationID] = measurement
+	}
+
+	return latest, nil
+}
+
+func readStations(ctx context.Context, db *sqlx.DB) ([]CollectedStation, error) {
+	var rows []stationRow
+	if err := db.SelectContext(ctx, &rows, queryFetchStations); err != nil {
+		return nil, err
+	}
+
+	stations := make([]CollectedStation, 0, len(rows))
+	for _, row := range rows {
+		station, err := row.toCollectedStation()
+		if err != nil {
+			return nil, err
+		}
+		stations = append(stations, station)
+	}
+
+	return stations, nil
+}
+
+// WriteStationBatch stores the station's rows in the station_observations table in a single transaction.
+func (sds *SqliteDataStore) WriteStationBatch(ctx context.Context, station StationInfo,
+	rows []WeatherDataRow) (int, error) {
+	return writeStationBatch(ctx, sds.db, station, rows)
+}
+
+// ReadStationRange reads the station's rows with a timestamp from (inclusive) up to to (exclusive).
+func (sds *SqliteDataStore) ReadStationRange(ctx context.Context, stationID string,
+	from, to int64) ([]WeatherDataRow, error) {
+	return readStationRange(ctx, sds.db, stationID, from, to)
+}
+
+// ReadLatestStationRows reads the latest row of every station from the station_observations table.
+func (sds *SqliteDataStore) ReadLatestStationRows(ctx context.Context) (map[string]WeatherDataRow, error) {
+	return readLatestStationRows(ctx, sds.db)
+}
+
+// ReadStations reads every station which has sent observations from the stations table.
+func (sds *SqliteDataStore) ReadStations(ctx context.Context) ([]CollectedStation, error) {
+	return readStations(ctx, sds.db)
+}
+
+// WriteStationBatch stores the station's rows in the station_observations table in a single transaction.
+func (pds *PostgresDataStore) WriteStationBatch(ctx context.Context, station StationInfo,
+	rows []WeatherDataRow) (int, error) {
+	return writeStationBatch(ctx, pds.db, station, rows)
+}
+
+// ReadStationRange reads the station's rows with a timestamp from (inclusive) up to to (exclusive).
+func (pds *PostgresDataStore) ReadStationRange(ctx context.Context, stationID string,
+	from, to int64) ([]WeatherDataRow, error) {
+	return readStationRange(ctx, pds.db, stationID, from, to)
+}
+
+// ReadLatestStationRows reads the latest row of every station from the station_observations table.
+func (pds *PostgresDataStore) ReadLatestStationRows(ctx context.Context) (map[string]WeatherDataRow, error) {
+	return readLatestStationRows(ctx, pds.db)
+}
+
+// ReadStations reads every station which has sent observations from the stations table.
+func (pds *PostgresDataStore) ReadStations(ctx context.Context) ([]CollectedStation, error) {
+	return readStations(ctx, pds.db)
+}
+
+// WriteStationBatch stores the station's rows, skipping any for timestamps already stored for the station.
+func (mds *MemoryDataStore) WriteStationBatch(ctx context.Context, station StationInfo,
+	rows []WeatherDataRow) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	mds.stations[station.ID] = CollectedStation{StationInfo: station, LastSeen: time.Now().Unix()}
+	stationRows, ok := mds.stationRows[station.ID]
+	if !ok {
+		stationRows = make(map[int64]WeatherDataRow)
+		mds.stationRows[station.ID] = stationRows
+	}
+
+	written := 0
+	for _, row := range rows {
+		if _, ok := stationRows[row.Timestamp]; ok {
+			continue
+		}
+
+		mds.lastID++
+		row.ID = mds.lastID
+		stationRows[row.Timestamp] = row
+		written++
+	}
+
+	return written, nil
+}
+
+// ReadStationRange returns the station's rows with a timestamp from (inclusive) up to to (exclusive) ordered by
+// timestamp.
+func (mds *MemoryDataStore) ReadStationRange(ctx context.Context, stationID string,
+	from, to int64) ([]WeatherDataRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mds.lock.RLock()
+	defer mds.lock.RUnlock()
+
+	var rows []WeatherDataRow
+	for timestamp, row := range mds.stationRows[stationID] {
+		if timestamp >= from && timestamp < to {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Timestamp < rows[j].Timestamp
+	})
+
+	return rows, nil
+}
+
+// ReadLatestStationRows returns the latest row of every station keyed by station ID.
+func (mds *MemoryDataStore) ReadLatestStationRows(ctx context.Context) (map[string]WeatherDataRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mds.lock.RLock()
+	defer mds.lock.RUnlock()
+
+	latest := make(map[string]WeatherDataRow, len(mds.stationRows))
+	for stationID, rows := range mds.stationRows {
+		for timestamp, row := range rows {
+			if current, ok := latest[stationID]; !ok || timestamp > current.Timestamp {
+				latest[stationID] = row
+			}
+		}
+	}
+
+	return latest, nil
+}
+
+// ReadStations returns every station which has sent observations ordered by ID.
+func (mds *MemoryDataStore) ReadStations(ctx context.Context) ([]CollectedStation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mds.lock.RLock()
+	defer mds.lock.RUnlock()
+
+	stations := make([]CollectedStation, 0, len(mds.stations))
+	for _, station := range mds.stations {
+		stations = append(stations, station)
+	}
+
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].ID < stations[j].ID
+	})
+
+	return stations, nil
+}
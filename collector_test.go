@@ -0,0 +1,169 @@
+package weatherstn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCollectorHandler_Publisher(t *testing.T) {
+	dataset := loadConformanceDataset(t)
+
+	stationStore := NewMemoryDataStore()
+	if _, err := stationStore.WriteBatch(context.Background(), dataset, false); err != nil {
+		t.Fatalf("failed to write to data store: %v", err)
+	}
+
+	collectorStore := NewMemoryDataStore()
+	server := httptest.NewServer(NewCollectorHandler(collectorStore, CollectorConfig{
+		Tokens: map[string]string{"allotment": "secret"},
+	}))
+	defer server.Close()
+
+	station := StationInfo{StationConfig: StationConfig{ID: "allotment", Timezone: "UTC"}, Software: "v1.2.3"}
+	config := EndpointConfig{
+		BaseURL:          server.URL,
+		SendObservations: Endpoint{Method: "POST", Path: "observations"},
+		Units:            ImperialUnits(),
+		Headers:          map[string]string{"Authorization": "Bearer secret"},
+		Encoding:         PublishEncodingCBOR,
+		Compression:      PublishCompressionZstd,
+	}
+	NewPublisher(stationStore, station, config, server.Client(), 0).Process(context.Background())
+
+	if unpublished, err := stationStore.ReadUnpublished(context.Background()); err != nil || len(unpublished) != 0 {
+		t.Fatalf("expected every observation to have been published but %d were left (%v)", len(unpublished), err)
+	}
+
+	rows, err := collectorStore.ReadStationRange(context.Background(), "allotment", 0, dataset[len(dataset)-1].Timestamp+1)
+	if err != nil {
+		t.Fatalf("failed to read station range: %v", err)
+	}
+	if len(rows) != len(dataset) {
+		t.Fatalf("expected %d observations to be collected but got %d", len(dataset), len(rows))
+	}
+	for i, row := range rows {
+		if !closeTo(row.AtmosReadings.Temperature, dataset[i].AtmosReadings.Temperature) ||
+			!closeTo(row.WindReadings.Speed, dataset[i].WindReadings.Speed) {
+			t.Fatalf("expected observation %d to be stored in metric as %#v but was %#v", i, dataset[i], row)
+		}
+	}
+
+	// Resent observations are accepted without being stored twice.
+	if _, err := stationStore.Requeue(context.Background(), 0, dataset[len(dataset)-1].Timestamp+1); err != nil {
+		t.Fatalf("failed to requeue: %v", err)
+	}
+	NewPublisher(stationStore, station, config, server.Client(), 0).Process(context.Background())
+	if unpublished, err := stationStore.ReadUnpublished(context.Background()); err != nil || len(unpublished) != 0 {
+		t.Fatalf("expected resent observations to be accepted but %d were left (%v)", len(unpublished), err)
+	}
+	rows, err = collectorStore.ReadStationRange(context.Background(), "allotment", 0, dataset[len(dataset)-1].Timestamp+1)
+	if err != nil || len(rows) != len(dataset) {
+		t.Fatalf("expected %d observations after resending but got %d (%v)", len(dataset), len(rows), err)
+	}
+}
+
+func TestCollectorHandler_Rejects(t *testing.T) {
+	envelope := func(stationID string, schemaVersion int) []byte {
+		body, err := json.Marshal(PublishEnvelope{
+			SchemaVersion: schemaVersion,
+			Station:       StationInfo{StationConfig: StationConfig{ID: stationID}},
+			Observations:  []WeatherDataRow{{Timestamp: 1548884106, IntervalSeconds: 30}},
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal envelope: %v", err)
+		}
+		return body
+	}
+
+	tests := map[string]struct {
+		method        string
+		authorization string
+		contentType   string
+		body          []byte
+		status        int
+	}{
+		"accepted":        {authorization: "Bearer secret", body: envelope("allotment", 1), status: 201},
+		"method":          {method: http.MethodGet, status: 405},
+		"no token":        {body: envelope("allotment", 1), status: 401},
+		"wrong token":     {authorization: "Bearer guess", body: envelope("allotment", 1), status: 401},
+		"other station":   {authorization: "Bearer secret", body: envelope("roof", 1), status: 403},
+		"schema version":  {authorization: "Bearer secret", body: envelope("allotment", 2), status: 400},
+		"invalid body":    {authorization: "Bearer secret", body: []byte("{"), status: 400},
+		"content type":    {authorization: "Bearer secret", contentType: "text/csv", body: []byte("a,b"), status: 415},
+		"too large":       {authorization: "Bearer secret", body: bytes.Repeat([]byte(" "), 2048), status: 413},
+		"missing station": {authorization: "Bearer secret", body: envelope("", 1), status: 400},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := NewCollectorHandler(NewMemoryDataStore(), CollectorConfig{
+				Tokens:       map[string]string{"allotment": "secret"},
+				MaxBodyBytes: 1024,
+			})
+
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			req := httptest.NewRequest(method, "/observations", bytes.NewReader(test.body))
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.status {
+				t.Fatalf("expected status %d but got %d: %s", test.status, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestCollectorConfig_Validate(t *testing.T) {
+	valid := func() CollectorConfig {
+		return CollectorConfig{
+			Listen:         ":8080",
+			Tokens:         map[string]string{"allotment": "secret"},
+			DatabaseConfig: DatabaseConfig{Path: "./collector"},
+		}
+	}
+	config := valid()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("unexpected error validating config: %v", err)
+	}
+
+	invalid := map[string]func(config *CollectorConfig){
+		"listen":    func(config *CollectorConfig) { config.Listen = "8080" },
+		"path":      func(config *CollectorConfig) { config.Path = "observations" },
+		"no tokens": func(config *CollectorConfig) { config.Tokens = nil },
+		"token":     func(config *CollectorConfig) { config.Tokens["roof"] = "" },
+		"database":  func(config *CollectorConfig) { config.DatabaseConfig.Path = "" },
+		"log level": func(config *CollectorConfig) { config.LogLevel = "chatty" },
+		"region":    func(config *CollectorConfig) { config.Regions = map[string]RegionConfig{"london": {}} },
+		"region name": func(config *CollectorConfig) {
+			config.Regions = map[string]RegionConfig{FleetRegionAll: {Stations: []string{"allotment"}}}
+		},
+		"region box": func(config *CollectorConfig) {
+			latitude := 51.5
+			config.Regions = map[string]RegionConfig{"london": {MinLatitude: &latitude}}
+		},
+		"fleet stale": func(config *CollectorConfig) { config.FleetStaleAfterSecs = -1 },
+	}
+	for name, invalidate := range invalid {
+		t.Run(name, func(t *testing.T) {
+			config := valid()
+			invalidate(&config)
+			var configErr *ConfigError
+			if err := config.Validate(); !errors.As(err, &configErr) || len(configErr.Problems) != 1 {
+				t.Fatalf("expected config to have a single problem but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package weatherstn
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix is the prefix of environment variables which override fields of the config file. The rest of the
+// name is the path to the field in upper snake case, e.g. WEATHERSTN_PUBLISHER_ENDPOINTS_HOST overrides
+// publisher.endpoints.host.
+const ConfigEnvPrefix = "WEATHERSTN_"
+
+// readConfigFile decodes a config file into a generic map, as JSON, YAML or TOML depending on its extension. Files
+// without a .yaml, .yml or .toml extension are read as JSON.
+func readConfigFile(path string, data []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		tree, err := toml.LoadBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		raw = tree.ToMap()
+	default:
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	}
+
+	if raw == nil { // an empty YAML file
+		raw = make(map[string]interface{})
+	}
+
+	if _, err := normalizeConfigValue(raw); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
+
+// normalizeConfigValue converts YAML mappings, which may have keys of any type, into maps keyed by string so that the
+// value can be encoded as JSON.
+func normalizeConfigValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			normalized, err := normalizeConfigValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalized
+		}
+		return v, nil
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			elem, err := normalizeConfigValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			normalized[fmt.Sprint(key)] = elem
+		}
+		return normalized, nil
+	case []interface{}:
+		for i, elem := range v {
+			normalized, err := normalizeConfigValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// configEnvField is a config field which can be overridden by an environment variable.
+type configEnvField struct {
+	path []string
+	t    reflect.Type
+}
+
+// configEnvFields returns the fields of the struct type t keyed by the name of the environment variable which
+// overrides them. Nested structs are expanded into their fields, any other field, such as a list, a map or a struct
+// which decodes itself like Duration and Units, is overridden as a whole.
+func configEnvFields(t reflect.Type, name string, path []string, fields map[string]configEnvField) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+
+		fieldName := name + "_" + upperSnakeCase(key)
+		fieldPath := append(append([]string(nil), path...), key)
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && !reflect.PtrTo(fieldType).Implements(jsonUnmarshalerType) {
+			configEnvFields(fieldType, fieldName, fieldPath, fields)
+			continue
+		}
+
+		fields[strings.TrimPrefix(fieldName, "_")] = configEnvField{path: fieldPath, t: fieldType}
+	}
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// upperSnakeCase converts a camel case name into upper snake case, e.g. requestTimeoutSecs to REQUEST_TIMEOUT_SECS
+// and vaneCSPin to VANE_CS_PIN.
+func upperSnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// applyConfigEnv overrides the fields of the decoded config raw, for the config type t, with any ConfigEnvPrefix
+// environment variables in environ. Strings are used as they are and anything else is read as JSON, apart from
+// fields which decode themselves, such as durations and units, which may be either. Variables which don't match a
+// field or have invalid values are returned as problems.
+func applyConfigEnv(raw map[string]interface{}, t reflect.Type, environ []string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := make(map[string]configEnvField)
+	configEnvFields(t, "", nil, fields)
+
+	var problems configProblems
+	for _, env := range environ {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ConfigEnvPrefix) {
+			continue
+		}
+		name, text := parts[0], parts[1]
+
+		field, ok := fields[strings.TrimPrefix(name, ConfigEnvPrefix)]
+		if !ok {
+			problems.add("unknown environment variable %s", name)
+			continue
+		}
+
+		var value interface{}
+		if field.t.Kind() == reflect.String {
+			value = text
+		} else if err := json.Unmarshal([]byte(text), &value); err != nil {
+			if !reflect.PtrTo(field.t).Implements(jsonUnmarshalerType) {
+				problems.add("invalid value for environment variable %s: %v", name, err)
+				continue
+			}
+			value = text
+		}
+
+		setConfigValue(raw, field.path, value)
+	}
+	sort.Strings(problems)
+
+	return problems
+}
+
+// setConfigValue sets the value at path in the decoded config raw, creating any objects along the path which don't
+// exist. Keys are matched case insensitively, as they are when the config is decoded.
+func setConfigValue(raw map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		key = configKey(raw, key)
+		next, ok := raw[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			raw[key] = next
+		}
+		raw = next
+	}
+
+	raw[configKey(raw, path[len(path)-1])] = value
+}
+
+// configKey returns the key in raw which matches key case insensitively, or key if there isn't one.
+func configKey(raw map[string]interface{}, key string) string {
+	for existing := range raw {
+		if strings.EqualFold(existing, key) {
+			return existing
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,222 @@
+package weatherstn
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	jsonTestConfig = `{
+  "producer": {
+    "intervalSecs": 30,
+    "wind": {"anemPin": 5, "anemIntervalSecs": "2s"},
+    "sensors": [
+      {"type": "bme280", "id": "screen", "settings": {"i2cAddr": 118}}
+    ],
+    "calibration": {"screen": {"temperatureOffset": -0.5}}
+  },
+  "publisher": {"intervalSecs": 60, "endpoints": {"host": "anearbyserver:111"}},
+  "backup": {"copyTo": ["/media/usb"]}
+}`
+
+	yamlTestConfig = `
+producer:
+  intervalSecs: 30
+  wind:
+    anemPin: 5
+    anemIntervalSecs: 2s
+  sensors:
+    - type: bme280
+      id: screen
+      settings:
+        i2cAddr: 118
+  calibration:
+    screen:
+      temperatureOffset: -0.5
+publisher:
+  intervalSecs: 60
+  endpoints:
+    host: anearbyserver:111
+backup:
+  copyTo: [/media/usb]
+`
+
+	tomlTestConfig = `
+[producer]
+intervalSecs = 30
+
+[producer.wind]
+anemPin = 5
+anemIntervalSecs = "2s"
+
+[[producer.sensors]]
+type = "bme280"
+id = "screen"
+
+[producer.sensors.settings]
+i2cAddr = 118
+
+[producer.calibration.screen]
+temperatureOffset = -0.5
+
+[publisher]
+intervalSecs = 60
+
+[publisher.endpoints]
+host = "anearbyserver:111"
+
+[backup]
+copyTo = ["/media/usb"]
+`
+)
+
+// parseTestConfig writes the config to a file with the given name in dir and returns it parsed.
+func parseTestConfig(t *testing.T, dir, name, data string) (*AppConfig, error) {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	config := NewAppConfig(path)
+	err := config.Parse()
+	config.path = ""
+
+	return config, err
+}
+
+func TestAppConfig_ParseFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn-config")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected, err := parseTestConfig(t, dir, "config.json", jsonTestConfig)
+	if err != nil {
+		t.Fatalf("unexpected error parsing json config: %v", err)
+	}
+	if expected.ProducerConfig.Wind.AnemInterval.Duration() != 2*time.Second {
+		t.Fatalf("expected anemometer interval to be parsed but got %#v", expected.ProducerConfig.Wind)
+	}
+
+	formats := map[string]string{
+		"config.yaml": yamlTestConfig,
+		"config.yml":  yamlTestConfig,
+		"config.toml": tomlTestConfig,
+	}
+	for name, data := range formats {
+		t.Run(name, func(t *testing.T) {
+			config, err := parseTestConfig(t, dir, name, data)
+			if err != nil {
+				t.Fatalf("unexpected error parsing config: %v", err)
+			}
+
+			if !reflect.DeepEqual(config, expected) {
+				t.Fatalf("expected config to be %#v but was %#v", expected, config)
+			}
+		})
+	}
+
+	t.Run("unknown fields", func(t *testing.T) {
+		_, err := parseTestConfig(t, dir, "unknown.yaml", "producer:\n  intervalSecs: 30\n  pollSecs: 10\n")
+
+		var configErr *ConfigError
+		expected := []string{"unknown field producer.pollSecs"}
+		if !errors.As(err, &configErr) || !reflect.DeepEqual(configErr.Problems, expected) {
+			t.Fatalf("expected unknown field to be rejected but got %v", err)
+		}
+	})
+}
+
+func TestAppConfig_ParseEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn-config")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	env := map[string]string{
+		"WEATHERSTN_PUBLISHER_ENDPOINTS_HOST":              "afarawayserver:222",
+		"WEATHERSTN_PUBLISHER_REQUEST_TIMEOUT_SECS":        "10",
+		"WEATHERSTN_PRODUCER_WIND_ANEM_INTERVAL_SECS":      "500ms",
+		"WEATHERSTN_PRODUCER_WIND_VANE_CS_PIN":             "7",
+		"WEATHERSTN_PRODUCER_CALIBRATION":                  `{"screen": {"humidityOffset": 2}}`,
+		"WEATHERSTN_DATABASE_DSN":                          "postgres://weather:secret@db/weather",
+		"WEATHERSTN_STATUS_LISTEN":                         "127.0.0.1:8080",
+		"WEATHERSTN_STATUS_UNITS":                          "imperial,speed=knots",
+		"WEATHERSTN_PRODUCER_SAMPLING_AGGREGATIONS":        `{"wind_speed": "max"}`,
+		"WEATHERSTN_PRODUCER_SUPERVISOR_MAX_READ_FAILURES": "5",
+	}
+	for name, value := range env {
+		restore := setenv(t, name, value)
+		defer restore()
+	}
+
+	config, err := parseTestConfig(t, dir, "config.yaml", yamlTestConfig)
+	if err != nil {
+		t.Fatalf("unexpected error parsing config: %v", err)
+	}
+
+	if config.PublisherConfig.EndpointConfig.Host != "afarawayserver:222" ||
+		config.PublisherConfig.RequestTimeoutSecs != 10 || config.PublisherConfig.PushIntervalSecs != 60 {
+		t.Fatalf("expected publisher to be overridden but got %#v", config.PublisherConfig)
+	}
+	if wind := config.ProducerConfig.Wind; wind.AnemInterval.Duration() != 500*time.Millisecond ||
+		wind.VaneCSPinNumber != 7 || wind.AnemPinNumber != 5 {
+		t.Fatalf("expected wind to be overridden but got %#v", wind)
+	}
+	expectedCalibration := map[string]CalibrationConfig{"screen": {HumidityOffset: 2}}
+	if !reflect.DeepEqual(config.ProducerConfig.Calibration, expectedCalibration) {
+		t.Fatalf("expected calibration to be replaced but got %#v", config.ProducerConfig.Calibration)
+	}
+	if config.DatabaseConfig.DSN != "postgres://weather:secret@db/weather" ||
+		config.StatusConfig.Listen != "127.0.0.1:8080" || config.ProducerConfig.Supervisor.MaxReadFailures != 5 ||
+		config.ProducerConfig.Sampling.Aggregations["wind_speed"] != "max" ||
+		config.StatusConfig.Units.Speed != "knots" || config.StatusConfig.Units.Temperature != "F" {
+		t.Fatalf("expected config to be overridden but got %#v", config)
+	}
+}
+
+func TestAppConfig_ParseEnvInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn-config")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	restoreUnknown := setenv(t, "WEATHERSTN_PUBLISHER_HOST", "afarawayserver:222")
+	defer restoreUnknown()
+	restoreInvalid := setenv(t, "WEATHERSTN_PRODUCER_INTERVAL_SECS", "thirty")
+	defer restoreInvalid()
+
+	config, err := parseTestConfig(t, dir, "config.json", jsonTestConfig)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 2 {
+		t.Fatalf("expected both environment variables to be rejected but got %v", err)
+	}
+
+	if config.ProducerConfig.PollIntervalSecs != 30 {
+		t.Fatalf("expected the rest of the config to be parsed but got %#v", config.ProducerConfig)
+	}
+}
+
+func TestUpperSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"host":               "HOST",
+		"requestTimeoutSecs": "REQUEST_TIMEOUT_SECS",
+		"vaneCSPin":          "VANE_CS_PIN",
+		"i2cAddr":            "I2C_ADDR",
+		"dsn":                "DSN",
+		"ProducerConfig":     "PRODUCER_CONFIG",
+	}
+	for name, expected := range tests {
+		if actual := upperSnakeCase(name); actual != expected {
+			t.Fatalf("expected %s to be %s but was %s", name, expected, actual)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package weatherstn
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/jmoiron/sqlx"
@@ -8,39 +13,125 @@ import (
 
 const (
 	stmtInsertDataRow = "INSERT INTO observations (timestamp, wind_speed, wind_direction, wind_gust_speed," +
-		"rainfall, temperature, humidity, pressure, interval_secs) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);"
-	queryFetchUnpublishedDataRow = "SELECT timestamp, wind_speed, wind_direction, wind_gust_speed," +
-		"rainfall, temperature, humidity, pressure, interval_secs FROM observations where published=false " +
-		"ORDER BY timestamp ASC;"
-	stmtUpdateDataRow = "UPDATE observations SET published=true WHERE timestamp BETWEEN ? AND ?;"
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, " +
+		"interval_secs) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(timestamp) DO NOTHING;"
+	stmtInsertPublishedDataRow = "INSERT INTO observations (timestamp, wind_speed, wind_direction, wind_gust_speed," +
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, " +
+		"interval_secs, published) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(timestamp) DO NOTHING;"
+	queryFetchUnpublishedDataRow = "SELECT id, timestamp, wind_speed, wind_direction, wind_gust_speed," +
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, " +
+		"interval_secs FROM observations where published=false ORDER BY timestamp ASC;"
+	queryFetchRangeDataRow = "SELECT id, timestamp, wind_speed, wind_direction, wind_gust_speed," +
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, " +
+		"interval_secs FROM observations WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp ASC;"
+	stmtUpdateDataRow = "UPDATE observations SET published=true WHERE id IN (?);"
+
+	// sqliteMaxUpdateBatch keeps the number of bound parameters in a single update below the sqlite default limit of
+	// 999 variables.
+	sqliteMaxUpdateBatch = 500
 )
 
+// ErrDuplicateObservation is returned by a DataStore when writing a row for a timestamp which has already been stored.
+var ErrDuplicateObservation = errors.New("observation already exists for timestamp")
+
 // WeatherDataRow is the structure for data passed to and from a DataStore.
 type WeatherDataRow struct {
-	Timestamp       int64                `json:"timestamp"`
-	AtmosReadings   AtmoshphericReadings `json:"atmospherics"`
-	WindReadings    WindReadings         `json:"wind"`
-	RainReadings    RainReadings         `json:"rain"`
+	ID              int64                     `json:"-"` // assigned by the DataStore, only set on rows read back from it
+	Timestamp       int64                     `json:"timestamp"`
+	AtmosReadings   AtmoshphericReadings      `json:"atmospherics"`
+	WindReadings    WindReadings              `json:"wind"`
+	RainReadings    RainReadings              `json:"rain"`
+	SensorReadings  map[string]SensorReadings `json:"sensors,omitempty"` // readings of any additional sensors by ID
 	IntervalSeconds int
 }
 
 type weatherDataRow struct {
-	Timestamp       int64   `db:"timestamp"`
-	Temperature     float64 `db:"temperature"`
-	Pressure        float64 `db:"pressure"`
-	Humidity        float64 `db:"humidity"`
-	WindSpeed       float64 `db:"wind_speed"`
-	WindDirection   float32 `db:"wind_direction"`
-	WindGust        float64 `db:"wind_gust_speed"`
-	Rainfall        float64 `db:"rainfall"`
-	IntervalSeconds int     `db:"interval_secs"`
-}
-
-// DataStore is responsible for persisting and reading data from storage.
+	ID              int64    `db:"id"`
+	Timestamp       int64    `db:"timestamp"`
+	Temperature     float64  `db:"temperature"`
+	Pressure        float64  `db:"pressure"`
+	Humidity        float64  `db:"humidity"`
+	TemperatureMin  *float64 `db:"temperature_min"`
+	TemperatureMax  *float64 `db:"temperature_max"`
+	SensorReadings  *string  `db:"sensor_readings"`
+	WindSpeed       float64  `db:"wind_speed"`
+	WindDirection   float32  `db:"wind_direction"`
+	WindGust        float64  `db:"wind_gust_speed"`
+	Rainfall        float64  `db:"rainfall"`
+	IntervalSeconds int      `db:"interval_secs"`
+}
+
+func (row weatherDataRow) toWeatherDataRow() (WeatherDataRow, error) {
+	var sensorReadings map[string]SensorReadings
+	if row.SensorReadings != nil {
+		if err := json.Unmarshal([]byte(*row.SensorReadings), &sensorReadings); err != nil {
+			return WeatherDataRow{}, err
+		}
+	}
+
+	return WeatherDataRow{
+		ID:              row.ID,
+		Timestamp:       row.Timestamp,
+		IntervalSeconds: row.IntervalSeconds,
+		WindReadings: WindReadings{
+			Speed:     row.WindSpeed,
+			Direction: row.WindDirection,
+			Gust:      row.WindGust,
+		},
+		RainReadings: RainReadings{
+			Rainfall: row.Rainfall,
+		},
+		AtmosReadings: AtmoshphericReadings{
+			Temperature:    row.Temperature,
+			Humidity:       row.Humidity,
+			Pressure:       row.Pressure,
+			TemperatureMin: row.TemperatureMin,
+			TemperatureMax: row.TemperatureMax,
+		},
+		SensorReadings: sensorReadings,
+	}, nil
+}
+
+func toWeatherDataRows(rows []weatherDataRow) ([]WeatherDataRow, error) {
+	var measurements []WeatherDataRow
+	for _, row := range rows {
+		measurement, err := row.toWeatherDataRow()
+		if err != nil {
+			return nil, err
+		}
+		measurements = append(measurements, measurement)
+	}
+
+	return measurements, nil
+}
+
+// sensorReadingsColumn encodes the readings of additional sensors for storage, returning nil when there are none.
+func sensorReadingsColumn(readings map[string]SensorReadings) (*string, error) {
+	if len(readings) == 0 {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(readings)
+	if err != nil {
+		return nil, err
+	}
+
+	column := string(encoded)
+	return &column, nil
+}
+
+// DataStore is responsible for persisting and reading data from storage. Implementations must keep timestamps unique,
+// returning ErrDuplicateObservation from Write for a timestamp that is already stored, and return rows in ascending
+// timestamp order. Rows read back from a DataStore carry their ID, which is what UpdatePublished acknowledges so that
+// rows written after ReadUnpublished are never marked as published without having been sent. WriteBatch writes many
+// rows at once, e.g. for importing history, skipping rows for timestamps already stored and returning how many were
+// written. All methods give up and return the context's error once it is done.
 type DataStore interface {
-	Write(WeatherDataRow) error
-	ReadUnpublished() ([]WeatherDataRow, error)
-	UpdatePublished(minTimestamp, maxTimestamp int64) error
+	Write(ctx context.Context, row WeatherDataRow) error
+	WriteBatch(ctx context.Context, rows []WeatherDataRow, published bool) (int, error)
+	ReadUnpublished(ctx context.Context) ([]WeatherDataRow, error)
+	ReadRange(ctx context.Context, from, to int64) ([]WeatherDataRow, error)
+	UpdatePublished(ctx context.Context, ids []int64) error
 }
 
 // SqliteDataStore is an implementation of a DataStore that uses Sqlite statement syntax.
@@ -55,9 +146,28 @@ func NewSqliteDataStore(db *sqlx.DB) *SqliteDataStore {
 	}
 }
 
+// checkInserted converts an insert which was ignored due to a timestamp conflict into ErrDuplicateObservation.
+func checkInserted(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrDuplicateObservation
+	}
+
+	return nil
+}
+
 // Write persists the row to disk.
-func (sds *SqliteDataStore) Write(row WeatherDataRow) error {
-	_, err := sds.db.Exec(stmtInsertDataRow,
+func (sds *SqliteDataStore) Write(ctx context.Context, row WeatherDataRow) error {
+	sensorReadings, err := sensorReadingsColumn(row.SensorReadings)
+	if err != nil {
+		return err
+	}
+
+	result, err := sds.db.ExecContext(ctx, stmtInsertDataRow,
 		row.Timestamp,
 		row.WindReadings.Speed,
 		row.WindReadings.Direction,
@@ -66,59 +176,163 @@ func (sds *SqliteDataStore) Write(row WeatherDataRow) error {
 		row.AtmosReadings.Temperature,
 		row.AtmosReadings.Humidity,
 		row.AtmosReadings.Pressure,
+		row.AtmosReadings.TemperatureMin,
+		row.AtmosReadings.TemperatureMax,
+		sensorReadings,
 		row.IntervalSeconds,
 	)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return checkInserted(result)
+}
+
+// WriteBatch persists the rows to disk in a single transaction, skipping any that already exist.
+func (sds *SqliteDataStore) WriteBatch(ctx context.Context, rows []WeatherDataRow, published bool) (int, error) {
+	return writeBatch(ctx, sds.db, stmtInsertPublishedDataRow, rows, published)
+}
+
+func writeBatch(ctx context.Context, db *sqlx.DB, stmt string, rows []WeatherDataRow, published bool) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	insert, err := tx.PrepareContext(ctx, stmt)
+	if err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	written := 0
+	for _, row := range rows {
+		sensorReadings, err := sensorReadingsColumn(row.SensorReadings)
+		if err != nil {
+			if closeErr := insert.Close(); closeErr != nil {
+				log.WithError(closeErr).
+					WithField("component", "DataStore").
+					Error("failed to close statement")
+			}
+			rollback(tx)
+			return 0, err
+		}
+
+		result, err := insert.ExecContext(ctx,
+			row.Timestamp,
+			row.WindReadings.Speed,
+			row.WindReadings.Direction,
+			row.WindReadings.Gust,
+			row.RainReadings.Rainfall,
+			row.AtmosReadings.Temperature,
+			row.AtmosReadings.Humidity,
+			row.AtmosReadings.Pressure,
+			row.AtmosReadings.TemperatureMin,
+			row.AtmosReadings.TemperatureMax,
+			sensorReadings,
+			row.IntervalSeconds,
+			published,
+		)
+		if err == nil {
+			err = checkInserted(result)
+		}
+		if errors.Is(err, ErrDuplicateObservation) {
+			continue
+		}
+		if err != nil {
+			if closeErr := insert.Close(); closeErr != nil {
+				log.WithError(closeErr).
+					WithField("component", "DataStore").
+					Error("failed to close statement")
+			}
+			rollback(tx)
+			return 0, err
+		}
+
+		written++
+	}
+
+	if err := insert.Close(); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return written, nil
 }
 
 // ReadUnpublished reads all of the unpublished rows from the database.
-func (sds *SqliteDataStore) ReadUnpublished() ([]WeatherDataRow, error) {
+func (sds *SqliteDataStore) ReadUnpublished(ctx context.Context) ([]WeatherDataRow, error) {
 	var rows []weatherDataRow
-	err := sds.db.Select(&rows, queryFetchUnpublishedDataRow)
+	err := sds.db.SelectContext(ctx, &rows, queryFetchUnpublishedDataRow)
 	if err != nil {
 		return nil, err
 	}
 
-	var measurements []WeatherDataRow
-	for _, row := range rows {
-		measurements = append(measurements, WeatherDataRow{
-			Timestamp:       row.Timestamp,
-			IntervalSeconds: row.IntervalSeconds,
-			WindReadings: WindReadings{
-				Speed:     row.WindSpeed,
-				Direction: row.WindDirection,
-				Gust:      row.WindGust,
-			},
-			RainReadings: RainReadings{
-				Rainfall: row.Rainfall,
-			},
-			AtmosReadings: AtmoshphericReadings{
-				Temperature: row.Temperature,
-				Humidity:    row.Humidity,
-				Pressure:    row.Pressure,
-			},
-		})
+	return toWeatherDataRows(rows)
+}
+
+// ReadRange reads all rows with a timestamp from (inclusive) up to to (exclusive) from the database.
+func (sds *SqliteDataStore) ReadRange(ctx context.Context, from, to int64) ([]WeatherDataRow, error) {
+	var rows []weatherDataRow
+	err := sds.db.SelectContext(ctx, &rows, queryFetchRangeDataRow, from, to)
+	if err != nil {
+		return nil, err
 	}
 
-	return measurements, nil
+	return toWeatherDataRows(rows)
 }
 
-// UpdatePublished sets all rows to published where timestamp is between the bounds.
-func (sds *SqliteDataStore) UpdatePublished(minTimestamp, maxTimestamp int64) error {
-	_, err := sds.db.Exec(stmtUpdateDataRow, minTimestamp, maxTimestamp)
+// UpdatePublished sets the rows with the given IDs to published.
+func (sds *SqliteDataStore) UpdatePublished(ctx context.Context, ids []int64) error {
+	err := sds.updatePublished(ctx, ids)
 	if err != nil {
 		// This doesn't matter too much, we'll just end up resending data upstream which can deal with not duplicating
 		// data.
 		log.WithError(err).
 			WithField("component", "SqliteDataStore").
-			WithField("event", "ReadUnpublished").
+			WithField("event", "UpdatePublished").
 			Error("failed to update published rows")
 		return err
 	}
 
 	return nil
 }
+
+func (sds *SqliteDataStore) updatePublished(ctx context.Context, ids []int64) error {
+	tx, err := sds.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(ids); start += sqliteMaxUpdateBatch {
+		end := start + sqliteMaxUpdateBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		query, args, err := sqlx.In(stmtUpdateDataRow, ids[start:end])
+		if err != nil {
+			rollback(tx)
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func rollback(tx *sqlx.Tx) {
+	if err := tx.Rollback(); err != nil {
+		log.WithError(err).
+			WithField("component", "DataStore").
+			Error("failed to rollback transaction")
+	}
+}
@@ -0,0 +1,652 @@
+package weatherstn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dataStoreFactory creates a new, empty, DataStore for a single conformance test along with a function to release it.
+type dataStoreFactory func(t *testing.T) (DataStore, func())
+
+// testDataStoreConformance is the set of behaviours that every DataStore implementation must satisfy.
+func testDataStoreConformance(t *testing.T, newStore dataStoreFactory) {
+	t.Run("ReadUnpublishedEmpty", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+
+		rows, err := store.ReadUnpublished(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read unpublished: %v", err)
+		}
+
+		if len(rows) != 0 {
+			t.Fatalf("expected no rows but got %d", len(rows))
+		}
+	})
+
+	t.Run("WriteReadsInTimestampOrder", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		// Write in reverse so that ordering has to come from the store.
+		for i := len(dataset) - 1; i >= 0; i-- {
+			if err := store.Write(context.Background(), dataset[i]); err != nil {
+				t.Fatalf("failed to write to data store: %v", err)
+			}
+		}
+
+		assertUnpublished(t, store, dataset)
+	})
+
+	t.Run("WriteDuplicateTimestamp", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		if err := store.Write(context.Background(), dataset[0]); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+
+		duplicate := dataset[1]
+		duplicate.Timestamp = dataset[0].Timestamp
+		err := store.Write(context.Background(), duplicate)
+		if !errors.Is(err, ErrDuplicateObservation) {
+			t.Fatalf("expected ErrDuplicateObservation but got %v", err)
+		}
+
+		assertUnpublished(t, store, dataset[:1])
+	})
+
+	t.Run("TemperatureRange", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		min, max := -2.5, 4.25
+		dataset[1].AtmosReadings.TemperatureMin = &min
+		dataset[1].AtmosReadings.TemperatureMax = &max
+		for _, row := range dataset[:3] {
+			if err := store.Write(context.Background(), row); err != nil {
+				t.Fatalf("failed to write to data store: %v", err)
+			}
+		}
+
+		// Rows without a range read back as nil rather than zero.
+		assertUnpublished(t, store, dataset[:3])
+	})
+
+	t.Run("SensorReadings", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		dataset[0].SensorReadings = map[string]SensorReadings{
+			"ground": {AtmosReadings: &AtmoshphericReadings{Temperature: 4.5, Humidity: 91, Pressure: 1003.25}},
+			"mast":   {WindReadings: &WindReadings{Speed: 12.5, Direction: 225, Gust: 20}},
+		}
+		if err := store.Write(context.Background(), dataset[0]); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+		if _, err := store.WriteBatch(context.Background(), dataset[1:3], false); err != nil {
+			t.Fatalf("failed to write batch to data store: %v", err)
+		}
+
+		assertUnpublished(t, store, dataset[:3])
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := store.Write(ctx, dataset[0]); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected write to fail with context.Canceled but got %v", err)
+		}
+		if _, err := store.WriteBatch(ctx, dataset[1:3], false); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected batch write to fail with context.Canceled but got %v", err)
+		}
+		if _, err := store.ReadUnpublished(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected read to fail with context.Canceled but got %v", err)
+		}
+
+		assertUnpublished(t, store, nil)
+	})
+
+	t.Run("WriteBatch", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		if err := store.Write(context.Background(), dataset[4]); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+
+		// The existing row is skipped and the published rows never show up as unpublished.
+		written, err := store.WriteBatch(context.Background(), dataset[:8], true)
+		if err != nil {
+			t.Fatalf("failed to write batch to data store: %v", err)
+		}
+		if written != 7 {
+			t.Fatalf("expected 7 rows to be written but was %d", written)
+		}
+
+		written, err = store.WriteBatch(context.Background(), dataset[6:], false)
+		if err != nil {
+			t.Fatalf("failed to write batch to data store: %v", err)
+		}
+		if written != len(dataset)-8 {
+			t.Fatalf("expected %d rows to be written but was %d", len(dataset)-8, written)
+		}
+
+		expected := append([]WeatherDataRow{dataset[4]}, dataset[8:]...)
+		assertUnpublished(t, store, expected)
+
+		rows, err := store.ReadRange(context.Background(), 0, dataset[len(dataset)-1].Timestamp+1)
+		if err != nil {
+			t.Fatalf("failed to read range: %v", err)
+		}
+
+		assertRows(t, rows, dataset)
+	})
+
+	t.Run("UpdatePublished", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		for _, row := range dataset {
+			if err := store.Write(context.Background(), row); err != nil {
+				t.Fatalf("failed to write to data store: %v", err)
+			}
+		}
+
+		unpublished, err := store.ReadUnpublished(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read unpublished: %v", err)
+		}
+
+		err = store.UpdatePublished(context.Background(), rowIDs(unpublished[2:len(unpublished)-1]))
+		if err != nil {
+			t.Fatalf("failed to update published: %v", err)
+		}
+
+		assertUnpublished(t, store, []WeatherDataRow{dataset[0], dataset[1], dataset[len(dataset)-1]})
+	})
+
+	t.Run("UpdatePublishedIgnoresRowsWrittenAfterRead", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		backfilled := dataset[3]
+		late := dataset[len(dataset)-1]
+		for _, row := range dataset {
+			if row.Timestamp == backfilled.Timestamp || row.Timestamp == late.Timestamp {
+				continue
+			}
+			if err := store.Write(context.Background(), row); err != nil {
+				t.Fatalf("failed to write to data store: %v", err)
+			}
+		}
+
+		unpublished, err := store.ReadUnpublished(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read unpublished: %v", err)
+		}
+
+		// A backfilled row inside the timestamp range that was read and a row newer than all of them.
+		if err := store.Write(context.Background(), backfilled); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+		if err := store.Write(context.Background(), late); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+
+		err = store.UpdatePublished(context.Background(), rowIDs(unpublished))
+		if err != nil {
+			t.Fatalf("failed to update published: %v", err)
+		}
+
+		assertUnpublished(t, store, []WeatherDataRow{backfilled, late})
+	})
+
+	t.Run("ConcurrentWritesDuringPublish", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+
+		const numRows = 200
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			for i := 0; i < numRows; i++ {
+				// Alternate between rows at the end of the series and rows backfilled in the middle of it.
+				timestamp := int64(1580000000 + i*60)
+				if i%2 == 1 {
+					timestamp = int64(1580000000 + (numRows-i)*60 + 30)
+				}
+				if err := store.Write(context.Background(), WeatherDataRow{Timestamp: timestamp, IntervalSeconds: 60}); err != nil {
+					t.Errorf("failed to write to data store: %v", err)
+					return
+				}
+			}
+		}()
+
+		published := make(map[int64]bool)
+		publish := func() {
+			unpublished, err := store.ReadUnpublished(context.Background())
+			if err != nil {
+				t.Fatalf("failed to read unpublished: %v", err)
+			}
+			if err := store.UpdatePublished(context.Background(), rowIDs(unpublished)); err != nil {
+				t.Fatalf("failed to update published: %v", err)
+			}
+			for _, row := range unpublished {
+				published[row.Timestamp] = true
+			}
+		}
+
+		for done := false; !done; {
+			select {
+			case <-written:
+				done = true
+			default:
+			}
+			publish()
+		}
+
+		unpublished, err := store.ReadUnpublished(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read unpublished: %v", err)
+		}
+
+		if len(unpublished) != 0 {
+			t.Fatalf("expected all rows to be published but %d were not", len(unpublished))
+		}
+
+		if len(published) != numRows {
+			t.Fatalf("expected %d rows to have been read for publishing but got %d", numRows, len(published))
+		}
+	})
+
+	t.Run("ReadRange", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		for i := len(dataset) - 1; i >= 0; i-- {
+			if err := store.Write(context.Background(), dataset[i]); err != nil {
+				t.Fatalf("failed to write to data store: %v", err)
+			}
+		}
+
+		// Publishing state must not affect range reads.
+		unpublished, err := store.ReadUnpublished(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read unpublished: %v", err)
+		}
+		if err := store.UpdatePublished(context.Background(), rowIDs(unpublished[:4])); err != nil {
+			t.Fatalf("failed to update published: %v", err)
+		}
+
+		rows, err := store.ReadRange(context.Background(), dataset[2].Timestamp, dataset[7].Timestamp)
+		if err != nil {
+			t.Fatalf("failed to read range: %v", err)
+		}
+
+		assertRows(t, rows, dataset[2:7])
+	})
+
+	t.Run("ConcurrentWrites", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		dataset := loadConformanceDataset(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(dataset))
+		for _, row := range dataset {
+			wg.Add(1)
+			go func(row WeatherDataRow) {
+				defer wg.Done()
+				errs <- store.Write(context.Background(), row)
+			}(row)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("failed to write to data store: %v", err)
+			}
+		}
+
+		assertUnpublished(t, store, dataset)
+	})
+
+	t.Run("PublishLog", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		history := publishHistory(t, store)
+
+		attempts := []PublishAttempt{
+			{Time: 200, Target: "https://anearbyserver:111/observations", Rows: 2, FirstID: 3, LastID: 4,
+				FirstTimestamp: 1548884166, LastTimestamp: 1548884196, Bytes: 512, StatusCode: 500, LatencyMillis: 80,
+				Error: "unexpected status code 500"},
+			{Time: 100, Target: "https://anearbyserver:111/observations", Rows: 2, FirstID: 1, LastID: 2,
+				FirstTimestamp: 1548884106, LastTimestamp: 1548884136, Bytes: 498, StatusCode: 201, LatencyMillis: 120},
+			{Time: 300, Target: "https://anearbyserver:111/observations", Rows: 2, FirstID: 3, LastID: 4,
+				FirstTimestamp: 1548884166, LastTimestamp: 1548884196, Bytes: 512, LatencyMillis: 30000,
+				Error: "context deadline exceeded"},
+		}
+		for _, attempt := range attempts {
+			if err := history.RecordPublish(context.Background(), attempt); err != nil {
+				t.Fatalf("failed to record publish attempt: %v", err)
+			}
+		}
+
+		logged, err := history.ReadPublishLog(context.Background(), 100, 300)
+		if err != nil {
+			t.Fatalf("failed to read publish log: %v", err)
+		}
+		if len(logged) != 2 {
+			t.Fatalf("expected 2 attempts in range but got %d", len(logged))
+		}
+		for i, expected := range []PublishAttempt{attempts[1], attempts[0]} {
+			if logged[i].ID == 0 {
+				t.Fatalf("expected attempt %d to have an ID", i)
+			}
+			expected.ID = logged[i].ID
+			if !reflect.DeepEqual(logged[i], expected) {
+				t.Fatalf("expected attempt %d to be %#v but was %#v", i, expected, logged[i])
+			}
+		}
+
+		pruned, err := history.PrunePublishLog(context.Background(), 200)
+		if err != nil || pruned != 1 {
+			t.Fatalf("expected to prune 1 attempt but got %d (%v)", pruned, err)
+		}
+		if err := history.RecordPublish(context.Background(), attempts[1]); err != nil {
+			t.Fatalf("failed to record publish attempt: %v", err)
+		}
+		logged, err = history.ReadPublishLog(context.Background(), 0, math.MaxInt64)
+		if err != nil {
+			t.Fatalf("failed to read publish log: %v", err)
+		}
+		if len(logged) != 3 || logged[0].Time != 100 || logged[1].Time != 200 || logged[2].Time != 300 ||
+			logged[0].ID == logged[1].ID || logged[0].ID == logged[2].ID {
+			t.Fatalf("expected the pruned attempt to be gone and the new one to have its own ID but got %#v", logged)
+		}
+	})
+
+	t.Run("Requeue", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		history := publishHistory(t, store)
+		dataset := loadConformanceDataset(t)
+
+		if _, err := store.WriteBatch(context.Background(), dataset, true); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+
+		requeued, err := history.Requeue(context.Background(), dataset[2].Timestamp, dataset[5].Timestamp)
+		if err != nil {
+			t.Fatalf("failed to requeue: %v", err)
+		}
+		if requeued != 3 {
+			t.Fatalf("expected 3 rows to be requeued but got %d", requeued)
+		}
+		assertUnpublished(t, store, dataset[2:5])
+
+		// Rows which are already waiting to be published aren't counted again.
+		requeued, err = history.Requeue(context.Background(), dataset[0].Timestamp, dataset[5].Timestamp)
+		if err != nil {
+			t.Fatalf("failed to requeue: %v", err)
+		}
+		if requeued != 2 {
+			t.Fatalf("expected 2 more rows to be requeued but got %d", requeued)
+		}
+		assertUnpublished(t, store, dataset[:5])
+	})
+
+	t.Run("RequeueAttempt", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		history := publishHistory(t, store)
+		dataset := loadConformanceDataset(t)
+
+		if _, err := store.WriteBatch(context.Background(), dataset, true); err != nil {
+			t.Fatalf("failed to write to data store: %v", err)
+		}
+		rows, err := store.ReadRange(context.Background(), 0, math.MaxInt64)
+		if err != nil {
+			t.Fatalf("failed to read rows: %v", err)
+		}
+
+		// The attempt's ID range also covers a row which it didn't send, as when history is imported between pushes.
+		sent := []WeatherDataRow{rows[1], rows[3]}
+		if err := history.RecordPublish(context.Background(), newPublishAttempt(sent)); err != nil {
+			t.Fatalf("failed to record publish attempt: %v", err)
+		}
+		logged, err := history.ReadPublishLog(context.Background(), 0, math.MaxInt64)
+		if err != nil || len(logged) != 1 {
+			t.Fatalf("expected the attempt to be logged but got %d (%v)", len(logged), err)
+		}
+		attempt, err := history.ReadPublishAttempt(context.Background(), logged[0].ID)
+		if err != nil || !reflect.DeepEqual(attempt, logged[0]) {
+			t.Fatalf("expected to read %#v but got %#v (%v)", logged[0], attempt, err)
+		}
+		if _, err := history.ReadPublishAttempt(context.Background(), logged[0].ID+1); !errors.Is(err,
+			ErrPublishAttemptNotFound) {
+			t.Fatalf("expected ErrPublishAttemptNotFound for an unknown attempt but got %v", err)
+		}
+
+		if attempt.FirstID != rows[1].ID || attempt.LastID != rows[3].ID {
+			t.Fatalf("expected the attempt to range over ids %d-%d but was %d-%d", rows[1].ID, rows[3].ID,
+				attempt.FirstID, attempt.LastID)
+		}
+
+		requeued, err := history.RequeueAttempt(context.Background(), attempt.ID)
+		if err != nil {
+			t.Fatalf("failed to requeue: %v", err)
+		}
+		if requeued != 2 {
+			t.Fatalf("expected 2 rows to be requeued but got %d", requeued)
+		}
+		assertUnpublished(t, store, []WeatherDataRow{dataset[1], dataset[3]})
+	})
+
+	t.Run("StationObservations", func(t *testing.T) {
+		store, closeStore := newStore(t)
+		defer closeStore()
+		collector := collectorStore(t, store)
+		dataset := loadConformanceDataset(t)
+
+		latitude, longitude := 51.501, -0.142
+		allotment := StationInfo{
+			StationConfig: StationConfig{ID: "allotment", Name: "Allotment", Latitude: &latitude,
+				Longitude: &longitude, SensorHeights: map[string]float64{"screen": 1.5}, Timezone: "Europe/London"},
+			Software: "v1.2.3",
+		}
+		roof := StationInfo{StationConfig: StationConfig{ID: "roof", Timezone: "UTC"}, Software: "v1.2.3"}
+
+		written, err := collector.WriteStationBatch(context.Background(), allotment, dataset[:4])
+		if err != nil || written != 4 {
+			t.Fatalf("expected 4 rows to be written but got %d (%v)", written, err)
+		}
+
+		// Resent rows are skipped per station, the same timestamps from another station are kept.
+		written, err = collector.WriteStationBatch(context.Background(), allotment, dataset[2:])
+		if err != nil || written != len(dataset)-4 {
+			t.Fatalf("expected %d new rows to be written but got %d (%v)", len(dataset)-4, written, err)
+		}
+		written, err = collector.WriteStationBatch(context.Background(), roof, dataset[:2])
+		if err != nil || written != 2 {
+			t.Fatalf("expected 2 rows to be written for another station but got %d (%v)", written, err)
+		}
+
+		rows, err := collector.ReadStationRange(context.Background(), "allotment", dataset[1].Timestamp,
+			dataset[5].Timestamp)
+		if err != nil {
+			t.Fatalf("failed to read station range: %v", err)
+		}
+		assertRows(t, rows, dataset[1:5])
+
+		// The station's own observations are kept apart.
+		if unpublished, err := store.ReadUnpublished(context.Background()); err != nil || len(unpublished) != 0 {
+			t.Fatalf("expected the station's own observations to be empty but got %d (%v)", len(unpublished), err)
+		}
+
+		latest, err := collector.ReadLatestStationRows(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read latest station rows: %v", err)
+		}
+		if len(latest) != 2 {
+			t.Fatalf("expected the latest rows of 2 stations but got %d", len(latest))
+		}
+		assertRows(t, []WeatherDataRow{latest["allotment"], latest["roof"]},
+			[]WeatherDataRow{dataset[len(dataset)-1], dataset[1]})
+
+		stations, err := collector.ReadStations(context.Background())
+		if err != nil {
+			t.Fatalf("failed to read stations: %v", err)
+		}
+		if len(stations) != 2 || stations[0].LastSeen == 0 {
+			t.Fatalf("expected both stations to have been seen but got %#v", stations)
+		}
+		if !reflect.DeepEqual(stations[0].StationInfo, allotment) || !reflect.DeepEqual(stations[1].StationInfo, roof) {
+			t.Fatalf("expected stations to be %#v and %#v but were %#v", allotment, roof, stations)
+		}
+	})
+}
+
+// collectorStore returns the store as a CollectorStore, which every DataStore in this package implements.
+func collectorStore(t *testing.T, store DataStore) CollectorStore {
+	collector, ok := store.(CollectorStore)
+	if !ok {
+		t.Fatalf("expected %T to store observations from many stations", store)
+	}
+
+	return collector
+}
+
+// publishHistory returns the store as a PublishHistory, which every DataStore in this package implements.
+func publishHistory(t *testing.T, store DataStore) PublishHistory {
+	history, ok := store.(PublishHistory)
+	if !ok {
+		t.Fatalf("expected %T to keep a publish history", store)
+	}
+
+	return history
+}
+
+func loadConformanceDataset(t *testing.T) []WeatherDataRow {
+	var dataset []WeatherDataRow
+	err := loadJSONTestDataset("unpublished_observations", &dataset)
+	if err != nil {
+		t.Fatalf("unexpected error loading dataset: %v", err)
+	}
+
+	return dataset
+}
+
+func rowIDs(rows []WeatherDataRow) []int64 {
+	ids := make([]int64, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+
+	return ids
+}
+
+// assertUnpublished checks that the unpublished rows in the store match expected.
+func assertUnpublished(t *testing.T, store DataStore, expected []WeatherDataRow) {
+	unpublished, err := store.ReadUnpublished(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read unpublished: %v", err)
+	}
+
+	assertRows(t, unpublished, expected)
+}
+
+// assertRows checks that rows read from a store match expected, ignoring the store assigned IDs other than checking
+// that they are set and unique.
+func assertRows(t *testing.T, rows []WeatherDataRow, expected []WeatherDataRow) {
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %d rows but got %d", len(expected), len(rows))
+	}
+
+	ids := make(map[int64]bool)
+	for i, d := range expected {
+		row := rows[i]
+		if row.ID == 0 || ids[row.ID] {
+			t.Fatalf("expected observation %d to have a unique ID but was %d", i, row.ID)
+		}
+		ids[row.ID] = true
+
+		row.ID = d.ID
+		if !reflect.DeepEqual(d, row) {
+			t.Fatalf("expected observation %d to be %#v but was %#v", i, d, row)
+		}
+	}
+}
+
+func TestMemoryDataStore_Conformance(t *testing.T) {
+	testDataStoreConformance(t, func(t *testing.T) (DataStore, func()) {
+		return NewMemoryDataStore(), func() {}
+	})
+}
+
+func TestSqliteDataStore_Conformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	n := 0
+
+	testDataStoreConformance(t, func(t *testing.T) (DataStore, func()) {
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("weather-%d.db", n))
+
+		migrator, err := NewMigrator(DatabaseConfig{Path: path})
+		if err != nil {
+			t.Fatalf("unexpected error creating migrator: %v", err)
+		}
+		if err := migrator.EnsureLatest(); err != nil {
+			t.Fatalf("unexpected error migrating schema: %v", err)
+		}
+		migrator.Close()
+
+		db, err := sqlx.Open("sqlite3", path)
+		if err != nil {
+			t.Fatalf("unexpected error opening database: %v", err)
+		}
+		return NewSqliteDataStore(db), func() {
+			db.Close()
+		}
+	})
+}
+
+func TestPostgresDataStore_Conformance(t *testing.T) {
+	testDataStoreConformance(t, func(t *testing.T) (DataStore, func()) {
+		db := openTestPostgres(t)
+
+		return NewPostgresDataStore(db), func() {
+			db.Close()
+		}
+	})
+}
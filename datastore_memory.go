@@ -0,0 +1,142 @@
+package weatherstn
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type memoryDataRow struct {
+	row       WeatherDataRow
+	published bool
+}
+
+// MemoryDataStore is an implementation of a DataStore that keeps all rows in memory. It has the same semantics as the
+// SqliteDataStore and is safe for concurrent use, making it suitable for tests and ephemeral deployments where losing
+// data on restart is acceptable.
+type MemoryDataStore struct {
+	rows           map[int64]*memoryDataRow // keyed by timestamp
+	ids            map[int64]*memoryDataRow
+	lastID         int64
+	publishLog     []PublishAttempt
+	lastLogID      int64
+	publishLogRows map[int64][]int64 // IDs of the rows sent keyed by attempt ID
+	stations       map[string]CollectedStation
+	stationRows    map[string]map[int64]WeatherDataRow // keyed by station ID and then timestamp
+	lock           sync.RWMutex
+}
+
+// NewMemoryDataStore creates a new, empty, MemoryDataStore.
+func NewMemoryDataStore() *MemoryDataStore {
+	return &MemoryDataStore{
+		rows:           make(map[int64]*memoryDataRow),
+		ids:            make(map[int64]*memoryDataRow),
+		publishLogRows: make(map[int64][]int64),
+		stations:       make(map[string]CollectedStation),
+		stationRows:    make(map[string]map[int64]WeatherDataRow),
+	}
+}
+
+// Write stores the row, returning ErrDuplicateObservation if a row with the same timestamp already exists.
+func (mds *MemoryDataStore) Write(ctx context.Context, row WeatherDataRow) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	return mds.write(row, false)
+}
+
+// WriteBatch stores the rows, skipping any for timestamps that already exist.
+func (mds *MemoryDataStore) WriteBatch(ctx context.Context, rows []WeatherDataRow, published bool) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	written := 0
+	for _, row := range rows {
+		if err := mds.write(row, published); err == nil {
+			written++
+		}
+	}
+
+	return written, nil
+}
+
+func (mds *MemoryDataStore) write(row WeatherDataRow, published bool) error {
+	if _, ok := mds.rows[row.Timestamp]; ok {
+		return ErrDuplicateObservation
+	}
+
+	mds.lastID++
+	row.ID = mds.lastID
+
+	stored := &memoryDataRow{row: row, published: published}
+	mds.rows[row.Timestamp] = stored
+	mds.ids[row.ID] = stored
+
+	return nil
+}
+
+// ReadUnpublished returns all of the unpublished rows ordered by timestamp.
+func (mds *MemoryDataStore) ReadUnpublished(ctx context.Context) ([]WeatherDataRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return mds.read(func(row *memoryDataRow) bool {
+		return !row.published
+	}), nil
+}
+
+// ReadRange returns all rows with a timestamp from (inclusive) up to to (exclusive) ordered by timestamp.
+func (mds *MemoryDataStore) ReadRange(ctx context.Context, from, to int64) ([]WeatherDataRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return mds.read(func(row *memoryDataRow) bool {
+		return row.row.Timestamp >= from && row.row.Timestamp < to
+	}), nil
+}
+
+func (mds *MemoryDataStore) read(match func(row *memoryDataRow) bool) []WeatherDataRow {
+	mds.lock.RLock()
+	defer mds.lock.RUnlock()
+
+	var measurements []WeatherDataRow
+	for _, row := range mds.rows {
+		if match(row) {
+			measurements = append(measurements, row.row)
+		}
+	}
+
+	sort.Slice(measurements, func(i, j int) bool {
+		return measurements[i].Timestamp < measurements[j].Timestamp
+	})
+
+	return measurements
+}
+
+// UpdatePublished sets the rows with the given IDs to published.
+func (mds *MemoryDataStore) UpdatePublished(ctx context.Context, ids []int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	for _, id := range ids {
+		if row, ok := mds.ids[id]; ok {
+			row.published = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package weatherstn
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	stmtPostgresInsertDataRow = "INSERT INTO observations (timestamp, wind_speed, wind_direction, wind_gust_speed," +
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, interval_secs) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) ON CONFLICT(timestamp) DO NOTHING;"
+	stmtPostgresInsertPublishedDataRow = "INSERT INTO observations (timestamp, wind_speed, wind_direction, " +
+		"wind_gust_speed, rainfall, temperature, humidity, pressure, temperature_min, temperature_max, " +
+		"sensor_readings, interval_secs, published) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) " +
+		"ON CONFLICT(timestamp) DO NOTHING;"
+	queryPostgresFetchUnpublishedDataRow = "SELECT id, timestamp, wind_speed, wind_direction, wind_gust_speed," +
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, interval_secs " +
+		"FROM observations WHERE published=false ORDER BY timestamp ASC;"
+	queryPostgresFetchRangeDataRow = "SELECT id, timestamp, wind_speed, wind_direction, wind_gust_speed," +
+		"rainfall, temperature, humidity, pressure, temperature_min, temperature_max, sensor_readings, interval_secs " +
+		"FROM observations WHERE timestamp >= $1 AND timestamp < $2 ORDER BY timestamp ASC;"
+	stmtPostgresUpdateDataRow = "UPDATE observations SET published=true WHERE id = ANY($1);"
+)
+
+// PostgresDataStore is an implementation of a DataStore that uses PostgreSQL statement syntax. It works equally
+// against plain PostgreSQL and TimescaleDB, the postgres migrations take care of creating the hypertable when the
+// extension is available.
+type PostgresDataStore struct {
+	db *sqlx.DB
+}
+
+// NewPostgresDataStore creates a new PostgresDataStore.
+func NewPostgresDataStore(db *sqlx.DB) *PostgresDataStore {
+	return &PostgresDataStore{
+		db: db,
+	}
+}
+
+// Write persists the row to the database.
+func (pds *PostgresDataStore) Write(ctx context.Context, row WeatherDataRow) error {
+	sensorReadings, err := sensorReadingsColumn(row.SensorReadings)
+	if err != nil {
+		return err
+	}
+
+	result, err := pds.db.ExecContext(ctx, stmtPostgresInsertDataRow,
+		row.Timestamp,
+		row.WindReadings.Speed,
+		row.WindReadings.Direction,
+		row.WindReadings.Gust,
+		row.RainReadings.Rainfall,
+		row.AtmosReadings.Temperature,
+		row.AtmosReadings.Humidity,
+		row.AtmosReadings.Pressure,
+		row.AtmosReadings.TemperatureMin,
+		row.AtmosReadings.TemperatureMax,
+		sensorReadings,
+		row.IntervalSeconds,
+	)
+	if err != nil {
+		return err
+	}
+
+	return checkInserted(result)
+}
+
+// WriteBatch persists the rows to the database in a single transaction, skipping any that already exist.
+func (pds *PostgresDataStore) WriteBatch(ctx context.Context, rows []WeatherDataRow, published bool) (int, error) {
+	return writeBatch(ctx, pds.db, stmtPostgresInsertPublishedDataRow, rows, published)
+}
+
+// ReadUnpublished reads all of the unpublished rows from the database.
+func (pds *PostgresDataStore) ReadUnpublished(ctx context.Context) ([]WeatherDataRow, error) {
+	var rows []weatherDataRow
+	err := pds.db.SelectContext(ctx, &rows, queryPostgresFetchUnpublishedDataRow)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWeatherDataRows(rows)
+}
+
+// ReadRange reads all rows with a timestamp from (inclusive) up to to (exclusive) from the database.
+func (pds *PostgresDataStore) ReadRange(ctx context.Context, from, to int64) ([]WeatherDataRow, error) {
+	var rows []weatherDataRow
+	err := pds.db.SelectContext(ctx, &rows, queryPostgresFetchRangeDataRow, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return toWeatherDataRows(rows)
+}
+
+// UpdatePublished sets the rows with the given IDs to published.
+func (pds *PostgresDataStore) UpdatePublished(ctx context.Context, ids []int64) error {
+	_, err := pds.db.ExecContext(ctx, stmtPostgresUpdateDataRow, pq.Array(ids))
+	if err != nil {
+		log.WithError(err).
+			WithField("component", "PostgresDataStore").
+			WithField("event", "UpdatePublished").
+			Error("failed to update published rows")
+		return err
+	}
+
+	return nil
+}
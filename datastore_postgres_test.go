@@ -0,0 +1,49 @@
+package weatherstn
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+const defaultTestPostgresDSN = "postgres://postgres@localhost:5432/weatherstn_test?sslmode=disable"
+
+// openTestPostgres connects to a local postgres, resetting the schema with the postgres migrations. Tests calling
+// this are skipped when no server is available, WEATHERSTN_TEST_POSTGRES_DSN can be used to point at a specific one.
+func openTestPostgres(t *testing.T) *sqlx.DB {
+	dsn := os.Getenv("WEATHERSTN_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		dsn = defaultTestPostgresDSN
+	}
+
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Skipf("postgres not available: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Skipf("postgres not available: %v", err)
+	}
+
+	migrator, err := NewMigrator(DatabaseConfig{Driver: DatabaseDriverPostgres, DSN: dsn})
+	if err != nil {
+		db.Close()
+		t.Fatalf("unexpected error creating migrator: %v", err)
+	}
+	defer migrator.Close()
+
+	if err := migrator.m.Down(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		db.Close()
+		t.Fatalf("unexpected error resetting schema: %v", err)
+	}
+	if err := migrator.EnsureLatest(); err != nil {
+		db.Close()
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+
+	return db
+}
@@ -1,6 +1,7 @@
 package weatherstn
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"testing"
@@ -15,16 +16,21 @@ type MockDataStore struct {
 	tmock.Mock
 }
 
-func (mds *MockDataStore) Write(row WeatherDataRow) error {
+func (mds *MockDataStore) Write(ctx context.Context, row WeatherDataRow) error {
 	args := mds.Called(row)
 	return args.Error(0)
 }
 
-func (mds *MockDataStore) UpdatePublished(minTimestamp, maxTimestamp int64) error {
-	args := mds.Called(minTimestamp, maxTimestamp)
+func (mds *MockDataStore) UpdatePublished(ctx context.Context, ids []int64) error {
+	args := mds.Called(ids)
 	return args.Error(0)
 }
 
+func (mds *MockDataStore) WriteBatch(ctx context.Context, rows []WeatherDataRow, published bool) (int, error) {
+	args := mds.Called(rows, published)
+	return args.Int(0), args.Error(1)
+}
+
 func loadJSONTestDataset(dataset string, valuePtr interface{}) error {
 	bytes, err := ioutil.ReadFile("testdata/" + dataset + ".json")
 	if err != nil {
@@ -39,11 +45,16 @@ func loadJSONTestDataset(dataset string, valuePtr interface{}) error {
 	return nil
 }
 
-func (mds *MockDataStore) ReadUnpublished() ([]WeatherDataRow, error) {
+func (mds *MockDataStore) ReadUnpublished(ctx context.Context) ([]WeatherDataRow, error) {
 	args := mds.Called()
 	return args.Get(0).([]WeatherDataRow), args.Error(1)
 }
 
+func (mds *MockDataStore) ReadRange(ctx context.Context, from, to int64) ([]WeatherDataRow, error) {
+	args := mds.Called(from, to)
+	return args.Get(0).([]WeatherDataRow), args.Error(1)
+}
+
 func newAtmosReadings(temp float64, humidity float64, pressure float64) AtmoshphericReadings {
 	return AtmoshphericReadings{
 		Temperature: temp,
@@ -90,11 +101,14 @@ func TestSqliteDataStore_Write(t *testing.T) {
 			row.AtmosReadings.Temperature,
 			row.AtmosReadings.Humidity,
 			row.AtmosReadings.Pressure,
+			row.AtmosReadings.TemperatureMin,
+			row.AtmosReadings.TemperatureMax,
+			nil,
 			row.IntervalSeconds,
 		).
 		WillReturnResult(sqlmock.NewResult(5, 1))
 
-	err = store.Write(*row)
+	err = store.Write(context.Background(), *row)
 	if err != nil {
 		t.Fatalf("failed to write to data store: %v", err)
 	}
@@ -114,14 +128,48 @@ func TestSqliteDataStore_UpdatePublished(t *testing.T) {
 	sqlxDB := sqlx.NewDb(mockDB, "sqlmock")
 	store := NewSqliteDataStore(sqlxDB)
 
-	var minTimestamp int64 = 1580339947
-	var maxTimestamp int64 = 1580347147
+	ids := []int64{3, 4, 7}
 
-	mock.ExpectExec("UPDATE observations SET published=true WHERE timestamp BETWEEN (.+)").
-		WithArgs(minTimestamp, maxTimestamp).
-		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE observations SET published=true WHERE id IN \\(\\?, \\?, \\?\\)").
+		WithArgs(ids[0], ids[1], ids[2]).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	err = store.UpdatePublished(context.Background(), ids)
+	if err != nil {
+		t.Fatalf("failed to update published with data store: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("mock received unexpected arguments: %v", err)
+	}
+}
+
+func TestSqliteDataStore_UpdatePublishedBatches(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unexpected error opening mock database: %v", err)
+	}
+	defer mockDB.Close()
+
+	sqlxDB := sqlx.NewDb(mockDB, "sqlmock")
+	store := NewSqliteDataStore(sqlxDB)
+
+	ids := make([]int64, sqliteMaxUpdateBatch+1)
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE observations SET published=true WHERE id IN").
+		WillReturnResult(sqlmock.NewResult(0, sqliteMaxUpdateBatch))
+	mock.ExpectExec("UPDATE observations SET published=true WHERE id IN \\(\\?\\)").
+		WithArgs(ids[len(ids)-1]).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
-	err = store.UpdatePublished(minTimestamp, maxTimestamp)
+	err = store.UpdatePublished(context.Background(), ids)
 	if err != nil {
 		t.Fatalf("failed to update published with data store: %v", err)
 	}
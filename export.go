@@ -0,0 +1,363 @@
+package weatherstn
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ExportFormat is a file format that observations can be exported to.
+type ExportFormat string
+
+const (
+	// ExportFormatCSV writes a header row followed by one row per observation.
+	ExportFormatCSV ExportFormat = "csv"
+
+	// ExportFormatJSONLines writes one JSON object per observation, one per line.
+	ExportFormatJSONLines ExportFormat = "jsonl"
+
+	// ExportFormatParquet writes a single parquet file with one column per field.
+	ExportFormatParquet ExportFormat = "parquet"
+)
+
+// ExportOptions controls which fields are exported and how they are presented.
+type ExportOptions struct {
+	// Fields to export in order, defaults to all fields from ExportFields.
+	Fields []string
+
+	// Units that readings are converted into, defaults to metric. The unit of each field is declared in the CSV
+	// header, in a units object on each JSON line and in the "units" metadata of parquet files.
+	Units Units
+
+	// Location used to format the time field, defaults to UTC.
+	Location *time.Location
+}
+
+type exportField struct {
+	name        string
+	parquetType string
+	quantity    string // measured by the field, for fields with a unit
+	value       func(row WeatherDataRow, loc *time.Location) interface{}
+}
+
+var exportFields = []exportField{
+	{"time", "UTF8", "", func(row WeatherDataRow, loc *time.Location) interface{} {
+		return time.Unix(row.Timestamp, 0).In(loc).Format(time.RFC3339)
+	}},
+	{"timestamp", "INT64", "", func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.Timestamp
+	}},
+	{"temperature", "DOUBLE", quantityTemperature, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.AtmosReadings.Temperature
+	}},
+	{"temperature_min", "DOUBLE", quantityTemperature, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return optionalExportValue(row.AtmosReadings.TemperatureMin)
+	}},
+	{"temperature_max", "DOUBLE", quantityTemperature, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return optionalExportValue(row.AtmosReadings.TemperatureMax)
+	}},
+	{"humidity", "DOUBLE", quantityHumidity, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.AtmosReadings.Humidity
+	}},
+	{"pressure", "DOUBLE", quantityPressure, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.AtmosReadings.Pressure
+	}},
+	{"wind_speed", "DOUBLE", quantitySpeed, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.WindReadings.Speed
+	}},
+	{"wind_direction", "FLOAT", quantityDirection, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.WindReadings.Direction
+	}},
+	{"wind_gust", "DOUBLE", quantitySpeed, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.WindReadings.Gust
+	}},
+	{"rainfall", "DOUBLE", quantityRainfall, func(row WeatherDataRow, loc *time.Location) interface{} {
+		return row.RainReadings.Rainfall
+	}},
+	{"interval_secs", "INT64", "", func(row WeatherDataRow, loc *time.Location) interface{} {
+		return int64(row.IntervalSeconds)
+	}},
+}
+
+// optionalExportValue returns the value of v, or nil for readings which were not recorded so that they are exported as
+// empty or null values rather than zero.
+func optionalExportValue(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	return *v
+}
+
+// ExportFields returns the names of all fields that can be exported, in their default order.
+func ExportFields() []string {
+	names := make([]string, len(exportFields))
+	for i, field := range exportFields {
+		names[i] = field.name
+	}
+
+	return names
+}
+
+// ParseExportFormat returns the ExportFormat with the given name.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch ExportFormat(name) {
+	case ExportFormatCSV, ExportFormatJSONLines, ExportFormatParquet:
+		return ExportFormat(name), nil
+	default:
+		return "", fmt.Errorf("unknown export format %s", name)
+	}
+}
+
+// Export writes the rows to w in the given format.
+func Export(w io.Writer, format ExportFormat, rows []WeatherDataRow, opts ExportOptions) error {
+	fields, err := selectExportFields(opts.Fields)
+	if err != nil {
+		return err
+	}
+
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	units, err := opts.Units.normalize()
+	if err != nil {
+		return err
+	}
+
+	values := func(row WeatherDataRow) []interface{} {
+		row = units.Convert(row)
+		vals := make([]interface{}, len(fields))
+		for i, field := range fields {
+			vals[i] = field.value(row, loc)
+		}
+
+		return vals
+	}
+
+	switch format {
+	case ExportFormatCSV:
+		return exportCSV(w, fields, units, rows, values)
+	case ExportFormatJSONLines:
+		return exportJSONLines(w, fields, units, rows, values)
+	case ExportFormatParquet:
+		return exportParquet(w, fields, units, rows, values)
+	default:
+		return fmt.Errorf("unknown export format %s", format)
+	}
+}
+
+func selectExportFields(names []string) ([]exportField, error) {
+	if len(names) == 0 {
+		return exportFields, nil
+	}
+
+	var fields []exportField
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, field := range exportFields {
+			if field.name == name {
+				fields = append(fields, field)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown export field %s, valid fields are %s", name,
+				strings.Join(ExportFields(), ", "))
+		}
+	}
+
+	return fields, nil
+}
+
+// exportUnits returns a JSON object of the unit of each field which has one, in field order.
+func exportUnits(fields []exportField, units Units) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for _, field := range fields {
+		if field.quantity == "" {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteString(",")
+		}
+
+		key, err := json.Marshal(field.name)
+		if err != nil {
+			return nil, err
+		}
+		unit, err := json.Marshal(units.unit(field.quantity))
+		if err != nil {
+			return nil, err
+		}
+
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(unit)
+	}
+	b.WriteString("}")
+
+	return b.Bytes(), nil
+}
+
+func exportCSV(w io.Writer, fields []exportField, units Units, rows []WeatherDataRow,
+	values func(WeatherDataRow) []interface{}) error {
+	cw := csv.NewWriter(w)
+
+	// Units are declared in the header, e.g. "temperature (F)".
+	record := make([]string, len(fields))
+	for i, field := range fields {
+		record[i] = field.name
+		if field.quantity != "" {
+			record[i] = fmt.Sprintf("%s (%s)", field.name, units.unit(field.quantity))
+		}
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, val := range values(row) {
+			record[i] = formatExportValue(val)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatExportValue(val interface{}) string {
+	switch v := val.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func exportJSONLines(w io.Writer, fields []exportField, units Units, rows []WeatherDataRow,
+	values func(WeatherDataRow) []interface{}) error {
+	unitsObject, err := exportUnits(fields, units)
+	if err != nil {
+		return err
+	}
+
+	// Objects are built by hand so that keys are written in the selected field order, followed by their units.
+	var line strings.Builder
+	for _, row := range rows {
+		line.Reset()
+		line.WriteString("{")
+		for i, val := range values(row) {
+			if i > 0 {
+				line.WriteString(",")
+			}
+
+			key, err := json.Marshal(fields[i].name)
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(val)
+			if err != nil {
+				return err
+			}
+
+			line.Write(key)
+			line.WriteString(":")
+			line.Write(value)
+		}
+		if len(unitsObject) > 2 {
+			line.WriteString(`,"units":`)
+			line.Write(unitsObject)
+		}
+		line.WriteString("}\n")
+
+		if _, err := io.WriteString(w, line.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func exportParquet(w io.Writer, fields []exportField, units Units, rows []WeatherDataRow,
+	values func(WeatherDataRow) []interface{}) error {
+	unitsObject, err := exportUnits(fields, units)
+	if err != nil {
+		return err
+	}
+
+	schema := make([]string, len(fields))
+	for i, field := range fields {
+		schema[i] = fmt.Sprintf("name=%s, type=%s", field.name, field.parquetType)
+	}
+
+	pw, err := writer.NewCSVWriter(schema, &parquetStreamFile{w: w}, 1)
+	if err != nil {
+		return err
+	}
+
+	unitsValue := string(unitsObject)
+	pw.Footer.KeyValueMetadata = append(pw.Footer.KeyValueMetadata, &parquet.KeyValue{Key: "units", Value: &unitsValue})
+
+	for _, row := range rows {
+		if err := pw.Write(values(row)); err != nil {
+			return err
+		}
+	}
+
+	return pw.WriteStop()
+}
+
+// parquetStreamFile adapts an io.Writer to the file interface used by the parquet writer, which only ever appends.
+type parquetStreamFile struct {
+	w io.Writer
+}
+
+var errParquetStreamUnsupported = errors.New("parquet stream only supports writing")
+
+func (psf *parquetStreamFile) Write(p []byte) (int, error) {
+	return psf.w.Write(p)
+}
+
+func (psf *parquetStreamFile) Read(p []byte) (int, error) {
+	return 0, errParquetStreamUnsupported
+}
+
+func (psf *parquetStreamFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errParquetStreamUnsupported
+}
+
+func (psf *parquetStreamFile) Close() error {
+	return nil
+}
+
+func (psf *parquetStreamFile) Open(name string) (source.ParquetFile, error) {
+	return nil, errParquetStreamUnsupported
+}
+
+func (psf *parquetStreamFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errParquetStreamUnsupported
+}
@@ -0,0 +1,117 @@
+package weatherstn
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport_CSV(t *testing.T) {
+	var row WeatherDataRow
+	err := loadJSONTestDataset("one_observation", &row)
+	if err != nil {
+		t.Fatalf("unexpected error loading json file: %v", err)
+	}
+
+	loc := time.FixedZone("NZDT", 13*60*60)
+
+	var buf bytes.Buffer
+	err = Export(&buf, ExportFormatCSV, []WeatherDataRow{row}, ExportOptions{
+		Fields:   []string{"time", "temperature", "wind_speed", "rainfall"},
+		Units:    ImperialUnits(),
+		Location: loc,
+	})
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	expected := "time,temperature (F),wind_speed (mph),rainfall (in)\n" +
+		"2020-01-30T12:19:07+13:00,68.36,2.6252932872027355,0.0033070866141732286\n"
+	if buf.String() != expected {
+		t.Fatalf("expected export to be %q but was %q", expected, buf.String())
+	}
+}
+
+func TestExport_JSONLines(t *testing.T) {
+	var dataset []WeatherDataRow
+	err := loadJSONTestDataset("unpublished_observations", &dataset)
+	if err != nil {
+		t.Fatalf("unexpected error loading dataset: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = Export(&buf, ExportFormatJSONLines, dataset, ExportOptions{
+		Fields: []string{"timestamp", "pressure", "wind_direction"},
+	})
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(dataset) {
+		t.Fatalf("expected %d lines but got %d", len(dataset), len(lines))
+	}
+
+	if !strings.HasPrefix(lines[0], `{"timestamp":1548884166,"pressure":`) {
+		t.Fatalf("expected fields in selected order but line was %s", lines[0])
+	}
+	if !strings.HasSuffix(lines[0], `,"units":{"pressure":"hPa","wind_direction":"degrees"}}`) {
+		t.Fatalf("expected units to be declared but line was %s", lines[0])
+	}
+
+	for i, line := range lines {
+		var obs struct {
+			Timestamp     int64   `json:"timestamp"`
+			Pressure      float64 `json:"pressure"`
+			WindDirection float32 `json:"wind_direction"`
+		}
+		if err := json.Unmarshal([]byte(line), &obs); err != nil {
+			t.Fatalf("unexpected error unmarshalling line %d: %v", i, err)
+		}
+
+		if obs.Timestamp != dataset[i].Timestamp || obs.Pressure != dataset[i].AtmosReadings.Pressure ||
+			obs.WindDirection != dataset[i].WindReadings.Direction {
+			t.Fatalf("expected line %d to match %#v but was %s", i, dataset[i], line)
+		}
+	}
+}
+
+func TestExport_Parquet(t *testing.T) {
+	var dataset []WeatherDataRow
+	err := loadJSONTestDataset("unpublished_observations", &dataset)
+	if err != nil {
+		t.Fatalf("unexpected error loading dataset: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = Export(&buf, ExportFormatParquet, dataset, ExportOptions{Units: Units{Speed: "knots"}})
+	if err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte("PAR1")) || !bytes.HasSuffix(out, []byte("PAR1")) {
+		t.Fatalf("expected output to be a parquet file")
+	}
+	if !bytes.Contains(out, []byte(`"wind_speed":"knots"`)) {
+		t.Fatalf("expected units to be declared in the parquet metadata")
+	}
+}
+
+func TestExport_UnknownUnit(t *testing.T) {
+	var buf bytes.Buffer
+	err := Export(&buf, ExportFormatCSV, nil, ExportOptions{Units: Units{Speed: "furlongs/fortnight"}})
+	if err == nil {
+		t.Fatalf("expected unknown unit to be rejected")
+	}
+}
+
+func TestExport_UnknownField(t *testing.T) {
+	var buf bytes.Buffer
+	err := Export(&buf, ExportFormatCSV, nil, ExportOptions{Fields: []string{"temprature"}})
+	if err == nil {
+		t.Fatalf("expected unknown field to be rejected")
+	}
+}
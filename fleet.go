@@ -0,0 +1,470 @@
+package weatherstn
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultFleetStaleAfterSecs is how long after its latest observation a station is reported as stale when no
+	// limit is configured.
+	DefaultFleetStaleAfterSecs = 3600
+
+	// DefaultFleetWindowSecs is the time window of gaps and regional aggregates when a request doesn't give one.
+	DefaultFleetWindowSecs = 24 * 3600
+
+	// MaxFleetWindowSecs is the longest time window of gaps and regional aggregates a request may ask for, as every
+	// observation in the window is read.
+	MaxFleetWindowSecs = 31 * 24 * 3600
+
+	// FleetRegionAll is the name of the region made up of every station, which is always reported.
+	FleetRegionAll = "all"
+
+	// fleetGapFactor is how many intervals apart consecutive observations may be before the time between them is
+	// reported as a gap.
+	fleetGapFactor = 2
+)
+
+// RegionConfig describes a region of the fleet, made up of the listed stations and those located within the
+// bounding box.
+type RegionConfig struct {
+	Stations     []string `json:"stations"`
+	MinLatitude  *float64 `json:"minLatitude"`
+	MaxLatitude  *float64 `json:"maxLatitude"`
+	MinLongitude *float64 `json:"minLongitude"`
+	MaxLongitude *float64 `json:"maxLongitude"`
+}
+
+// hasBox returns whether the region has a bounding box.
+func (rc RegionConfig) hasBox() bool {
+	return rc.MinLatitude != nil || rc.MaxLatitude != nil || rc.MinLongitude != nil || rc.MaxLongitude != nil
+}
+
+// Validate returns the problems with the region as a *ConfigError.
+func (rc RegionConfig) Validate() error {
+	var problems configProblems
+	if !rc.hasBox() && len(rc.Stations) == 0 {
+		problems.add("stations or a bounding box must be set")
+	}
+	if rc.hasBox() {
+		if rc.MinLatitude == nil || rc.MaxLatitude == nil || rc.MinLongitude == nil || rc.MaxLongitude == nil {
+			problems.add("minLatitude, maxLatitude, minLongitude and maxLongitude must be set together")
+		} else {
+			if *rc.MinLatitude > *rc.MaxLatitude {
+				problems.add("minLatitude must not be greater than maxLatitude")
+			}
+			if *rc.MinLongitude > *rc.MaxLongitude {
+				problems.add("minLongitude must not be greater than maxLongitude")
+			}
+		}
+	}
+
+	return problems.err()
+}
+
+// contains returns whether the station is in the region.
+func (rc RegionConfig) contains(station StationInfo) bool {
+	for _, id := range rc.Stations {
+		if id == station.ID {
+			return true
+		}
+	}
+
+	if !rc.hasBox() || station.Latitude == nil || station.Longitude == nil ||
+		rc.MinLatitude == nil || rc.MaxLatitude == nil || rc.MinLongitude == nil || rc.MaxLongitude == nil {
+		return false
+	}
+
+	return *station.Latitude >= *rc.MinLatitude && *station.Latitude <= *rc.MaxLatitude &&
+		*station.Longitude >= *rc.MinLongitude && *station.Longitude <= *rc.MaxLongitude
+}
+
+// FleetStation is the latest conditions at a station.
+type FleetStation struct {
+	CollectedStation
+	Latest *WeatherDataRow `json:"latest,omitempty"` // nil when the station hasn't sent any observations
+	Stale  bool            `json:"stale"`            // the latest observation is older than the stale limit
+}
+
+// FleetGap is a period without observations from a station.
+type FleetGap struct {
+	From int64 `json:"from"` // unix seconds of the last observation before the gap, or the start of the window
+	To   int64 `json:"to"`   // unix seconds of the first observation after the gap, or the end of the window
+}
+
+// StationGaps are the gaps in a station's observations over a time window.
+type StationGaps struct {
+	StationID      string     `json:"stationId"`
+	Observations   int        `json:"observations"`
+	Gaps           []FleetGap `json:"gaps"`
+	MissingSeconds int64      `json:"missingSeconds"` // total length of the gaps
+}
+
+// RegionalAggregate summarises the observations of the stations in a region over a time window. The aggregates are
+// nil when none of the stations sent observations in the window. Means are taken over the stations which reported,
+// so that a station sending observations more often doesn't count for more than the others. MeanTemperature only
+// uses observations with atmospheric samples, it is nil when none of the stations sampled temperature in the window.
+type RegionalAggregate struct {
+	Region          string   `json:"region"`
+	Stations        []string `json:"stations"`     // IDs of the stations in the region
+	Observations    int      `json:"observations"` // of all of the stations in the window
+	MeanTemperature *float64 `json:"meanTemperature"`
+	MaxGust         *float64 `json:"maxGust"`
+	MaxGustStation  string   `json:"maxGustStation,omitempty"`
+
+	// MeanStationRainfall is the rain which fell at each station in the window, averaged over the stations.
+	MeanStationRainfall *float64 `json:"meanStationRainfall"`
+}
+
+// FleetLatest returns the latest conditions at every station, marking those which haven't sent an observation for
+// staleAfter before now as stale.
+func FleetLatest(ctx context.Context, store CollectorStore, now time.Time,
+	staleAfter time.Duration) ([]FleetStation, error) {
+	stations, err := store.ReadStations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := store.ReadLatestStationRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fleet := make([]FleetStation, len(stations))
+	for i, station := range stations {
+		fleet[i] = FleetStation{CollectedStation: station, Stale: true}
+		if row, ok := latest[station.ID]; ok {
+			fleet[i].Latest = &row
+			fleet[i].Stale = now.Sub(time.Unix(row.Timestamp, 0)) > staleAfter
+		}
+	}
+
+	return fleet, nil
+}
+
+// FleetGaps returns the gaps in every station's observations with a timestamp from (inclusive) up to to (exclusive).
+// Consecutive observations more than twice the observation interval apart are reported as a gap, as are the start
+// and end of the window when they are that far from the first and last observations. A station without any
+// observations in the window has a single gap covering it.
+func FleetGaps(ctx context.Context, store CollectorStore, from, to int64) ([]StationGaps, error) {
+	stations, err := store.ReadStations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gaps := make([]StationGaps, len(stations))
+	for i, station := range stations {
+		rows, err := store.ReadStationRange(ctx, station.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		gaps[i] = stationGaps(station.ID, rows, from, to)
+	}
+
+	return gaps, nil
+}
+
+func stationGaps(stationID string, rows []WeatherDataRow, from, to int64) StationGaps {
+	gaps := StationGaps{StationID: stationID, Observations: len(rows), Gaps: []FleetGap{}}
+	addGap := func(gapFrom, gapTo int64) {
+		gaps.Gaps = append(gaps.Gaps, FleetGap{From: gapFrom, To: gapTo})
+		gaps.MissingSeconds += gapTo - gapFrom
+	}
+	if len(rows) == 0 {
+		addGap(from, to)
+		return gaps
+	}
+
+	maxApart := func(row WeatherDataRow) int64 {
+		return int64(fleetGapFactor * row.IntervalSeconds)
+	}
+
+	if first := rows[0]; first.Timestamp-from > maxApart(first) {
+		addGap(from, first.Timestamp)
+	}
+	for i := 1; i < len(rows); i++ {
+		if rows[i].Timestamp-rows[i-1].Timestamp > maxApart(rows[i]) {
+			addGap(rows[i-1].Timestamp, rows[i].Timestamp)
+		}
+	}
+	if last := rows[len(rows)-1]; to-last.Timestamp > maxApart(last) {
+		addGap(last.Timestamp, to)
+	}
+
+	return gaps
+}
+
+// FleetRegions returns the aggregates of the observations with a timestamp from (inclusive) up to to (exclusive) of
+// each region, and of FleetRegionAll, ordered by region name.
+func FleetRegions(ctx context.Context, store CollectorStore, regions map[string]RegionConfig,
+	from, to int64) ([]RegionalAggregate, error) {
+	stations, err := store.ReadStations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stationRows := make(map[string][]WeatherDataRow, len(stations))
+	for _, station := range stations {
+		rows, err := store.ReadStationRange(ctx, station.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		stationRows[station.ID] = rows
+	}
+
+	names := []string{FleetRegionAll}
+	for name := range regions {
+		if name != FleetRegionAll {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	aggregates := make([]RegionalAggregate, len(names))
+	for i, name := range names {
+		region, configured := regions[name]
+		aggregate := RegionalAggregate{Region: name, Stations: []string{}}
+		var temperatureSum, rainfallSum float64
+		reporting, reportingTemperature := 0, 0
+		for _, station := range stations {
+			if configured && !region.contains(station.StationInfo) {
+				continue
+			}
+			aggregate.Stations = append(aggregate.Stations, station.ID)
+
+			rows := stationRows[station.ID]
+			if len(rows) == 0 {
+				continue
+			}
+			reporting++
+
+			var stationTemperature float64
+			temperatureRows := 0
+			for _, row := range rows {
+				aggregate.Observations++
+				if hasAtmosSamples(row) {
+					stationTemperature += row.AtmosReadings.Temperature
+					temperatureRows++
+				}
+				rainfallSum += row.RainReadings.Rainfall
+				if aggregate.MaxGust == nil || row.WindReadings.Gust > *aggregate.MaxGust {
+					gust := row.WindReadings.Gust
+					aggregate.MaxGust = &gust
+					aggregate.MaxGustStation = station.ID
+				}
+			}
+			if temperatureRows > 0 {
+				temperatureSum += stationTemperature / float64(temperatureRows)
+				reportingTemperature++
+			}
+		}
+
+		if reporting > 0 {
+			meanRainfall := rainfallSum / float64(reporting)
+			aggregate.MeanStationRainfall = &meanRainfall
+		}
+		if reportingTemperature > 0 {
+			meanTemperature := temperatureSum / float64(reportingTemperature)
+			aggregate.MeanTemperature = &meanTemperature
+		}
+		aggregates[i] = aggregate
+	}
+
+	return aggregates, nil
+}
+
+// convert returns a copy of the aggregate with its readings converted from metric into the units.
+func (ra RegionalAggregate) convert(units Units) RegionalAggregate {
+	convert := func(value *float64, quantity string) *float64 {
+		if value == nil {
+			return nil
+		}
+		converted := units.converter(quantity)(*value)
+		return &converted
+	}
+
+	ra.MeanTemperature = convert(ra.MeanTemperature, quantityTemperature)
+	ra.MaxGust = convert(ra.MaxGust, quantitySpeed)
+	ra.MeanStationRainfall = convert(ra.MeanStationRainfall, quantityRainfall)
+
+	return ra
+}
+
+// FleetLatestResponse is the response of the fleet API's latest endpoint.
+type FleetLatestResponse struct {
+	Units    Units          `json:"units"` // of the latest observations
+	Stations []FleetStation `json:"stations"`
+}
+
+// FleetGapsResponse is the response of the fleet API's gaps endpoint.
+type FleetGapsResponse struct {
+	From     int64         `json:"from"`
+	To       int64         `json:"to"`
+	Stations []StationGaps `json:"stations"`
+}
+
+// FleetRegionsResponse is the response of the fleet API's regions endpoint.
+type FleetRegionsResponse struct {
+	From    int64               `json:"from"`
+	To      int64               `json:"to"`
+	Units   Units               `json:"units"` // of the aggregates
+	Regions []RegionalAggregate `json:"regions"`
+}
+
+// NewFleetHandler returns an http.Handler which serves the fleet API for comparing the stations which send
+// observations to a collector, under prefix:
+//
+//	latest   the latest conditions at every station
+//	gaps     the gaps in every station's observations
+//	regions  the aggregates of each configured region
+//
+// Requests must carry one of the config's fleet tokens unless anonymous requests are allowed. Readings are given in
+// metric unless the request asks for other units with a units query parameter, as the status API does. Gaps and
+// regions cover the window given by the from and to query parameters, as unix seconds or RFC3339 times, which
+// defaults to the DefaultFleetWindowSecs up to now and may be at most MaxFleetWindowSecs long.
+func NewFleetHandler(store CollectorStore, config CollectorConfig, prefix string) http.Handler {
+	staleAfter := time.Duration(defaultInt(config.FleetStaleAfterSecs, DefaultFleetStaleAfterSecs)) * time.Second
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !fleetAuthorized(r, config) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="weatherstn"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		units := MetricUnits()
+		if spec := r.URL.Query().Get("units"); spec != "" {
+			var err error
+			if units, err = ParseUnits(spec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		now := time.Now()
+		from, to, err := fleetWindow(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var response interface{}
+		switch strings.TrimPrefix(r.URL.Path, prefix) {
+		case "latest":
+			stations, err := FleetLatest(r.Context(), store, now, staleAfter)
+			if err != nil {
+				fleetError(w, err)
+				return
+			}
+			for i, station := range stations {
+				if station.Latest != nil {
+					converted := units.Convert(*station.Latest)
+					stations[i].Latest = &converted
+				}
+			}
+			response = FleetLatestResponse{Units: units, Stations: stations}
+		case "gaps":
+			gaps, err := FleetGaps(r.Context(), store, from, to)
+			if err != nil {
+				fleetError(w, err)
+				return
+			}
+			response = FleetGapsResponse{From: from, To: to, Stations: gaps}
+		case "regions":
+			regions, err := FleetRegions(r.Context(), store, config.Regions, from, to)
+			if err != nil {
+				fleetError(w, err)
+				return
+			}
+			for i, region := range regions {
+				regions[i] = region.convert(units)
+			}
+			response = FleetRegionsResponse{From: from, To: to, Units: units, Regions: regions}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.WithError(err).
+				WithField("component", "FleetHandler").
+				Error("failed to write response")
+		}
+	})
+}
+
+// fleetAuthorized returns whether the request carries one of the fleet tokens, or doesn't need to.
+func fleetAuthorized(r *http.Request, config CollectorConfig) bool {
+	token, ok := bearerToken(r)
+	if !ok {
+		return r.Header.Get("Authorization") == "" && config.AllowAnonymous
+	}
+
+	authorized := false
+	for _, expected := range config.FleetTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			authorized = true
+		}
+	}
+
+	return authorized
+}
+
+// fleetWindow returns the window given by the request's from and to query parameters.
+func fleetWindow(r *http.Request, now time.Time) (int64, int64, error) {
+	parse := func(name string, def int64) (int64, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return def, nil
+		}
+		if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return unix, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be unix seconds or an RFC3339 time but was %s", name, value)
+		}
+		return t.Unix(), nil
+	}
+
+	to, err := parse("to", now.Unix())
+	if err != nil {
+		return 0, 0, err
+	}
+	from, err := parse("from", to-DefaultFleetWindowSecs)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from >= to {
+		return 0, 0, fmt.Errorf("from must be before to")
+	}
+	if to-from > MaxFleetWindowSecs {
+		return 0, 0, fmt.Errorf("the window from from to to must be at most %d seconds", MaxFleetWindowSecs)
+	}
+
+	return from, to, nil
+}
+
+func fleetError(w http.ResponseWriter, err error) {
+	log.WithError(err).
+		WithField("component", "FleetHandler").
+		Error("failed to read fleet")
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,243 @@
+package weatherstn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newFleetStore returns a store holding observations from three stations: allotment and roof in London, which
+// observe every 60 seconds, and croft in Edinburgh, which stopped sending an hour before the others.
+func newFleetStore(t *testing.T, now int64) *MemoryDataStore {
+	float := func(v float64) *float64 { return &v }
+	stations := []StationInfo{
+		{StationConfig: StationConfig{ID: "allotment", Latitude: float(51.50), Longitude: float(-0.14)}},
+		{StationConfig: StationConfig{ID: "roof", Latitude: float(51.52), Longitude: float(-0.10)}},
+		{StationConfig: StationConfig{ID: "croft", Latitude: float(55.95), Longitude: float(-3.19)}},
+	}
+
+	observations := func(from, to int64, temperature, gust, rainfall float64) []WeatherDataRow {
+		var rows []WeatherDataRow
+		for timestamp := from; timestamp < to; timestamp += 60 {
+			rows = append(rows, WeatherDataRow{
+				Timestamp:       timestamp,
+				AtmosReadings:   AtmoshphericReadings{Temperature: temperature, TemperatureMin: &temperature},
+				WindReadings:    WindReadings{Gust: gust},
+				RainReadings:    RainReadings{Rainfall: rainfall},
+				IntervalSeconds: 60,
+			})
+		}
+		return rows
+	}
+
+	// allotment is missing 10 minutes of observations half way through the last hour.
+	allotment := append(observations(now-3600, now-1800, 10, 20, 0.1), observations(now-1200, now, 10, 20, 0.1)...)
+	batches := map[string][]WeatherDataRow{
+		"allotment": allotment,
+		"roof":      observations(now-3600, now, 12, 45, 0.2),
+		"croft":     observations(now-7200, now-3600, 4, 60, 0),
+	}
+
+	store := NewMemoryDataStore()
+	for _, station := range stations {
+		if _, err := store.WriteStationBatch(context.Background(), station, batches[station.ID]); err != nil {
+			t.Fatalf("failed to write station batch: %v", err)
+		}
+	}
+
+	return store
+}
+
+func TestFleetLatest(t *testing.T) {
+	now := time.Unix(1580339947, 0)
+	store := newFleetStore(t, now.Unix())
+
+	fleet, err := FleetLatest(context.Background(), store, now, 30*time.Minute)
+	if err != nil {
+		t.Fatalf("failed to read latest: %v", err)
+	}
+
+	if len(fleet) != 3 {
+		t.Fatalf("expected 3 stations but got %d", len(fleet))
+	}
+	for _, station := range fleet {
+		stale := station.ID == "croft"
+		if station.Latest == nil || station.Stale != stale {
+			t.Fatalf("expected %s to have a latest observation and be stale %t but was %#v", station.ID, stale,
+				station)
+		}
+	}
+	if fleet[2].ID != "roof" || fleet[2].Latest.Timestamp != now.Unix()-60 {
+		t.Fatalf("expected roof's latest observation to be a minute ago but was %#v", fleet[2])
+	}
+}
+
+func TestFleetGaps(t *testing.T) {
+	now := int64(1580339947)
+	store := newFleetStore(t, now)
+
+	gaps, err := FleetGaps(context.Background(), store, now-3600, now)
+	if err != nil {
+		t.Fatalf("failed to read gaps: %v", err)
+	}
+
+	expected := []StationGaps{
+		{StationID: "allotment", Observations: 50, Gaps: []FleetGap{{From: now - 1860, To: now - 1200}},
+			MissingSeconds: 660},
+		{StationID: "croft", Gaps: []FleetGap{{From: now - 3600, To: now}}, MissingSeconds: 3600},
+		{StationID: "roof", Observations: 60, Gaps: []FleetGap{}},
+	}
+	if !reflect.DeepEqual(gaps, expected) {
+		t.Fatalf("expected gaps to be %#v but were %#v", expected, gaps)
+	}
+}
+
+func TestFleetRegions(t *testing.T) {
+	now := int64(1580339947)
+	store := newFleetStore(t, now)
+
+	float := func(v float64) *float64 { return &v }
+	regions := map[string]RegionConfig{
+		"london": {MinLatitude: float(51.2), MaxLatitude: float(51.8), MinLongitude: float(-0.6),
+			MaxLongitude: float(0.3)},
+		"scotland": {Stations: []string{"croft"}},
+	}
+
+	aggregates, err := FleetRegions(context.Background(), store, regions, now-7200, now)
+	if err != nil {
+		t.Fatalf("failed to read regions: %v", err)
+	}
+
+	if len(aggregates) != 3 {
+		t.Fatalf("expected all, london and scotland but got %#v", aggregates)
+	}
+	all, london, scotland := aggregates[0], aggregates[1], aggregates[2]
+
+	if all.Region != FleetRegionAll || len(all.Stations) != 3 || all.Observations != 170 ||
+		*all.MaxGust != 60 || all.MaxGustStation != "croft" {
+		t.Fatalf("expected all to cover every station but was %#v", all)
+	}
+	if london.Region != "london" || !reflect.DeepEqual(london.Stations, []string{"allotment", "roof"}) ||
+		london.Observations != 110 || *london.MaxGust != 45 || london.MaxGustStation != "roof" {
+		t.Fatalf("expected london to cover allotment and roof but was %#v", london)
+	}
+	if !closeTo(*london.MeanTemperature, (10+12)/2.0) {
+		t.Fatalf("expected london mean temperature to be the mean of the station means but was %f",
+			*london.MeanTemperature)
+	}
+	if !closeTo(*london.MeanStationRainfall, (50*0.1+60*0.2)/2) {
+		t.Fatalf("expected london rainfall to be the mean of the station totals but was %f",
+			*london.MeanStationRainfall)
+	}
+	if scotland.Region != "scotland" || !reflect.DeepEqual(scotland.Stations, []string{"croft"}) ||
+		*scotland.MeanTemperature != 4 || *scotland.MeanStationRainfall != 0 {
+		t.Fatalf("expected scotland to cover croft but was %#v", scotland)
+	}
+
+	empty, err := FleetRegions(context.Background(), store, regions, now+60, now+120)
+	if err != nil {
+		t.Fatalf("failed to read regions: %v", err)
+	}
+	if empty[0].MeanTemperature != nil || empty[0].MaxGust != nil || empty[0].MeanStationRainfall != nil {
+		t.Fatalf("expected no aggregates without observations but got %#v", empty[0])
+	}
+}
+
+func TestFleetRegions_UnsampledTemperature(t *testing.T) {
+	now := int64(1580339947)
+	float := func(v float64) *float64 { return &v }
+
+	// shed's BME280 dropped out for its second observation, leaving a placeholder 0, and mast only measures wind.
+	batches := map[string][]WeatherDataRow{
+		"shed": {
+			{Timestamp: now - 120, AtmosReadings: AtmoshphericReadings{Temperature: 8, TemperatureMin: float(8)},
+				IntervalSeconds: 60},
+			{Timestamp: now - 60, IntervalSeconds: 60},
+		},
+		"mast": {
+			{Timestamp: now - 60, WindReadings: WindReadings{Gust: 30}, IntervalSeconds: 60},
+		},
+	}
+	store := NewMemoryDataStore()
+	for _, id := range []string{"shed", "mast"} {
+		station := StationInfo{StationConfig: StationConfig{ID: id}}
+		if _, err := store.WriteStationBatch(context.Background(), station, batches[id]); err != nil {
+			t.Fatalf("failed to write station batch: %v", err)
+		}
+	}
+
+	regions := map[string]RegionConfig{"coast": {Stations: []string{"mast"}}}
+	aggregates, err := FleetRegions(context.Background(), store, regions, now-3600, now)
+	if err != nil {
+		t.Fatalf("failed to read regions: %v", err)
+	}
+
+	all, coast := aggregates[0], aggregates[1]
+	if all.Observations != 3 || all.MeanTemperature == nil || *all.MeanTemperature != 8 {
+		t.Fatalf("expected all mean temperature to only use sampled observations but was %#v", all)
+	}
+	if coast.Observations != 1 || coast.MeanTemperature != nil || coast.MeanStationRainfall == nil ||
+		*coast.MaxGust != 30 {
+		t.Fatalf("expected coast to have no mean temperature without atmospheric samples but was %#v", coast)
+	}
+}
+
+func TestFleetHandler(t *testing.T) {
+	now := time.Now().Unix()
+	store := newFleetStore(t, now)
+	handler := NewFleetHandler(store, CollectorConfig{FleetTokens: []string{"operator"}}, "/fleet/")
+
+	get := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := get("/fleet/latest", ""); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 without a token but got %d", recorder.Code)
+	}
+	if recorder := get("/fleet/latest", "secret"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status 401 with a station's token but got %d", recorder.Code)
+	}
+	if recorder := get("/fleet/everything", "operator"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404 for an unknown view but got %d", recorder.Code)
+	}
+	if recorder := get("/fleet/gaps?from=yesterday", "operator"); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid window but got %d", recorder.Code)
+	}
+	if recorder := get(fmt.Sprintf("/fleet/regions?from=%d", now-MaxFleetWindowSecs-1), "operator"); recorder.Code !=
+		http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a window longer than the maximum but got %d", recorder.Code)
+	}
+
+	recorder := get("/fleet/latest?units=imperial", "operator")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", recorder.Code)
+	}
+	var latest FleetLatestResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &latest); err != nil {
+		t.Fatalf("unexpected error decoding latest: %v", err)
+	}
+	if latest.Units != ImperialUnits() || len(latest.Stations) != 3 ||
+		!closeTo(latest.Stations[2].Latest.AtmosReadings.Temperature, 53.6) {
+		t.Fatalf("expected latest conditions in imperial but got %#v", latest)
+	}
+
+	recorder = get("/fleet/regions?from="+time.Unix(now-600, 0).UTC().Format(time.RFC3339), "operator")
+	var regions FleetRegionsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &regions); err != nil {
+		t.Fatalf("unexpected error decoding regions: %v", err)
+	}
+	if regions.From != now-600 || len(regions.Regions) != 1 || regions.Regions[0].Observations != 20 {
+		t.Fatalf("expected the last 10 minutes of the whole fleet but got %#v", regions)
+	}
+}
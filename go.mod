@@ -1,17 +1,25 @@
 module github.com/chvck/weatherstn
 
-go 1.13
+go 1.16
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.4.0
+	github.com/eclipse/paho.mqtt.golang v1.3.5
+	github.com/fxamacker/cbor/v2 v2.4.0
 	github.com/golang-migrate/migrate/v4 v4.8.0
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/klauspost/compress v1.9.7
+	github.com/lib/pq v1.3.0
 	github.com/maciej/bme280 v0.2.0
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
+	github.com/pelletier/go-toml v1.9.5
 	github.com/sirupsen/logrus v1.4.2
 	github.com/stretchr/testify v1.4.0
 	github.com/warthog618/gpio v0.6.1
+	github.com/xitongsys/parquet-go v1.5.1
 	golang.org/x/exp v0.0.0-20191227195350-da58074b4299
-	golang.org/x/sys v0.0.0-20190927073244-c990c680b611
+	golang.org/x/net v0.11.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	gopkg.in/yaml.v2 v2.2.4 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,52 @@
+package weatherstn
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/warthog618/gpio"
+)
+
+// The GPIO memory mapping is shared by the whole process, so it is opened by the first provider to connect and only
+// closed once the last has disconnected. Otherwise a sensor being reconnected by its supervisor would unmap the GPIO
+// from under any other sensor still watching its pin.
+var (
+	gpioLock  sync.Mutex
+	gpioUsers int
+
+	// gpioOpen and gpioClose are replaced in tests.
+	gpioOpen  = gpio.Open
+	gpioClose = gpio.Close
+)
+
+// openGPIO opens the GPIO memory mapping if no other provider has, each successful call must be matched by a call to
+// closeGPIO.
+func openGPIO() error {
+	gpioLock.Lock()
+	defer gpioLock.Unlock()
+
+	if gpioUsers == 0 {
+		if err := gpioOpen(); err != nil && !errors.Is(err, gpio.ErrAlreadyOpen) {
+			return err
+		}
+	}
+	gpioUsers++
+
+	return nil
+}
+
+// closeGPIO closes the GPIO memory mapping once every provider which opened it has closed it.
+func closeGPIO() error {
+	gpioLock.Lock()
+	defer gpioLock.Unlock()
+
+	if gpioUsers == 0 {
+		return nil
+	}
+	gpioUsers--
+	if gpioUsers > 0 {
+		return nil
+	}
+
+	return gpioClose()
+}
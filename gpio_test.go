@@ -0,0 +1,41 @@
+package weatherstn
+
+import "testing"
+
+func TestOpenGPIO_SharedBetweenProviders(t *testing.T) {
+	var opens, closes int
+	defer func(open, shut func() error) { gpioOpen, gpioClose = open, shut }(gpioOpen, gpioClose)
+	gpioOpen = func() error { opens++; return nil }
+	gpioClose = func() error { closes++; return nil }
+
+	// Wind and rain connect, then wind is reconnected by its supervisor while rain keeps watching its pin.
+	for i := 0; i < 2; i++ {
+		if err := openGPIO(); err != nil {
+			t.Fatalf("unexpected error opening gpio: %v", err)
+		}
+	}
+	if err := closeGPIO(); err != nil {
+		t.Fatalf("unexpected error closing gpio: %v", err)
+	}
+	if closes != 0 {
+		t.Fatalf("expected gpio to stay open while rain is using it but it was closed")
+	}
+	if err := openGPIO(); err != nil {
+		t.Fatalf("unexpected error opening gpio: %v", err)
+	}
+	if opens != 1 {
+		t.Fatalf("expected gpio to be opened once but it was opened %d times", opens)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := closeGPIO(); err != nil {
+			t.Fatalf("unexpected error closing gpio: %v", err)
+		}
+	}
+	if closes != 1 {
+		t.Fatalf("expected gpio to be closed once the last provider disconnected but it was closed %d times", closes)
+	}
+	if err := closeGPIO(); err != nil || closes != 1 {
+		t.Fatalf("expected closing gpio again to do nothing but got %v and %d closes", err, closes)
+	}
+}
@@ -0,0 +1,494 @@
+package weatherstn
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ImportSource reads historical observations from another weather station's data format. Rows are returned in
+// ascending timestamp order with readings converted into metric.
+type ImportSource interface {
+	Read() ([]WeatherDataRow, error)
+}
+
+// DefaultImportUnits returns ImportUnits for sources recorded in the same units as the weather station, °C, hPa, km/h
+// and mm.
+func DefaultImportUnits() ImportUnits {
+	return MetricUnits()
+}
+
+// Import reads all rows from the source and writes them to the store, skipping any timestamps which are already
+// stored. If publish is true then the imported rows are sent upstream by the Publisher like any other observation,
+// otherwise they are stored as already published. Returns the number of rows read and written.
+func Import(ctx context.Context, store DataStore, source ImportSource, publish bool) (int, int, error) {
+	rows, err := source.Read()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	written, err := store.WriteBatch(ctx, rows, !publish)
+	if err != nil {
+		return len(rows), 0, err
+	}
+
+	return len(rows), written, nil
+}
+
+// CumulusLogSource reads the monthly log files written by Cumulus, e.g. Jan20log.txt.
+type CumulusLogSource struct {
+	r     io.Reader
+	units ImportUnits
+	loc   *time.Location
+}
+
+// NewCumulusLogSource creates a new CumulusLogSource. Cumulus doesn't record units in its log files so these must
+// match the station settings, times are interpreted in loc.
+func NewCumulusLogSource(r io.Reader, units ImportUnits, loc *time.Location) *CumulusLogSource {
+	return &CumulusLogSource{
+		r:     r,
+		units: units,
+		loc:   loc,
+	}
+}
+
+const (
+	cumulusFieldDate = iota
+	cumulusFieldTime
+	cumulusFieldTemperature
+	cumulusFieldHumidity
+	cumulusFieldDewPoint
+	cumulusFieldWindSpeed
+	cumulusFieldWindGust
+	cumulusFieldWindBearing
+	cumulusFieldRainRate
+	cumulusFieldRainToday
+	cumulusFieldPressure
+
+	cumulusMinFields = cumulusFieldPressure + 1
+)
+
+// Read parses every line of the log.
+func (cls *CumulusLogSource) Read() ([]WeatherDataRow, error) {
+	var rows []WeatherDataRow
+	var lastRainToday float64
+	first := true
+
+	scanner := bufio.NewScanner(cls.r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// Cumulus uses the regional list separator, where that is ; the decimal separator is usually a comma.
+		separator := ","
+		if strings.Contains(line, ";") {
+			separator = ";"
+		}
+
+		fields := strings.Split(line, separator)
+		if len(fields) < cumulusMinFields {
+			return nil, fmt.Errorf("line %d: expected at least %d fields but got %d", lineNum, cumulusMinFields,
+				len(fields))
+		}
+
+		timestamp, err := parseCumulusTime(fields[cumulusFieldDate], fields[cumulusFieldTime], cls.loc)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+
+		values := make([]float64, cumulusMinFields)
+		for i := cumulusFieldTemperature; i < cumulusMinFields; i++ {
+			values[i], err = strconv.ParseFloat(strings.Replace(strings.TrimSpace(fields[i]), ",", ".", 1), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d field %d: %v", lineNum, i+1, err)
+			}
+		}
+
+		row := WeatherDataRow{
+			Timestamp: timestamp.Unix(),
+			AtmosReadings: AtmoshphericReadings{
+				Humidity: values[cumulusFieldHumidity],
+			},
+			WindReadings: WindReadings{
+				Direction: float32(values[cumulusFieldWindBearing]),
+			},
+		}
+
+		conversions := []struct {
+			dest  *float64
+			value float64
+			unit  string
+		}{
+			{&row.AtmosReadings.Temperature, values[cumulusFieldTemperature], cls.units.Temperature},
+			{&row.AtmosReadings.Pressure, values[cumulusFieldPressure], cls.units.Pressure},
+			{&row.WindReadings.Speed, values[cumulusFieldWindSpeed], cls.units.Speed},
+			{&row.WindReadings.Gust, values[cumulusFieldWindGust], cls.units.Speed},
+		}
+		for _, c := range conversions {
+			if *c.dest, err = ConvertToMetric(c.value, c.unit); err != nil {
+				return nil, err
+			}
+		}
+
+		// The log records the running rainfall total for the day rather than rainfall within the interval. The total
+		// resets at the rollover time, which isn't necessarily midnight, so a drop in the total marks a new day. The
+		// first row has no previous total to compare against so no rainfall is attributed to it.
+		rainToday, err := ConvertToMetric(values[cumulusFieldRainToday], cls.units.Rainfall)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case first:
+		case rainToday >= lastRainToday:
+			row.RainReadings.Rainfall = rainToday - lastRainToday
+		default:
+			row.RainReadings.Rainfall = rainToday
+		}
+		lastRainToday = rainToday
+		first = false
+
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	fillImportIntervals(rows)
+
+	return rows, nil
+}
+
+func parseCumulusTime(date, clock string, loc *time.Location) (time.Time, error) {
+	// The date separator depends on the regional settings so split on anything that isn't a number.
+	notDigit := func(r rune) bool { return !unicode.IsDigit(r) }
+	dateParts := strings.FieldsFunc(date, notDigit)
+	clockParts := strings.FieldsFunc(clock, notDigit)
+	if len(dateParts) != 3 || len(clockParts) < 2 {
+		return time.Time{}, fmt.Errorf("unrecognised date and time %s %s", date, clock)
+	}
+
+	var parts [5]int
+	for i, part := range append(dateParts, clockParts[:2]...) {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return time.Time{}, err
+		}
+		parts[i] = n
+	}
+
+	year := parts[2]
+	if year < 100 {
+		year += 2000
+	}
+
+	return time.Date(year, time.Month(parts[1]), parts[0], parts[3], parts[4], 0, 0, loc), nil
+}
+
+// WeeWXSource reads the archive table of a WeeWX SQLite database.
+type WeeWXSource struct {
+	db *sqlx.DB
+}
+
+// NewWeeWXSource creates a new WeeWXSource reading from db, which must be the WeeWX archive database.
+func NewWeeWXSource(db *sqlx.DB) *WeeWXSource {
+	return &WeeWXSource{db: db}
+}
+
+const (
+	queryWeeWXArchive = "SELECT dateTime, usUnits, interval, outTemp, outHumidity, pressure, barometer, windSpeed, " +
+		"windDir, windGust, rain FROM archive ORDER BY dateTime ASC;"
+
+	weeWXUnitsUS       = 0x01
+	weeWXUnitsMetric   = 0x10
+	weeWXUnitsMetricWX = 0x11
+)
+
+type weeWXArchiveRow struct {
+	DateTime    int64           `db:"dateTime"`
+	USUnits     int             `db:"usUnits"`
+	Interval    int             `db:"interval"`
+	OutTemp     sql.NullFloat64 `db:"outTemp"`
+	OutHumidity sql.NullFloat64 `db:"outHumidity"`
+	Pressure    sql.NullFloat64 `db:"pressure"`
+	Barometer   sql.NullFloat64 `db:"barometer"`
+	WindSpeed   sql.NullFloat64 `db:"windSpeed"`
+	WindDir     sql.NullFloat64 `db:"windDir"`
+	WindGust    sql.NullFloat64 `db:"windGust"`
+	Rain        sql.NullFloat64 `db:"rain"`
+}
+
+// weeWXUnits are the units used by each of the WeeWX unit systems.
+var weeWXUnits = map[int]ImportUnits{
+	weeWXUnitsUS:       {Temperature: "F", Pressure: "inHg", Speed: "mph", Rainfall: "in"},
+	weeWXUnitsMetric:   {Temperature: "C", Pressure: "mbar", Speed: "km/h", Rainfall: "cm"},
+	weeWXUnitsMetricWX: {Temperature: "C", Pressure: "mbar", Speed: "m/s", Rainfall: "mm"},
+}
+
+// Read reads every archive record.
+func (wws *WeeWXSource) Read() ([]WeatherDataRow, error) {
+	var archive []weeWXArchiveRow
+	if err := wws.db.Select(&archive, queryWeeWXArchive); err != nil {
+		return nil, err
+	}
+
+	rows := make([]WeatherDataRow, 0, len(archive))
+	for _, record := range archive {
+		units, ok := weeWXUnits[record.USUnits]
+		if !ok {
+			return nil, fmt.Errorf("record %d: unknown unit system %d", record.DateTime, record.USUnits)
+		}
+
+		// Station pressure is what the BME280 measures, fall back to the sea level barometer when not recorded.
+		pressure := record.Pressure
+		if !pressure.Valid {
+			pressure = record.Barometer
+		}
+
+		row := WeatherDataRow{
+			Timestamp:       record.DateTime,
+			IntervalSeconds: record.Interval * 60,
+			AtmosReadings: AtmoshphericReadings{
+				Humidity: record.OutHumidity.Float64,
+			},
+			WindReadings: WindReadings{
+				Direction: -1, // as the wind provider reports no direction, 0 is north
+			},
+		}
+		if record.WindDir.Valid {
+			row.WindReadings.Direction = float32(record.WindDir.Float64)
+		}
+
+		conversions := []struct {
+			dest  *float64
+			value sql.NullFloat64
+			unit  string
+		}{
+			{&row.AtmosReadings.Temperature, record.OutTemp, units.Temperature},
+			{&row.AtmosReadings.Pressure, pressure, units.Pressure},
+			{&row.WindReadings.Speed, record.WindSpeed, units.Speed},
+			{&row.WindReadings.Gust, record.WindGust, units.Speed},
+			{&row.RainReadings.Rainfall, record.Rain, units.Rainfall},
+		}
+		for _, c := range conversions {
+			var err error
+			if *c.dest, err = ConvertToMetric(c.value.Float64, c.unit); err != nil {
+				return nil, err
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// WeatherLinkCSVSource reads the CSV data exports downloaded from WeatherLink, the units of each column are taken
+// from the column headings.
+type WeatherLinkCSVSource struct {
+	r   io.Reader
+	loc *time.Location
+}
+
+// NewWeatherLinkCSVSource creates a new WeatherLinkCSVSource, times are interpreted in loc.
+func NewWeatherLinkCSVSource(r io.Reader, loc *time.Location) *WeatherLinkCSVSource {
+	return &WeatherLinkCSVSource{
+		r:   r,
+		loc: loc,
+	}
+}
+
+var weatherLinkTimeLayouts = []string{
+	"1/2/06 3:04 PM",
+	"1/2/2006 3:04 PM",
+	"1/2/06 15:04",
+	"1/2/2006 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// weatherLinkBarometer is the heading of the WeatherLink column holding sea level pressure.
+const weatherLinkBarometer = "Barometer"
+
+// weatherLinkStationPressure are the headings of the WeatherLink columns holding station pressure.
+var weatherLinkStationPressure = map[string]bool{"Abs Press": true, "Absolute Pressure": true}
+
+// weatherLinkColumns maps the heading of a WeatherLink column, without its unit, to the reading it holds. The
+// barometer is handled separately as it is only used when the export has no station pressure.
+var weatherLinkColumns = map[string]func(row *WeatherDataRow) *float64{
+	"Temp":              func(row *WeatherDataRow) *float64 { return &row.AtmosReadings.Temperature },
+	"Hum":               func(row *WeatherDataRow) *float64 { return &row.AtmosReadings.Humidity },
+	"Abs Press":         func(row *WeatherDataRow) *float64 { return &row.AtmosReadings.Pressure },
+	"Absolute Pressure": func(row *WeatherDataRow) *float64 { return &row.AtmosReadings.Pressure },
+	"Wind Speed":        func(row *WeatherDataRow) *float64 { return &row.WindReadings.Speed },
+	"Hi Speed":          func(row *WeatherDataRow) *float64 { return &row.WindReadings.Gust },
+	"High Wind Speed": func(row *WeatherDataRow) *float64 {
+		return &row.WindReadings.Gust
+	},
+	"Rain": func(row *WeatherDataRow) *float64 { return &row.RainReadings.Rainfall },
+}
+
+var compassPoints = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW",
+	"NW", "NNW"}
+
+// Read parses the export, skipping any preamble before the heading row.
+func (wls *WeatherLinkCSVSource) Read() ([]WeatherDataRow, error) {
+	reader := csv.NewReader(wls.r)
+	reader.FieldsPerRecord = -1
+
+	type column struct {
+		index int
+		unit  string
+		dest  func(row *WeatherDataRow) *float64
+	}
+
+	var columns []column
+	var barometer *column
+	var stationPressure bool
+	timeColumn, directionColumn := -1, -1
+	var rows []WeatherDataRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if timeColumn == -1 {
+			for i, heading := range record {
+				heading = strings.TrimSpace(heading)
+				if heading == "Date & Time" {
+					timeColumn = i
+					continue
+				}
+				if heading == "Wind Direction" || heading == "Prevailing Wind Direction" {
+					directionColumn = i
+					continue
+				}
+
+				parts := strings.SplitN(heading, " - ", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				if dest, ok := weatherLinkColumns[parts[0]]; ok {
+					columns = append(columns, column{index: i, unit: parts[1], dest: dest})
+					stationPressure = stationPressure || weatherLinkStationPressure[parts[0]]
+				}
+				if parts[0] == weatherLinkBarometer {
+					barometer = &column{index: i, unit: parts[1], dest: func(row *WeatherDataRow) *float64 {
+						return &row.AtmosReadings.Pressure
+					}}
+				}
+			}
+
+			// Station pressure is what the BME280 measures, fall back to the sea level barometer when not exported.
+			if barometer != nil && !stationPressure {
+				columns = append(columns, *barometer)
+			}
+			continue
+		}
+
+		if len(record) <= timeColumn {
+			continue
+		}
+
+		timestamp, err := parseWeatherLinkTime(record[timeColumn], wls.loc)
+		if err != nil {
+			return nil, err
+		}
+
+		row := WeatherDataRow{Timestamp: timestamp.Unix()}
+		for _, col := range columns {
+			if col.index >= len(record) {
+				continue
+			}
+			value := strings.TrimSpace(record[col.index])
+			if value == "" || value == "--" {
+				continue
+			}
+
+			reading, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", record[timeColumn], err)
+			}
+
+			unit := col.unit
+			if unit == "%" {
+				unit = ""
+			}
+			if unit != "" {
+				if reading, err = ConvertToMetric(reading, unit); err != nil {
+					return nil, err
+				}
+			}
+			*col.dest(&row) = reading
+		}
+
+		if directionColumn != -1 && directionColumn < len(record) {
+			row.WindReadings.Direction = compassDegrees(strings.TrimSpace(record[directionColumn]))
+		}
+
+		rows = append(rows, row)
+	}
+
+	if timeColumn == -1 {
+		return nil, fmt.Errorf("no Date & Time heading found")
+	}
+
+	fillImportIntervals(rows)
+
+	return rows, nil
+}
+
+func parseWeatherLinkTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range weatherLinkTimeLayouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised time %s", value)
+}
+
+// compassDegrees converts a compass point such as NNE into degrees, returning -1 (as the wind provider does) when it
+// isn't recognised.
+func compassDegrees(point string) float32 {
+	for i, p := range compassPoints {
+		if p == point {
+			return float32(i) * 22.5
+		}
+	}
+
+	return -1
+}
+
+// fillImportIntervals sets the interval of rows from the time since the previous row, for formats which don't record
+// it. The first row is assumed to have the same interval as the second.
+func fillImportIntervals(rows []WeatherDataRow) {
+	for i := range rows {
+		if rows[i].IntervalSeconds != 0 {
+			continue
+		}
+
+		switch {
+		case i > 0:
+			rows[i].IntervalSeconds = int(rows[i].Timestamp - rows[i-1].Timestamp)
+		case len(rows) > 1:
+			rows[i].IntervalSeconds = int(rows[1].Timestamp - rows[0].Timestamp)
+		}
+	}
+}
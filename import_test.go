@@ -0,0 +1,191 @@
+package weatherstn
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func assertClose(t *testing.T, name string, expected, actual float64) {
+	if math.Abs(expected-actual) > 0.0001 {
+		t.Fatalf("expected %s to be %v but was %v", name, expected, actual)
+	}
+}
+
+func TestCumulusLogSource_Read(t *testing.T) {
+	f, err := os.Open("testdata/cumulus_log.txt")
+	if err != nil {
+		t.Fatalf("unexpected error opening log: %v", err)
+	}
+	defer f.Close()
+
+	units := DefaultImportUnits()
+	units.Speed = "m/s"
+
+	rows, err := NewCumulusLogSource(f, units, time.UTC).Read()
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows but got %d", len(rows))
+	}
+
+	expectedTime := time.Date(2020, 1, 30, 9, 0, 0, 0, time.UTC).Unix()
+	if rows[1].Timestamp != expectedTime {
+		t.Fatalf("expected timestamp to be %d but was %d", expectedTime, rows[1].Timestamp)
+	}
+
+	if rows[0].IntervalSeconds != 600 || rows[1].IntervalSeconds != 600 {
+		t.Fatalf("expected intervals to be 600 but were %d and %d", rows[0].IntervalSeconds,
+			rows[1].IntervalSeconds)
+	}
+
+	assertClose(t, "temperature", 15.6, rows[1].AtmosReadings.Temperature)
+	assertClose(t, "humidity", 80, rows[1].AtmosReadings.Humidity)
+	assertClose(t, "pressure", 1012.1, rows[1].AtmosReadings.Pressure)
+	assertClose(t, "wind speed", 6.1*3.6, rows[1].WindReadings.Speed)
+	assertClose(t, "wind gust", 10.3*3.6, rows[1].WindReadings.Gust)
+	assertClose(t, "wind direction", 247, float64(rows[1].WindReadings.Direction))
+
+	// Rainfall is the difference in the daily total, which resets at the 09:00 rollover.
+	assertClose(t, "first rainfall", 0, rows[0].RainReadings.Rainfall)
+	assertClose(t, "rainfall", 0.4, rows[1].RainReadings.Rainfall)
+	assertClose(t, "rainfall after rollover", 0.2, rows[2].RainReadings.Rainfall)
+}
+
+func TestWeatherLinkCSVSource_Read(t *testing.T) {
+	f, err := os.Open("testdata/weatherlink.csv")
+	if err != nil {
+		t.Fatalf("unexpected error opening export: %v", err)
+	}
+	defer f.Close()
+
+	rows, err := NewWeatherLinkCSVSource(f, time.UTC).Read()
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows but got %d", len(rows))
+	}
+
+	expectedTime := time.Date(2020, 1, 30, 9, 0, 0, 0, time.UTC).Unix()
+	if rows[1].Timestamp != expectedTime {
+		t.Fatalf("expected timestamp to be %d but was %d", expectedTime, rows[1].Timestamp)
+	}
+
+	if rows[1].IntervalSeconds != 900 {
+		t.Fatalf("expected interval to be 900 but was %d", rows[1].IntervalSeconds)
+	}
+
+	assertClose(t, "temperature", 16, rows[1].AtmosReadings.Temperature)
+	assertClose(t, "humidity", 80, rows[1].AtmosReadings.Humidity)
+	assertClose(t, "pressure", 29.90*33.8638866667, rows[1].AtmosReadings.Pressure)
+	assertClose(t, "missing wind speed", 0, rows[1].WindReadings.Speed)
+	assertClose(t, "wind gust", 12*1.609344, rows[1].WindReadings.Gust)
+	assertClose(t, "wind direction", 247.5, float64(rows[1].WindReadings.Direction))
+	assertClose(t, "missing wind direction", -1, float64(rows[2].WindReadings.Direction))
+	assertClose(t, "rainfall", 0.254, rows[1].RainReadings.Rainfall)
+}
+
+func TestWeatherLinkCSVSource_ReadStationPressure(t *testing.T) {
+	export := "Date & Time,Temp - °C,Barometer - mb,Abs Press - mb\n" +
+		"1/30/20 8:45 AM,15.0,1013.2,1001.4\n" +
+		"1/30/20 9:00 AM,15.2,1013.0,1001.2\n"
+
+	rows, err := NewWeatherLinkCSVSource(strings.NewReader(export), time.UTC).Read()
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows but got %d", len(rows))
+	}
+	assertClose(t, "station pressure", 1001.2, rows[1].AtmosReadings.Pressure)
+}
+
+func TestWeeWXSource_Read(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, "weewx.sdb"))
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %v", err)
+	}
+	defer db.Close()
+
+	db.MustExec("CREATE TABLE archive (dateTime INTEGER NOT NULL UNIQUE PRIMARY KEY, usUnits INTEGER NOT NULL, " +
+		"interval INTEGER NOT NULL, outTemp REAL, outHumidity REAL, pressure REAL, barometer REAL, windSpeed REAL, " +
+		"windDir REAL, windGust REAL, rain REAL);")
+	db.MustExec("INSERT INTO archive VALUES (1580374800, 1, 5, 59.0, 81, 29.5, 29.9, 10, 225, 15, 0.01);")
+	db.MustExec("INSERT INTO archive VALUES (1580375100, 16, 5, 15.2, 80, NULL, 1012.5, 16, 247.5, 24, 0.02);")
+	db.MustExec("INSERT INTO archive VALUES (1580375400, 16, 5, 15.1, 80, 1001.0, 1012.4, 0, NULL, 0, 0);")
+
+	rows, err := NewWeeWXSource(db).Read()
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows but got %d", len(rows))
+	}
+
+	if rows[0].Timestamp != 1580374800 || rows[0].IntervalSeconds != 300 {
+		t.Fatalf("unexpected timestamp or interval in %#v", rows[0])
+	}
+
+	assertClose(t, "us temperature", 15, rows[0].AtmosReadings.Temperature)
+	assertClose(t, "us pressure", 29.5*33.8638866667, rows[0].AtmosReadings.Pressure)
+	assertClose(t, "us wind speed", 10*1.609344, rows[0].WindReadings.Speed)
+	assertClose(t, "us rainfall", 0.254, rows[0].RainReadings.Rainfall)
+	assertClose(t, "metric temperature", 15.2, rows[1].AtmosReadings.Temperature)
+	assertClose(t, "metric barometer", 1012.5, rows[1].AtmosReadings.Pressure)
+	assertClose(t, "metric wind gust", 24, rows[1].WindReadings.Gust)
+	assertClose(t, "metric rainfall", 0.2, rows[1].RainReadings.Rainfall)
+	assertClose(t, "wind direction", 247.5, float64(rows[1].WindReadings.Direction))
+	assertClose(t, "missing wind direction", -1, float64(rows[2].WindReadings.Direction))
+}
+
+func TestImport(t *testing.T) {
+	f, err := os.Open("testdata/cumulus_log.txt")
+	if err != nil {
+		t.Fatalf("unexpected error opening log: %v", err)
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	store := NewMemoryDataStore()
+	existing := WeatherDataRow{Timestamp: time.Date(2020, 1, 30, 9, 0, 0, 0, time.UTC).Unix()}
+	if err := store.Write(ctx, existing); err != nil {
+		t.Fatalf("failed to write to data store: %v", err)
+	}
+
+	read, written, err := Import(ctx, store, NewCumulusLogSource(f, DefaultImportUnits(), time.UTC), false)
+	if err != nil {
+		t.Fatalf("failed to import: %v", err)
+	}
+
+	if read != 3 || written != 2 {
+		t.Fatalf("expected 3 rows read and 2 written but got %d and %d", read, written)
+	}
+
+	unpublished, err := store.ReadUnpublished(ctx)
+	if err != nil {
+		t.Fatalf("failed to read unpublished: %v", err)
+	}
+
+	if len(unpublished) != 1 {
+		t.Fatalf("expected only the existing row to be unpublished but got %d rows", len(unpublished))
+	}
+}
@@ -0,0 +1,227 @@
+package weatherstn
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres" // registers the postgres migrate database driver
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"  // registers the sqlite3 migrate database driver
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file" // registers the file migrate source driver
+	"github.com/golang-migrate/migrate/v4/source/httpfs"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrationsFS holds the schema migrations so that the binary doesn't need them deploying alongside it. The sqlite
+// migrations are at the top level and the postgres ones in the postgres directory.
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// ErrSchemaTooNew is returned when the database has been migrated to a schema version newer than any migration known
+// to this binary, usually because a newer version has been run against it.
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports")
+
+// Migrator applies schema migrations to the configured database.
+type Migrator struct {
+	config DatabaseConfig
+	m      *migrate.Migrate
+	latest uint
+}
+
+// NewMigrator creates a Migrator for the database, using the migrations embedded in the binary unless the config
+// specifies a migrations directory to use instead.
+func NewMigrator(config DatabaseConfig) (*Migrator, error) {
+	var databaseURL, embeddedPath string
+	switch config.DriverName() {
+	case DatabaseDriverSqlite:
+		databaseURL = "sqlite3://" + config.Path
+		embeddedPath = "migrations"
+	case DatabaseDriverPostgres:
+		databaseURL = config.DSN
+		embeddedPath = "migrations/postgres"
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", config.Driver)
+	}
+
+	var src source.Driver
+	var err error
+	if config.Migrations != "" {
+		src, err = source.Open("file://" + config.Migrations)
+	} else {
+		src, err = httpfs.New(http.FS(migrationsFS), embeddedPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := latestMigration(src)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithSourceInstance("migrations", src, databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		config: config,
+		m:      m,
+		latest: latest,
+	}, nil
+}
+
+func latestMigration(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// Up applies all pending migrations.
+func (mg *Migrator) Up() error {
+	err := mg.m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+
+	return err
+}
+
+// Steps applies, or when n is negative reverts, n migrations.
+func (mg *Migrator) Steps(n int) error {
+	return mg.m.Steps(n)
+}
+
+// EnsureLatest brings the database up to the latest schema version, backing up sqlite databases before applying any
+// migrations. It refuses to touch a database with a dirty schema or one newer than the migrations known to this
+// binary.
+func (mg *Migrator) EnsureLatest() error {
+	version, dirty, err := mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		version, err = 0, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("database schema is dirty at version %d, a previous migration failed and must be fixed "+
+			"manually", version)
+	}
+
+	if version > mg.latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, version, mg.latest)
+	}
+
+	if version == mg.latest {
+		return nil
+	}
+
+	logger := log.WithField("component", "Migrator").
+		WithField("from", version).
+		WithField("to", mg.latest)
+
+	if version > 0 && mg.config.DriverName() == DatabaseDriverSqlite {
+		backupPath := fmt.Sprintf("%s.v%d-%s.bak", mg.config.Path, version, time.Now().UTC().Format("20060102T150405"))
+		if err := copyFile(mg.config.Path, backupPath); err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		logger = logger.WithField("backup", backupPath)
+	}
+
+	logger.Info("migrating database schema")
+
+	return mg.Up()
+}
+
+// Close releases the database connection held by the Migrator.
+func (mg *Migrator) Close() error {
+	srcErr, dbErr := mg.m.Close()
+	if srcErr != nil {
+		return srcErr
+	}
+
+	return dbErr
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			log.WithError(err).WithField("file", from).Error("failed to close file")
+		}
+	}()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// OpenDataStore opens the configured DataStore, first migrating the database to the latest schema version.
+func OpenDataStore(config DatabaseConfig) (DataStore, error) {
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrator.EnsureLatest()
+	if closeErr := migrator.Close(); closeErr != nil {
+		log.WithError(closeErr).Error("failed to close migrator")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.DriverName() {
+	case DatabaseDriverSqlite:
+		db, err := sqlx.Open("sqlite3", config.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewSqliteDataStore(db), nil
+	case DatabaseDriverPostgres:
+		db, err := sqlx.Open("postgres", config.DSN)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewPostgresDataStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", config.Driver)
+	}
+}
@@ -0,0 +1,25 @@
+-- The bundled sqlite does not support DROP COLUMN so the table is rebuilt without the range columns.
+CREATE TABLE observations_v1 (
+    id INTEGER PRIMARY KEY,
+    timestamp INTEGER NOT NULL,
+    wind_speed REAL DEFAULT 0.0,
+    wind_direction REAL DEFAULT 0.0,
+    wind_gust_speed REAL DEFAULT 0.0,
+    rainfall REAL DEFAULT 0.0,
+    temperature REAL DEFAULT 0.0,
+    humidity REAL DEFAULT 0.0,
+    pressure REAL DEFAULT 0.0,
+    interval_secs INTEGER NOT NULL,
+    published BOOLEAN NOT NULL DEFAULT false
+);
+
+INSERT INTO observations_v1 (id, timestamp, wind_speed, wind_direction, wind_gust_speed, rainfall, temperature,
+    humidity, pressure, interval_secs, published)
+SELECT id, timestamp, wind_speed, wind_direction, wind_gust_speed, rainfall, temperature, humidity, pressure,
+    interval_secs, published FROM observations;
+
+DROP TABLE observations;
+ALTER TABLE observations_v1 RENAME TO observations;
+
+CREATE UNIQUE INDEX idx_uniq_timestamp ON observations(timestamp);
+CREATE INDEX idx_published ON observations(published);
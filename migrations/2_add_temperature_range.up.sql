@@ -0,0 +1,2 @@
+ALTER TABLE observations ADD COLUMN temperature_min REAL;
+ALTER TABLE observations ADD COLUMN temperature_max REAL;
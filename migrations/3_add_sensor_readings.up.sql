@@ -0,0 +1 @@
+ALTER TABLE observations ADD COLUMN sensor_readings TEXT;
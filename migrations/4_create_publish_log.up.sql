@@ -0,0 +1,16 @@
+CREATE TABLE publish_log (
+    id INTEGER PRIMARY KEY,
+    time INTEGER NOT NULL,
+    target TEXT NOT NULL,
+    row_count INTEGER NOT NULL,
+    first_id INTEGER NOT NULL,
+    last_id INTEGER NOT NULL,
+    first_timestamp INTEGER NOT NULL,
+    last_timestamp INTEGER NOT NULL,
+    bytes INTEGER NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    latency_ms INTEGER NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_publish_log_time ON publish_log(time);
@@ -0,0 +1,2 @@
+DROP TABLE station_observations;
+DROP TABLE stations;
@@ -0,0 +1,32 @@
+CREATE TABLE stations (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    latitude REAL,
+    longitude REAL,
+    altitude REAL,
+    sensor_heights TEXT,
+    timezone TEXT NOT NULL DEFAULT '',
+    software TEXT NOT NULL DEFAULT '',
+    last_seen INTEGER NOT NULL
+);
+
+CREATE TABLE station_observations (
+    id INTEGER PRIMARY KEY,
+    station_id TEXT NOT NULL REFERENCES stations(id),
+    timestamp INTEGER NOT NULL,
+    wind_speed REAL DEFAULT 0.0,
+    wind_direction REAL DEFAULT 0.0,
+    wind_gust_speed REAL DEFAULT 0.0,
+    rainfall REAL DEFAULT 0.0,
+    temperature REAL DEFAULT 0.0,
+    humidity REAL DEFAULT 0.0,
+    pressure REAL DEFAULT 0.0,
+    temperature_min REAL,
+    temperature_max REAL,
+    sensor_readings TEXT,
+    interval_secs INTEGER NOT NULL,
+    received_at INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_uniq_station_timestamp ON station_observations(station_id, timestamp);
+CREATE INDEX idx_station_observations_timestamp ON station_observations(timestamp);
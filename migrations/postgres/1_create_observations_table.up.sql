@@ -0,0 +1,28 @@
+CREATE TABLE observations (
+    id BIGSERIAL NOT NULL,
+    timestamp BIGINT NOT NULL,
+    wind_speed DOUBLE PRECISION DEFAULT 0.0,
+    wind_direction REAL DEFAULT 0.0,
+    wind_gust_speed DOUBLE PRECISION DEFAULT 0.0,
+    rainfall DOUBLE PRECISION DEFAULT 0.0,
+    temperature DOUBLE PRECISION DEFAULT 0.0,
+    humidity DOUBLE PRECISION DEFAULT 0.0,
+    pressure DOUBLE PRECISION DEFAULT 0.0,
+    interval_secs INTEGER NOT NULL,
+    published BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX idx_uniq_timestamp ON observations(timestamp);
+CREATE INDEX idx_id ON observations(id);
+CREATE INDEX idx_published ON observations(published);
+
+-- Convert observations into a hypertable when the TimescaleDB extension is installed on the server. Unique indexes on
+-- a hypertable must include the partitioning column, which is why id is not a primary key here.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb') THEN
+        CREATE EXTENSION IF NOT EXISTS timescaledb;
+        PERFORM create_hypertable('observations', 'timestamp', chunk_time_interval => 604800, migrate_data => true);
+    END IF;
+END
+$$;
@@ -0,0 +1 @@
+ALTER TABLE observations DROP COLUMN temperature_min, DROP COLUMN temperature_max;
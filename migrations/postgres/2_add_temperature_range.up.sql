@@ -0,0 +1 @@
+ALTER TABLE observations ADD COLUMN temperature_min DOUBLE PRECISION, ADD COLUMN temperature_max DOUBLE PRECISION;
@@ -0,0 +1 @@
+ALTER TABLE observations DROP COLUMN sensor_readings;
@@ -0,0 +1,16 @@
+CREATE TABLE publish_log (
+    id BIGSERIAL PRIMARY KEY,
+    time BIGINT NOT NULL,
+    target TEXT NOT NULL,
+    row_count INTEGER NOT NULL,
+    first_id BIGINT NOT NULL,
+    last_id BIGINT NOT NULL,
+    first_timestamp BIGINT NOT NULL,
+    last_timestamp BIGINT NOT NULL,
+    bytes INTEGER NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_publish_log_time ON publish_log(time);
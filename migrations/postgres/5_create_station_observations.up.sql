@@ -0,0 +1,44 @@
+CREATE TABLE stations (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    altitude DOUBLE PRECISION,
+    sensor_heights JSONB,
+    timezone TEXT NOT NULL DEFAULT '',
+    software TEXT NOT NULL DEFAULT '',
+    last_seen BIGINT NOT NULL
+);
+
+CREATE TABLE station_observations (
+    id BIGSERIAL NOT NULL,
+    station_id TEXT NOT NULL REFERENCES stations(id),
+    timestamp BIGINT NOT NULL,
+    wind_speed DOUBLE PRECISION DEFAULT 0.0,
+    wind_direction REAL DEFAULT 0.0,
+    wind_gust_speed DOUBLE PRECISION DEFAULT 0.0,
+    rainfall DOUBLE PRECISION DEFAULT 0.0,
+    temperature DOUBLE PRECISION DEFAULT 0.0,
+    humidity DOUBLE PRECISION DEFAULT 0.0,
+    pressure DOUBLE PRECISION DEFAULT 0.0,
+    temperature_min DOUBLE PRECISION,
+    temperature_max DOUBLE PRECISION,
+    sensor_readings JSONB,
+    interval_secs INTEGER NOT NULL,
+    received_at BIGINT NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_uniq_station_timestamp ON station_observations(station_id, timestamp);
+CREATE INDEX idx_station_observations_id ON station_observations(id);
+CREATE INDEX idx_station_observations_timestamp ON station_observations(timestamp);
+
+-- As with observations, station_observations becomes a hypertable when TimescaleDB is available.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb') THEN
+        CREATE EXTENSION IF NOT EXISTS timescaledb;
+        PERFORM create_hypertable('station_observations', 'timestamp', chunk_time_interval => 604800,
+            migrate_data => true);
+    END IF;
+END
+$$;
@@ -0,0 +1 @@
+DROP TABLE publish_log_rows;
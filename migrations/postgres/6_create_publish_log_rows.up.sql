@@ -0,0 +1,6 @@
+CREATE TABLE publish_log_rows (
+    attempt_id BIGINT NOT NULL REFERENCES publish_log(id),
+    observation_id BIGINT NOT NULL
+);
+
+CREATE INDEX idx_publish_log_rows_attempt ON publish_log_rows(attempt_id);
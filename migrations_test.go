@@ -0,0 +1,138 @@
+package weatherstn
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMigrator_EnsureLatestEmbedded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DatabaseConfig{Path: filepath.Join(dir, "weather.db")}
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		t.Fatalf("unexpected error creating migrator: %v", err)
+	}
+	defer migrator.Close()
+
+	if err := migrator.EnsureLatest(); err != nil {
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+
+	version, dirty, err := migrator.m.Version()
+	if err != nil {
+		t.Fatalf("unexpected error reading version: %v", err)
+	}
+	if version != migrator.latest || dirty {
+		t.Fatalf("expected clean version %d but was %d (dirty %t)", migrator.latest, version, dirty)
+	}
+
+	// Running again against an up to date database is a no-op.
+	if err := migrator.EnsureLatest(); err != nil {
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+}
+
+func TestMigrator_EnsureLatestBacksUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0700); err != nil {
+		t.Fatalf("unexpected error creating migrations dir: %v", err)
+	}
+	files := map[string]string{
+		"1_one.up.sql":   "CREATE TABLE one (id INTEGER);",
+		"1_one.down.sql": "DROP TABLE one;",
+		"2_two.up.sql":   "CREATE TABLE two (id INTEGER);",
+		"2_two.down.sql": "DROP TABLE two;",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(migrations, name), []byte(contents), 0600); err != nil {
+			t.Fatalf("unexpected error writing migration: %v", err)
+		}
+	}
+
+	config := DatabaseConfig{Path: filepath.Join(dir, "weather.db"), Migrations: migrations}
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		t.Fatalf("unexpected error creating migrator: %v", err)
+	}
+	defer migrator.Close()
+
+	if err := migrator.Steps(1); err != nil {
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+
+	if err := migrator.EnsureLatest(); err != nil {
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+
+	backups, err := filepath.Glob(config.Path + ".v1-*.bak")
+	if err != nil {
+		t.Fatalf("unexpected error listing backups: %v", err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup but got %d", len(backups))
+	}
+
+	// The backup is of the database before the second migration was applied.
+	db, err := sqlx.Open("sqlite3", backups[0])
+	if err != nil {
+		t.Fatalf("unexpected error opening backup: %v", err)
+	}
+	defer db.Close()
+
+	var tables []string
+	err = db.Select(&tables, "SELECT name FROM sqlite_master WHERE type='table' AND name IN ('one', 'two');")
+	if err != nil {
+		t.Fatalf("unexpected error reading backup: %v", err)
+	}
+	if len(tables) != 1 || tables[0] != "one" {
+		t.Fatalf("expected backup to only contain table one but had %v", tables)
+	}
+}
+
+func TestMigrator_EnsureLatestRefusesNewerSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DatabaseConfig{Path: filepath.Join(dir, "weather.db")}
+	migrator, err := NewMigrator(config)
+	if err != nil {
+		t.Fatalf("unexpected error creating migrator: %v", err)
+	}
+	defer migrator.Close()
+
+	if err := migrator.EnsureLatest(); err != nil {
+		t.Fatalf("unexpected error migrating schema: %v", err)
+	}
+
+	// Simulate a newer binary having migrated the database.
+	db, err := sqlx.Open("sqlite3", config.Path)
+	if err != nil {
+		t.Fatalf("unexpected error opening database: %v", err)
+	}
+	defer db.Close()
+	db.MustExec("UPDATE schema_migrations SET version = ?;", migrator.latest+1)
+
+	err = migrator.EnsureLatest()
+	if !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew but got %v", err)
+	}
+}
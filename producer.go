@@ -1,6 +1,10 @@
 package weatherstn
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -8,77 +12,514 @@ import (
 
 // SensorProducer collects weather station readings from sensors.
 type SensorProducer struct {
-	atmosProvider AtmosphericSensorProvider
-	windProvider  WindSensorProvider
-	rainProvider  RainSensorProvider
-	datastore     DataStore
-	stopCh        chan struct{}
+	sensors    []*producerSensor
+	datastore  DataStore
+	spool      *Spool
+	aggregator *Aggregator
+
+	// maxLoopWork bounds how long a single pass of the Run loop can spend sampling and storing, used with the time of
+	// the last pass to tell whether the loop is still making progress.
+	maxLoopWork time.Duration
+	lastLoop    time.Time
+	loopWait    time.Duration
+	loopLock    sync.Mutex
+
+	intervalCh chan time.Duration
+
+	observer func(row WeatherDataRow)
+}
+
+// SamplingConfig configures how often each kind of sensor is sampled within an observation interval and how the
+// samples are aggregated into the observation. A sensor with no interval is sampled once per observation.
+type SamplingConfig struct {
+	AtmosIntervalSecs int               `json:"atmosIntervalSecs"`
+	WindIntervalSecs  int               `json:"windIntervalSecs"`
+	RainIntervalSecs  int               `json:"rainIntervalSecs"`
+	Aggregations      map[string]string `json:"aggregations"` // metric name to mean, min, max, sum or last
+}
+
+// producerSensor is a Sensor along with the kinds of readings it provides, the samples taken from it and the supervisor
+// tracking its health.
+type producerSensor struct {
+	Sensor
+	atmos       AtmosphericSensorProvider
+	wind        WindSensorProvider
+	rain        RainSensorProvider
+	samples     *sampleBuffer
+	supervisor  *sensorSupervisor
+	calibration calibration
 }
 
-// NewSensorProducer creates and returns a SensorProducer.
-func NewSensorProducer(atmosProvider AtmosphericSensorProvider, windProvider WindSensorProvider,
-	rainProvider RainSensorProvider, store DataStore) *SensorProducer {
+// NewSensorProducer creates and returns a SensorProducer. There can be any number of sensors of each kind, the first
+// of each kind fills the observation's readings and the readings of the rest are kept by sensor ID. Observations which
+// fail to be written to the store are held in the spool until writes succeed again. Sensors are connected by Run and
+// supervised, a sensor which can't be connected or keeps failing is reconnected with backoff rather than stopping the
+// producer.
+func NewSensorProducer(sensors []Sensor, store DataStore, spool *Spool, sampling SamplingConfig,
+	supervision SupervisorConfig) (*SensorProducer, error) {
+	aggregator, err := NewAggregator(sampling.Aggregations)
+	if err != nil {
+		return nil, err
+	}
+
+	maxLoopWork := storeTimeout
+	producerSensors := make([]*producerSensor, len(sensors))
+	for i, sensor := range sensors {
+		ps := &producerSensor{
+			Sensor:     sensor,
+			samples:    newSampleBuffer(),
+			supervisor: newSensorSupervisor(sensor, supervision),
+		}
+		ps.atmos, _ = sensor.Provider.(AtmosphericSensorProvider)
+		ps.wind, _ = sensor.Provider.(WindSensorProvider)
+		ps.rain, _ = sensor.Provider.(RainSensorProvider)
+		if ps.atmos == nil && ps.wind == nil && ps.rain == nil {
+			return nil, fmt.Errorf("sensor %s does not provide any readings", sensor.ID)
+		}
+
+		if ps.SampleInterval == 0 {
+			switch {
+			case ps.atmos != nil:
+				ps.SampleInterval = time.Duration(sampling.AtmosIntervalSecs) * time.Second
+			case ps.wind != nil:
+				ps.SampleInterval = time.Duration(sampling.WindIntervalSecs) * time.Second
+			default:
+				ps.SampleInterval = time.Duration(sampling.RainIntervalSecs) * time.Second
+			}
+		}
+
+		// A sensor may be connected and then read once for each kind of readings that it provides.
+		reads := 1
+		for _, provides := range []bool{ps.atmos != nil, ps.wind != nil, ps.rain != nil} {
+			if provides {
+				reads++
+			}
+		}
+		maxLoopWork += time.Duration(reads) * ps.supervisor.readTimeout
+
+		producerSensors[i] = ps
+	}
+
 	return &SensorProducer{
-		atmosProvider: atmosProvider,
-		windProvider:  windProvider,
-		rainProvider:  rainProvider,
-		datastore:     store,
-		stopCh:        make(chan struct{}),
+		sensors:     producerSensors,
+		datastore:   store,
+		spool:       spool,
+		aggregator:  aggregator,
+		maxLoopWork: maxLoopWork,
+		intervalCh:  make(chan time.Duration, 1),
+	}, nil
+}
+
+// sample reads each kind of readings from the sensor into its samples, connecting the sensor first if needed. The
+// sample is reported to the supervisor as failed if any of the readings fail or time out, readings which have no new
+// sample are left out.
+func (ps *producerSensor) sample(ctx context.Context) {
+	if !ps.supervisor.ready(ctx) {
+		return
+	}
+
+	var readings SensorReadings
+	var sampleErr error
+	if ps.atmos != nil {
+		readCtx, cancel := ps.supervisor.readContext(ctx)
+		atmos, err := ps.atmos.Readings(readCtx)
+		cancel()
+		switch {
+		case err == nil:
+			atmos = ps.calibration.atmos(atmos)
+			ps.samples.addAtmos(atmos)
+			readings.AtmosReadings = atmos
+		case errors.Is(err, ErrNoSample):
+			err = nil
+		}
+		sampleErr = ps.logReadError(err, "atmospheric readings", sampleErr)
+	}
+
+	if ps.wind != nil {
+		readCtx, cancel := ps.supervisor.readContext(ctx)
+		wind, err := ps.wind.Readings(readCtx)
+		cancel()
+		switch {
+		case err == nil:
+			wind = ps.calibration.wind(wind)
+			ps.samples.addWind(wind)
+			readings.WindReadings = wind
+		case errors.Is(err, ErrNoSample):
+			err = nil
+		}
+		sampleErr = ps.logReadError(err, "wind readings", sampleErr)
+	}
+
+	if ps.rain != nil {
+		readCtx, cancel := ps.supervisor.readContext(ctx)
+		rain, err := ps.rain.Readings(readCtx)
+		cancel()
+		switch {
+		case err == nil:
+			rain = ps.calibration.rain(rain)
+			ps.samples.addRain(rain)
+			readings.RainReadings = rain
+		case errors.Is(err, ErrNoSample):
+			err = nil
+		}
+		sampleErr = ps.logReadError(err, "rain readings", sampleErr)
+	}
+
+	if ctx.Err() != nil {
+		// Shutting down, the failure says nothing about the health of the sensor.
+		return
 	}
+
+	if sampleErr != nil {
+		ps.supervisor.failed(sampleErr)
+		return
+	}
+
+	ps.supervisor.succeeded(readings)
 }
 
-func (sp *SensorProducer) poll() (AtmoshphericReadings, WindReadings, RainReadings) {
-	atmosReadings, err := sp.atmosProvider.Readings()
-	if err != nil {
-		atmosReadings = &AtmoshphericReadings{}
-		log.WithError(err).WithField("event", "atmospheric readings")
+// logReadError logs err if it is set, returning the first error seen in the sample.
+func (ps *producerSensor) logReadError(err error, event string, sampleErr error) error {
+	if err == nil {
+		return sampleErr
 	}
 
-	windReadings, err := sp.windProvider.Readings()
-	if err != nil {
-		windReadings = &WindReadings{}
-		log.WithError(err).WithField("event", "wind readings")
+	log.WithError(err).
+		WithField("component", "SensorProducer").
+		WithField("event", event).
+		WithField("sensor", ps.ID).
+		Error("failed to read sensor")
+
+	if sampleErr != nil {
+		return sampleErr
 	}
+	return err
+}
 
-	rainReadings, err := sp.rainProvider.Readings()
-	if err != nil {
-		rainReadings = &RainReadings{}
-		log.WithError(err).WithField("event", "rain readings")
+// observe aggregates the samples taken from each sensor since the last observation. The first sensor of each kind
+// provides the readings of the observation, the readings of any others are returned keyed by sensor ID.
+func (sp *SensorProducer) observe() (AtmoshphericReadings, WindReadings, RainReadings, map[string]SensorReadings) {
+	atmos, wind, rain := sp.aggregator.Readings(nil)
+	var haveAtmos, haveWind, haveRain bool
+	var others map[string]SensorReadings
+	other := func(id string, update func(readings *SensorReadings)) {
+		if others == nil {
+			others = make(map[string]SensorReadings)
+		}
+		readings := others[id]
+		update(&readings)
+		others[id] = readings
+	}
+
+	for _, ps := range sp.sensors {
+		sensorAtmos, sensorWind, sensorRain := sp.aggregator.Readings(ps.samples.drain())
+		if ps.atmos != nil {
+			if !haveAtmos {
+				atmos, haveAtmos = sensorAtmos, true
+			} else {
+				other(ps.ID, func(readings *SensorReadings) { readings.AtmosReadings = &sensorAtmos })
+			}
+		}
+		if ps.wind != nil {
+			if !haveWind {
+				wind, haveWind = sensorWind, true
+			} else {
+				other(ps.ID, func(readings *SensorReadings) { readings.WindReadings = &sensorWind })
+			}
+		}
+		if ps.rain != nil {
+			if !haveRain {
+				rain, haveRain = sensorRain, true
+			} else {
+				other(ps.ID, func(readings *SensorReadings) { readings.RainReadings = &sensorRain })
+			}
+		}
+	}
+
+	return atmos, wind, rain, others
+}
+
+// sampler samples a sensor at its own interval, last is the boundary at which it was last sampled.
+type sampler struct {
+	interval time.Duration
+	sample   func(ctx context.Context)
+	last     time.Time
+}
+
+// due returns whether a boundary of the sampler's interval has passed since it was last sampled.
+func (s *sampler) due(now time.Time) bool {
+	return now.Truncate(s.interval).After(s.last)
+}
+
+// samplers splits the sensors into those which are sampled at their own interval and those which are sampled once per
+// observation, which includes any configured to sample less often than observations are made.
+func (sp *SensorProducer) samplers(now time.Time, interval time.Duration) ([]*sampler,
+	[]func(ctx context.Context)) {
+	var scheduled []*sampler
+	var unscheduled []func(ctx context.Context)
+	for _, ps := range sp.sensors {
+		if ps.SampleInterval <= 0 || ps.SampleInterval >= interval {
+			unscheduled = append(unscheduled, ps.sample)
+			continue
+		}
+
+		scheduled = append(scheduled, &sampler{
+			interval: ps.SampleInterval,
+			sample:   ps.sample,
+			last:     now.Truncate(ps.SampleInterval),
+		})
 	}
 
-	return *atmosReadings, *windReadings, *rainReadings
+	return scheduled, unscheduled
 }
 
-// Run starts the collector for gathering and saving readings.
-func (sp *SensorProducer) Run(interval time.Duration) {
+const (
+	// clockJumpThreshold is how far the wall clock may move relative to the monotonic clock between polls before it
+	// is treated as having been stepped, e.g. by NTP.
+	clockJumpThreshold = 2 * time.Second
+
+	// storeTimeout is how long writing an observation to the datastore may take before it is spooled instead.
+	storeTimeout = 10 * time.Second
+)
+
+// Run starts the collector for gathering and saving readings. Polls are aligned to wall clock boundaries of the
+// interval, e.g. every :00 and :30 for a 30 second interval, so that observations line up between stations. Rows are
+// timestamped with the boundary and record the actual time elapsed since the previous poll as their interval. Sensors
+// with their own sampling interval are sampled on the boundaries of that interval in between and their samples are
+// aggregated into the row. The interval can be changed with SetInterval while Run is running. Run returns once ctx is
+// done, disconnecting the sensors.
+func (sp *SensorProducer) Run(ctx context.Context, interval time.Duration) {
+	lastPoll := time.Now()
+	// The boundary before Run started isn't recorded, the first observation would otherwise only hold the samples
+	// taken since starting.
+	lastTimestamp := lastPoll.Truncate(interval).Unix()
+	scheduled, unscheduled := sp.samplers(lastPoll, interval)
+
+	for _, ps := range sp.sensors {
+		ps.supervisor.ready(ctx)
+	}
+	defer func() {
+		for _, ps := range sp.sensors {
+			ps.supervisor.disconnect()
+		}
+	}()
+
+	defer sp.heartbeat(time.Time{}, 0)
+
 	for {
+		next := nextBoundary(time.Now(), interval)
+		for _, s := range scheduled {
+			if boundary := nextBoundary(time.Now(), s.interval); boundary.Before(next) {
+				next = boundary
+			}
+		}
+		sp.heartbeat(time.Now(), time.Until(next))
+
 		select {
-		case <-sp.stopCh:
+		case <-ctx.Done():
 			return
-		case <-time.After(interval):
+		case interval = <-sp.intervalCh:
+			scheduled, unscheduled = sp.samplers(time.Now(), interval)
+			continue
+		case <-time.After(time.Until(next)):
+		}
+
+		now := time.Now()
+		for _, s := range scheduled {
+			if s.due(now) {
+				s.last = now.Truncate(s.interval)
+				s.sample(ctx)
+			}
+		}
+
+		timestamp, ok := alignedTimestamp(now, interval, lastTimestamp)
+		if !ok {
+			// Either only samples were due or the wall clock has been stepped backwards since the last poll, wait for
+			// the next boundary that hasn't been recorded yet rather than writing a duplicate timestamp.
+			continue
+		}
+
+		elapsed := now.Sub(lastPoll) // monotonic so unaffected by clock steps
+		if jump := now.Round(0).Sub(lastPoll.Round(0)) - elapsed; jump > clockJumpThreshold ||
+			jump < -clockJumpThreshold {
+			log.WithField("component", "SensorProducer").
+				WithField("event", "Run").
+				WithField("jump", jump.String()).
+				Warn("wall clock jump detected")
 		}
+		lastPoll = now
+		lastTimestamp = timestamp
 
-		t := time.Now().Unix()
-		atmosReadings, windReadings, rainReadings := sp.poll()
+		for _, sample := range unscheduled {
+			sample(ctx)
+		}
+		atmosReadings, windReadings, rainReadings, sensorReadings := sp.observe()
 
-		err := sp.datastore.Write(WeatherDataRow{
-			Timestamp:       t,
+		row := WeatherDataRow{
+			Timestamp:       timestamp,
 			AtmosReadings:   atmosReadings,
 			WindReadings:    windReadings,
 			RainReadings:    rainReadings,
-			IntervalSeconds: int(interval.Seconds()),
-		})
-		if err != nil {
+			SensorReadings:  sensorReadings,
+			IntervalSeconds: int(elapsed.Round(time.Second).Seconds()),
+		}
+		sp.store(row)
+		if sp.observer != nil {
+			sp.observer(row)
+		}
+	}
+}
+
+// nextBoundary returns the first wall clock boundary of interval after now.
+func nextBoundary(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
+
+// alignedTimestamp returns the unix timestamp of the latest interval boundary at or before now, or false if that
+// boundary is not after the last recorded timestamp. If the clock has jumped forward past several boundaries only the
+// latest is used.
+func alignedTimestamp(now time.Time, interval time.Duration, lastTimestamp int64) (int64, bool) {
+	timestamp := now.Truncate(interval).Unix()
+	if timestamp <= lastTimestamp {
+		return 0, false
+	}
+
+	return timestamp, true
+}
+
+// store writes the row to the datastore, first replaying anything spooled by earlier failures so that rows are
+// written in order. If the datastore is unavailable or doesn't respond within storeTimeout the row is spooled instead.
+// The write isn't tied to the run context so that an observation which has been made is still stored during shutdown.
+func (sp *SensorProducer) store(row WeatherDataRow) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	if sp.spool.Len() > 0 {
+		if err := sp.spool.Replay(ctx, sp.datastore); err != nil {
+			sp.spool.Push(row)
 			log.WithError(err).
 				WithField("component", "SensorProducer").
 				WithField("event", "store").
-				Error("failed to write sensor data to store")
+				WithField("spooled", sp.spool.Len()).
+				Error("failed to replay spooled sensor data to store")
+			return
 		}
+
+		log.WithField("component", "SensorProducer").
+			WithField("event", "store").
+			Info("replayed spooled sensor data to store")
+	}
+
+	err := sp.datastore.Write(ctx, row)
+	if errors.Is(err, ErrDuplicateObservation) {
+		log.WithError(err).
+			WithField("component", "SensorProducer").
+			WithField("event", "store").
+			Warn("sensor data already stored for timestamp")
+		return
+	}
+	if err != nil {
+		sp.spool.Push(row)
+		log.WithError(err).
+			WithField("component", "SensorProducer").
+			WithField("event", "store").
+			WithField("spooled", sp.spool.Len()).
+			Error("failed to write sensor data to store, spooling")
 	}
 }
 
-// Stop causes the run loop to be halted, returning once the run loop has completed any work.
-func (sp *SensorProducer) Stop() {
-	sp.stopCh <- struct{}{}
-	return
+// Calibrate sets the calibration of each sensor, keyed by sensor ID, taking effect from the next sample. Sensors which
+// aren't included are uncalibrated. It returns an error, without changing any calibration, if a calibration is invalid
+// or is for an unknown sensor.
+func (sp *SensorProducer) Calibrate(calibrations map[string]CalibrationConfig) error {
+	for id, calibration := range calibrations {
+		if !sp.hasSensor(id) {
+			return fmt.Errorf("calibration for unknown sensor %s", id)
+		}
+		if err := calibration.Validate(); err != nil {
+			return fmt.Errorf("invalid calibration for sensor %s: %v", id, err)
+		}
+	}
+
+	for _, ps := range sp.sensors {
+		ps.calibration.set(calibrations[ps.ID])
+	}
+
+	return nil
+}
+
+// SetObserver sets a function that Run calls with each observation once it has been written, or spooled if the
+// datastore is unavailable, e.g. AlertEngine.Observe. It must be set before Run is started.
+func (sp *SensorProducer) SetObserver(observer func(row WeatherDataRow)) {
+	sp.observer = observer
+}
+
+// SetInterval changes the interval of a running producer, taking effect from the next observation. Samples taken so
+// far are kept and aggregated into that observation.
+func (sp *SensorProducer) SetInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive but was %s", interval)
+	}
+
+	setLatest(sp.intervalCh, interval)
+
+	return nil
+}
+
+// setLatest sends d on ch, which has a buffer of one, replacing any value which hasn't been received yet. Only the
+// latest interval matters if Run hasn't picked up an earlier one yet.
+func setLatest(ch chan time.Duration, d time.Duration) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- d
+}
+
+func (sp *SensorProducer) hasSensor(id string) bool {
+	for _, ps := range sp.sensors {
+		if ps.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// heartbeat records that the Run loop has completed a pass and is about to wait for up to wait before the next one.
+// A zero now records that the loop has stopped.
+func (sp *SensorProducer) heartbeat(now time.Time, wait time.Duration) {
+	sp.loopLock.Lock()
+	sp.lastLoop = now
+	sp.loopWait = wait
+	sp.loopLock.Unlock()
+}
+
+// Alive returns whether Run is running and making progress, i.e. its loop has come round again within the time it
+// was due to wait plus the longest that sampling the sensors and storing an observation can take. It is false before
+// Run is started and after it returns.
+func (sp *SensorProducer) Alive(now time.Time) bool {
+	sp.loopLock.Lock()
+	defer sp.loopLock.Unlock()
+
+	if sp.lastLoop.IsZero() {
+		return false
+	}
+
+	return now.Sub(sp.lastLoop) <= sp.loopWait+sp.maxLoopWork
+}
+
+// SensorStatus returns the health of each sensor, in the order the sensors were given.
+func (sp *SensorProducer) SensorStatus() []SensorStatus {
+	statuses := make([]SensorStatus, len(sp.sensors))
+	for i, ps := range sp.sensors {
+		statuses[i] = ps.supervisor.Status()
+	}
+
+	return statuses
+}
+
+// SpoolStats returns the stats of the spool holding observations which have not yet been written.
+func (sp *SensorProducer) SpoolStats() SpoolStats {
+	return sp.spool.Stats()
 }
@@ -0,0 +1,108 @@
+package weatherstn
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// PublishEncodingJSON sends the PublishEnvelope as JSON, this is the default.
+	PublishEncodingJSON = "json"
+
+	// PublishEncodingCBOR sends the PublishEnvelope as CBOR (RFC 8949), with the same field names as JSON. It is
+	// typically around half the size of JSON before compression.
+	PublishEncodingCBOR = "cbor"
+
+	// PublishCompressionNone sends bodies uncompressed, this is the default.
+	PublishCompressionNone = "none"
+
+	// PublishCompressionGzip compresses bodies with gzip.
+	PublishCompressionGzip = "gzip"
+
+	// PublishCompressionZstd compresses bodies with zstd, which is both smaller and faster than gzip.
+	PublishCompressionZstd = "zstd"
+
+	// DefaultCompressionThresholdBytes is the smallest body which is compressed when no threshold is configured,
+	// compressing anything smaller costs more in headers than it saves.
+	DefaultCompressionThresholdBytes = 1024
+)
+
+// encodeEnvelope encodes the envelope for sending upstream, returning the body and its content type.
+func encodeEnvelope(envelope PublishEnvelope, encoding string) ([]byte, string, error) {
+	switch encoding {
+	case "", PublishEncodingJSON:
+		body, err := json.Marshal(envelope)
+		return body, "application/json", err
+	case PublishEncodingCBOR:
+		body, err := cbor.Marshal(envelope)
+		return body, "application/cbor", err
+	default:
+		return nil, "", fmt.Errorf("unknown encoding %s, valid encodings are %s and %s", encoding,
+			PublishEncodingJSON, PublishEncodingCBOR)
+	}
+}
+
+// compressBody compresses the body when it is at least threshold bytes, returning the body to send and its content
+// encoding, which is empty when the body is sent as it is.
+func compressBody(body []byte, compression string, threshold int) ([]byte, string, error) {
+	if compression == "" || compression == PublishCompressionNone || len(body) < threshold {
+		return body, "", nil
+	}
+
+	switch compression {
+	case PublishCompressionGzip:
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), PublishCompressionGzip, nil
+	case PublishCompressionZstd:
+		zw, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, "", err
+		}
+		compressed := zw.EncodeAll(body, nil)
+		if err := zw.Close(); err != nil {
+			return nil, "", err
+		}
+		return compressed, PublishCompressionZstd, nil
+	default:
+		return nil, "", fmt.Errorf("unknown compression %s, valid compressions are %s, %s and %s", compression,
+			PublishCompressionNone, PublishCompressionGzip, PublishCompressionZstd)
+	}
+}
+
+// MarshalCBOR writes the units as a map declaring the unit of every reading, as MarshalJSON does.
+func (u Units) MarshalCBOR() ([]byte, error) {
+	declared, err := u.declared()
+	if err != nil {
+		return nil, err
+	}
+
+	return cbor.Marshal(declared)
+}
+
+// UnmarshalCBOR accepts a map with a unit for each quantity, as written by MarshalCBOR.
+func (u *Units) UnmarshalCBOR(data []byte) error {
+	var declared map[string]string
+	if err := cbor.Unmarshal(data, &declared); err != nil {
+		return fmt.Errorf("units must be a map of units: %v", err)
+	}
+
+	units, err := unitsFromDeclared(declared)
+	if err != nil {
+		return err
+	}
+	*u = units
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package weatherstn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// PublishProxyNone disables the proxy for requests upstream, even when one is set in the environment.
+const PublishProxyNone = "none"
+
+// redactedValue replaces secrets in what is recorded and logged, as url.URL.Redacted does for passwords.
+const redactedValue = "xxxxx"
+
+// EndpointTemplateData is available to the templates of an Endpoint's path and query and of the EndpointConfig's
+// headers, e.g. "stations/{{.StationID}}/observations".
+type EndpointTemplateData struct {
+	StationID      string
+	SchemaVersion  int
+	Rows           int   // number of observations sent
+	FirstTimestamp int64 // unix seconds of the first observation sent
+	LastTimestamp  int64 // unix seconds of the last observation sent
+	Time           int64 // unix seconds when the request is made
+}
+
+// renderEndpointTemplate executes the template text with data.
+func renderEndpointTemplate(name, text string, data EndpointTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// baseURL returns the URL that endpoint paths are relative to.
+func (ec EndpointConfig) baseURL() (*url.URL, error) {
+	if ec.BaseURL == "" {
+		return &url.URL{Scheme: "https", Host: ec.Host}, nil
+	}
+
+	base, err := url.Parse(ec.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("scheme must be http or https but was %q", base.Scheme)
+	}
+	if base.Host == "" {
+		return nil, fmt.Errorf("%s has no host", ec.BaseURL)
+	}
+
+	return base, nil
+}
+
+// endpointURL returns the URL of the endpoint, with its path appended to the base URL and its query parameters added
+// to those of the base URL.
+func (ec EndpointConfig) endpointURL(endpoint Endpoint, data EndpointTemplateData) (*url.URL, error) {
+	target, err := ec.baseURL()
+	if err != nil {
+		return nil, fmt.Errorf("baseURL: %v", err)
+	}
+
+	path, err := renderEndpointTemplate("path", endpoint.Path, data)
+	if err != nil {
+		return nil, fmt.Errorf("path: %v", err)
+	}
+	if path != "" {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+		target.RawPath = ""
+	}
+
+	if len(endpoint.Query) > 0 {
+		query := target.Query()
+		for name, text := range endpoint.Query {
+			value, err := renderEndpointTemplate(name, text, data)
+			if err != nil {
+				return nil, fmt.Errorf("query.%s: %v", name, err)
+			}
+			query.Set(name, value)
+		}
+		target.RawQuery = query.Encode()
+	}
+
+	return target, nil
+}
+
+// redactURL returns u with any password and the value of every query parameter, which often carry API keys, redacted.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.RawQuery != "" {
+		query := redacted.Query()
+		for _, values := range query {
+			for i := range values {
+				values[i] = redactedValue
+			}
+		}
+		redacted.RawQuery = query.Encode()
+	}
+
+	return redacted.Redacted()
+}
+
+// redactRawURL is redactURL for a URL which hasn't been parsed, anything which can't be parsed as a URL, such as a
+// postgres key=value connection string, is redacted entirely.
+func redactRawURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return redactedValue
+	}
+
+	return redactURL(u)
+}
+
+// redactHeaders returns a copy of headers with every value other than that of a Host header redacted.
+func redactHeaders(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !strings.EqualFold(name, "Host") {
+			value = redactedValue
+		}
+		redacted[name] = value
+	}
+
+	return redacted
+}
+
+// redacted returns a copy of the config which is safe to log, with the query values of the base URL and endpoints,
+// the header values and any proxy password redacted.
+func (ec EndpointConfig) redacted() EndpointConfig {
+	ec.BaseURL = redactRawURL(ec.BaseURL)
+	if ec.SendObservations.Query != nil {
+		query := make(map[string]string, len(ec.SendObservations.Query))
+		for name := range ec.SendObservations.Query {
+			query[name] = redactedValue
+		}
+		ec.SendObservations.Query = query
+	}
+	ec.Headers = redactHeaders(ec.Headers)
+	if ec.Proxy != PublishProxyNone {
+		ec.Proxy = redactRawURL(ec.Proxy)
+	}
+
+	return ec
+}
+
+// setHeaders sets the configured headers on req, a Host header sets the host the request is sent as.
+func (ec EndpointConfig) setHeaders(req *http.Request, data EndpointTemplateData) error {
+	for name, text := range ec.Headers {
+		value, err := renderEndpointTemplate(name, text, data)
+		if err != nil {
+			return fmt.Errorf("headers.%s: %v", name, err)
+		}
+
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	return nil
+}
+
+// succeeded returns whether upstream accepted the observations with the status code.
+func (ec EndpointConfig) succeeded(statusCode int) bool {
+	if len(ec.SuccessStatusCodes) == 0 {
+		return statusCode == http.StatusCreated
+	}
+
+	for _, code := range ec.SuccessStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyURL returns the proxy that requests are sent through, nil for none, and whether one is configured at all.
+func (ec EndpointConfig) proxyURL() (*url.URL, bool, error) {
+	switch ec.Proxy {
+	case "":
+		return nil, false, nil
+	case PublishProxyNone:
+		return nil, true, nil
+	}
+
+	proxy, err := url.Parse(ec.Proxy)
+	if err != nil {
+		return nil, false, err
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, false, fmt.Errorf("scheme must be http, https or socks5 but was %q", proxy.Scheme)
+	}
+	if proxy.Host == "" {
+		return nil, false, fmt.Errorf("%s has no host", ec.Proxy)
+	}
+
+	return proxy, true, nil
+}
+
+// validateRequest adds the problems with the parts of the config used to build requests to problems.
+func (ec EndpointConfig) validateRequest(problems *configProblems) {
+	if ec.Host != "" && ec.BaseURL != "" {
+		problems.add("host and baseURL must not both be set")
+	}
+
+	// Rendering with empty data catches unknown fields as well as syntax errors.
+	if _, err := ec.endpointURL(ec.SendObservations, EndpointTemplateData{}); err != nil {
+		problems.add("sendObservations: %v", err)
+	}
+	for name := range ec.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			problems.add("headers: invalid header name %q", name)
+		}
+	}
+	if err := ec.setHeaders(&http.Request{Header: make(http.Header)}, EndpointTemplateData{}); err != nil {
+		problems.add("%v", err)
+	}
+	if _, _, err := ec.proxyURL(); err != nil {
+		problems.add("proxy: %v", err)
+	}
+	for _, code := range ec.SuccessStatusCodes {
+		if code < 100 || code > 599 {
+			problems.add("successStatusCodes: %d is not an HTTP status code", code)
+		}
+	}
+}
+
+// publisherProxyKey is the context key of the proxy a Publisher's request is sent through.
+type publisherProxyKey struct{}
+
+// withPublisherProxy returns ctx carrying the proxy that PublisherProxy sends a request made with it through, nil for
+// none.
+func withPublisherProxy(ctx context.Context, proxy *url.URL) context.Context {
+	return context.WithValue(ctx, publisherProxyKey{}, proxy)
+}
+
+// PublisherProxy is an http.Transport Proxy which sends a Publisher's requests through the proxy in its
+// EndpointConfig, so that reloading the config changes the proxy, and any other request through the proxy set in the
+// environment.
+func PublisherProxy(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(publisherProxyKey{}).(*url.URL); ok {
+		return proxy, nil
+	}
+
+	return http.ProxyFromEnvironment(req)
+}
+
+// NewPublisherHTTPClient returns an http client for a Publisher which sends requests through the configured proxy.
+func NewPublisherHTTPClient() *http.Client {
+	transport := &http.Transport{}
+	if defaultTransport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = defaultTransport.Clone()
+	}
+	transport.Proxy = PublisherProxy
+
+	return &http.Client{Transport: transport}
+}
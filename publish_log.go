@@ -0,0 +1,409 @@
+package weatherstn
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stmtInsertPublishAttempt = "INSERT INTO publish_log (time, target, row_count, first_id, last_id, " +
+		"first_timestamp, last_timestamp, bytes, status_code, latency_ms, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, " +
+		"?, ?)"
+	stmtInsertPublishAttemptRow = "INSERT INTO publish_log_rows (attempt_id, observation_id) VALUES (?, ?);"
+
+	queryFetchPublishLog = "SELECT id, time, target, row_count, first_id, last_id, first_timestamp, last_timestamp, " +
+		"bytes, status_code, latency_ms, error FROM publish_log WHERE time >= ? AND time < ? ORDER BY time ASC, id ASC;"
+	queryFetchPublishAttempt = "SELECT id, time, target, row_count, first_id, last_id, first_timestamp, " +
+		"last_timestamp, bytes, status_code, latency_ms, error FROM publish_log WHERE id = ?;"
+	stmtPrunePublishLogRows = "DELETE FROM publish_log_rows WHERE attempt_id IN (SELECT id FROM publish_log WHERE " +
+		"time < ?);"
+	stmtPrunePublishLog = "DELETE FROM publish_log WHERE time < ?;"
+	stmtRequeueDataRows = "UPDATE observations SET published=false WHERE timestamp >= ? AND timestamp < ? AND " +
+		"published=true;"
+	stmtRequeueAttemptRows = "UPDATE observations SET published=false WHERE id IN (SELECT observation_id FROM " +
+		"publish_log_rows WHERE attempt_id = ?) AND published=true;"
+)
+
+// ErrPublishAttemptNotFound is returned when there is no publish attempt with the ID asked for.
+var ErrPublishAttemptNotFound = errors.New("publish attempt not found")
+
+// PublishAttempt records a single attempt by the Publisher to send observations upstream.
+type PublishAttempt struct {
+	ID             int64  `db:"id"`
+	Time           int64  `db:"time"`   // unix seconds when the attempt started
+	Target         string `db:"target"` // URL the observations were sent to
+	Rows           int    `db:"row_count"`
+	FirstID        int64  `db:"first_id"`
+	LastID         int64  `db:"last_id"`
+	FirstTimestamp int64  `db:"first_timestamp"`
+	LastTimestamp  int64  `db:"last_timestamp"`
+	Bytes          int    `db:"bytes"`       // size of the request body as sent, after any compression
+	StatusCode     int    `db:"status_code"` // zero when no response was received
+	LatencyMillis  int64  `db:"latency_ms"`
+	Error          string `db:"error"` // empty when upstream accepted the observations
+
+	// RowIDs are the IDs of the rows sent, they are recorded with the attempt for requeueing it but not read back.
+	RowIDs []int64 `db:"-"`
+}
+
+// Succeeded returns whether upstream accepted the observations.
+func (pa PublishAttempt) Succeeded() bool {
+	return pa.Error == ""
+}
+
+// PublishHistory is implemented by DataStores which keep a log of every attempt to publish observations, so that
+// what was sent upstream can be checked and ranges of observations sent again. The Publisher records its attempts
+// when its DataStore implements it, as all of the DataStores in this package do.
+type PublishHistory interface {
+	// RecordPublish adds the attempt and the IDs of the rows it sent to the log, its ID is assigned by the store.
+	RecordPublish(ctx context.Context, attempt PublishAttempt) error
+
+	// ReadPublishLog returns the attempts made from (inclusive) up to to (exclusive), in unix seconds, in the order
+	// they were made.
+	ReadPublishLog(ctx context.Context, from, to int64) ([]PublishAttempt, error)
+
+	// ReadPublishAttempt returns the attempt with the ID, or ErrPublishAttemptNotFound if there isn't one.
+	ReadPublishAttempt(ctx context.Context, id int64) (PublishAttempt, error)
+
+	// PrunePublishLog removes the attempts made before before, in unix seconds, returning how many were removed.
+	PrunePublishLog(ctx context.Context, before int64) (int, error)
+
+	// Requeue marks the published rows with a timestamp from (inclusive) up to to (exclusive) as unpublished, so that
+	// the Publisher sends them again, returning how many were requeued.
+	Requeue(ctx context.Context, from, to int64) (int, error)
+
+	// RequeueAttempt marks the published rows which were sent by the attempt with the ID as unpublished, returning
+	// how many were requeued. Rows in the attempt's ID range which it didn't send are left alone.
+	RequeueAttempt(ctx context.Context, id int64) (int, error)
+}
+
+// newPublishAttempt returns an attempt to publish rows, which must be in timestamp order, with the rows and their
+// range filled in.
+func newPublishAttempt(rows []WeatherDataRow) PublishAttempt {
+	attempt := PublishAttempt{Rows: len(rows)}
+	if len(rows) == 0 {
+		return attempt
+	}
+
+	attempt.FirstID, attempt.LastID = rows[0].ID, rows[0].ID
+	attempt.RowIDs = make([]int64, len(rows))
+	for i, row := range rows {
+		attempt.RowIDs[i] = row.ID
+		if row.ID < attempt.FirstID {
+			attempt.FirstID = row.ID
+		}
+		if row.ID > attempt.LastID {
+			attempt.LastID = row.ID
+		}
+	}
+	attempt.FirstTimestamp = rows[0].Timestamp
+	attempt.LastTimestamp = rows[len(rows)-1].Timestamp
+
+	return attempt
+}
+
+// recordPublish adds the attempt and its rows to the log in a single transaction. Postgres doesn't report the ID of
+// an inserted row so returningID has the ID returned by the insert instead.
+func recordPublish(ctx context.Context, db *sqlx.DB, attempt PublishAttempt, returningID bool) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{
+		attempt.Time,
+		attempt.Target,
+		attempt.Rows,
+		attempt.FirstID,
+		attempt.LastID,
+		attempt.FirstTimestamp,
+		attempt.LastTimestamp,
+		attempt.Bytes,
+		attempt.StatusCode,
+		attempt.LatencyMillis,
+		attempt.Error,
+	}
+	if returningID {
+		err = tx.QueryRowxContext(ctx, tx.Rebind(stmtInsertPublishAttempt+" RETURNING id;"), args...).Scan(&attempt.ID)
+	} else {
+		var result sql.Result
+		result, err = tx.ExecContext(ctx, tx.Rebind(stmtInsertPublishAttempt+";"), args...)
+		if err == nil {
+			attempt.ID, err = result.LastInsertId()
+		}
+	}
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+
+	insert, err := tx.PrepareContext(ctx, tx.Rebind(stmtInsertPublishAttemptRow))
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	closeInsert := func() {
+		if err := insert.Close(); err != nil {
+			log.WithError(err).
+				WithField("component", "DataStore").
+				Error("failed to close statement")
+		}
+	}
+
+	for _, id := range attempt.RowIDs {
+		if _, err := insert.ExecContext(ctx, attempt.ID, id); err != nil {
+			closeInsert()
+			rollback(tx)
+			return err
+		}
+	}
+	closeInsert()
+
+	return tx.Commit()
+}
+
+func readPublishLog(ctx context.Context, db *sqlx.DB, from, to int64) ([]PublishAttempt, error) {
+	var attempts []PublishAttempt
+	if err := db.SelectContext(ctx, &attempts, db.Rebind(queryFetchPublishLog), from, to); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func readPublishAttempt(ctx context.Context, db *sqlx.DB, id int64) (PublishAttempt, error) {
+	var attempt PublishAttempt
+	err := db.GetContext(ctx, &attempt, db.Rebind(queryFetchPublishAttempt), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PublishAttempt{}, ErrPublishAttemptNotFound
+	}
+
+	return attempt, err
+}
+
+func prunePublishLog(ctx context.Context, db *sqlx.DB, before int64) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind(stmtPrunePublishLogRows), before); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, tx.Rebind(stmtPrunePublishLog), before)
+	if err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	return int(pruned), tx.Commit()
+}
+
+func requeue(ctx context.Context, db *sqlx.DB, query string, args ...interface{}) (int, error) {
+	result, err := db.ExecContext(ctx, db.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	requeued, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(requeued), nil
+}
+
+// RecordPublish adds the attempt to the publish_log table and its rows to the publish_log_rows table.
+func (sds *SqliteDataStore) RecordPublish(ctx context.Context, attempt PublishAttempt) error {
+	return recordPublish(ctx, sds.db, attempt, false)
+}
+
+// ReadPublishLog reads the attempts made from (inclusive) up to to (exclusive) from the publish_log table.
+func (sds *SqliteDataStore) ReadPublishLog(ctx context.Context, from, to int64) ([]PublishAttempt, error) {
+	return readPublishLog(ctx, sds.db, from, to)
+}
+
+// ReadPublishAttempt reads the attempt with the ID from the publish_log table.
+func (sds *SqliteDataStore) ReadPublishAttempt(ctx context.Context, id int64) (PublishAttempt, error) {
+	return readPublishAttempt(ctx, sds.db, id)
+}
+
+// PrunePublishLog removes the attempts made before before from the publish_log table.
+func (sds *SqliteDataStore) PrunePublishLog(ctx context.Context, before int64) (int, error) {
+	return prunePublishLog(ctx, sds.db, before)
+}
+
+// Requeue marks the published rows with a timestamp from (inclusive) up to to (exclusive) as unpublished.
+func (sds *SqliteDataStore) Requeue(ctx context.Context, from, to int64) (int, error) {
+	return requeue(ctx, sds.db, stmtRequeueDataRows, from, to)
+}
+
+// RequeueAttempt marks the published rows which the attempt with the ID sent as unpublished.
+func (sds *SqliteDataStore) RequeueAttempt(ctx context.Context, id int64) (int, error) {
+	return requeue(ctx, sds.db, stmtRequeueAttemptRows, id)
+}
+
+// RecordPublish adds the attempt to the publish_log table and its rows to the publish_log_rows table.
+func (pds *PostgresDataStore) RecordPublish(ctx context.Context, attempt PublishAttempt) error {
+	return recordPublish(ctx, pds.db, attempt, true)
+}
+
+// ReadPublishLog reads the attempts made from (inclusive) up to to (exclusive) from the publish_log table.
+func (pds *PostgresDataStore) ReadPublishLog(ctx context.Context, from, to int64) ([]PublishAttempt, error) {
+	return readPublishLog(ctx, pds.db, from, to)
+}
+
+// ReadPublishAttempt reads the attempt with the ID from the publish_log table.
+func (pds *PostgresDataStore) ReadPublishAttempt(ctx context.Context, id int64) (PublishAttempt, error) {
+	return readPublishAttempt(ctx, pds.db, id)
+}
+
+// PrunePublishLog removes the attempts made before before from the publish_log table.
+func (pds *PostgresDataStore) PrunePublishLog(ctx context.Context, before int64) (int, error) {
+	return prunePublishLog(ctx, pds.db, before)
+}
+
+// Requeue marks the published rows with a timestamp from (inclusive) up to to (exclusive) as unpublished.
+func (pds *PostgresDataStore) Requeue(ctx context.Context, from, to int64) (int, error) {
+	return requeue(ctx, pds.db, stmtRequeueDataRows, from, to)
+}
+
+// RequeueAttempt marks the published rows which the attempt with the ID sent as unpublished.
+func (pds *PostgresDataStore) RequeueAttempt(ctx context.Context, id int64) (int, error) {
+	return requeue(ctx, pds.db, stmtRequeueAttemptRows, id)
+}
+
+// RecordPublish adds the attempt to the log.
+func (mds *MemoryDataStore) RecordPublish(ctx context.Context, attempt PublishAttempt) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	mds.lastLogID++
+	attempt.ID = mds.lastLogID
+	mds.publishLogRows[attempt.ID] = attempt.RowIDs
+	attempt.RowIDs = nil
+	mds.publishLog = append(mds.publishLog, attempt)
+
+	return nil
+}
+
+// ReadPublishLog returns the attempts made from (inclusive) up to to (exclusive) in the order they were made.
+func (mds *MemoryDataStore) ReadPublishLog(ctx context.Context, from, to int64) ([]PublishAttempt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mds.lock.RLock()
+	defer mds.lock.RUnlock()
+
+	var attempts []PublishAttempt
+	for _, attempt := range mds.publishLog {
+		if attempt.Time >= from && attempt.Time < to {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	sort.SliceStable(attempts, func(i, j int) bool {
+		return attempts[i].Time < attempts[j].Time
+	})
+
+	return attempts, nil
+}
+
+// ReadPublishAttempt returns the attempt with the ID.
+func (mds *MemoryDataStore) ReadPublishAttempt(ctx context.Context, id int64) (PublishAttempt, error) {
+	if err := ctx.Err(); err != nil {
+		return PublishAttempt{}, err
+	}
+
+	mds.lock.RLock()
+	defer mds.lock.RUnlock()
+
+	for _, attempt := range mds.publishLog {
+		if attempt.ID == id {
+			return attempt, nil
+		}
+	}
+
+	return PublishAttempt{}, ErrPublishAttemptNotFound
+}
+
+// PrunePublishLog removes the attempts made before before from the log.
+func (mds *MemoryDataStore) PrunePublishLog(ctx context.Context, before int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	kept := mds.publishLog[:0]
+	for _, attempt := range mds.publishLog {
+		if attempt.Time >= before {
+			kept = append(kept, attempt)
+		} else {
+			delete(mds.publishLogRows, attempt.ID)
+		}
+	}
+	pruned := len(mds.publishLog) - len(kept)
+	mds.publishLog = kept
+
+	return pruned, nil
+}
+
+// Requeue marks the published rows with a timestamp from (inclusive) up to to (exclusive) as unpublished.
+func (mds *MemoryDataStore) Requeue(ctx context.Context, from, to int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	requeued := 0
+	for timestamp, row := range mds.rows {
+		if timestamp >= from && timestamp < to && row.published {
+			row.published = false
+			requeued++
+		}
+	}
+
+	return requeued, nil
+}
+
+// RequeueAttempt marks the published rows which the attempt with the ID sent as unpublished.
+func (mds *MemoryDataStore) RequeueAttempt(ctx context.Context, id int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	mds.lock.Lock()
+	defer mds.lock.Unlock()
+
+	requeued := 0
+	for _, rowID := range mds.publishLogRows[id] {
+		if row, ok := mds.ids[rowID]; ok && row.published {
+			row.published = false
+			requeued++
+		}
+	}
+
+	return requeued, nil
+}
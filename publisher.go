@@ -2,10 +2,13 @@ package weatherstn
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -14,21 +17,102 @@ import (
 // Endpoint represents a target endpoint.
 type Endpoint struct {
 	Method string `json:"method"`
-	Path   string `json:"path"`
+	Path   string `json:"path"` // relative to the base URL, a template of EndpointTemplateData
+
+	// Query parameters added to those of the base URL, each value is a template of EndpointTemplateData.
+	Query map[string]string `json:"query"`
 }
 
 // EndpointConfig represents the configuration of all endpoints that the publisher will send to.
 type EndpointConfig struct {
-	Host             string   `json:"host"`
+	// Host that observations are sent to over https, or BaseURL, e.g. http://localhost:8080/api?key=abc, for any
+	// other scheme, port, path prefix or query. Only one of them may be set.
+	Host    string `json:"host"`
+	BaseURL string `json:"baseURL"`
+
 	SendObservations Endpoint `json:"sendObservations"`
+	Units            Units    `json:"units"` // units observations are sent in, defaults to metric
+
+	// Headers added to every request, each value is a template of EndpointTemplateData. A Host header sets the host
+	// requests are sent as, Content-Type and Content-Encoding are always set by the Publisher.
+	Headers map[string]string `json:"headers"`
+
+	// Proxy that requests are sent through, e.g. http://proxy:3128, or PublishProxyNone. Defaults to the proxy set by
+	// the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables. Only applies to a Publisher using an http client
+	// from NewPublisherHTTPClient.
+	Proxy string `json:"proxy"`
+
+	// SuccessStatusCodes are the response status codes which mean upstream accepted the observations, defaults to
+	// 201 Created.
+	SuccessStatusCodes []int `json:"successStatusCodes"`
+
+	// Encoding of the PublishEnvelope, PublishEncodingJSON or PublishEncodingCBOR, defaults to JSON.
+	Encoding string `json:"encoding"`
+
+	// Compression of request bodies of at least CompressionThresholdBytes, PublishCompressionNone, Gzip or Zstd,
+	// defaults to none. The threshold defaults to DefaultCompressionThresholdBytes.
+	Compression               string `json:"compression"`
+	CompressionThresholdBytes int    `json:"compressionThresholdBytes"`
+}
+
+// Validate returns the problems with the endpoint config as a *ConfigError.
+func (ec EndpointConfig) Validate() error {
+	var problems configProblems
+	if ec.Host == "" && ec.BaseURL == "" {
+		problems.add("host or baseURL must be set")
+	}
+	ec.validateRequest(&problems)
+	switch ec.Encoding {
+	case "", PublishEncodingJSON, PublishEncodingCBOR:
+	default:
+		problems.add("unknown encoding %s, valid encodings are %s and %s", ec.Encoding, PublishEncodingJSON,
+			PublishEncodingCBOR)
+	}
+	switch ec.Compression {
+	case "", PublishCompressionNone, PublishCompressionGzip, PublishCompressionZstd:
+	default:
+		problems.add("unknown compression %s, valid compressions are %s, %s and %s", ec.Compression,
+			PublishCompressionNone, PublishCompressionGzip, PublishCompressionZstd)
+	}
+	problems.notNegative("compressionThresholdBytes", ec.CompressionThresholdBytes)
+
+	return problems.err()
+}
+
+// PublishSchemaVersion is the version of the PublishEnvelope format, it is incremented whenever the format changes in a
+// way which existing readers can't handle.
+const PublishSchemaVersion = 1
+
+// PublishEnvelope is the body of each request the Publisher sends upstream.
+type PublishEnvelope struct {
+	SchemaVersion int              `json:"schemaVersion"` // PublishSchemaVersion
+	Station       StationInfo      `json:"station"`
+	Units         Units            `json:"units"` // of every observation
+	Observations  []WeatherDataRow `json:"observations"`
 }
 
+// publishShutdownGrace is how long a publish in progress when the Publisher is stopped has to finish, which is within
+// the time the station waits for it on shutdown.
+const publishShutdownGrace = 10 * time.Second
+
+// DefaultPublishLogKeepDays is how long attempts are kept in the publish log when no retention is configured.
+const DefaultPublishLogKeepDays = 30
+
+// DefaultRequestTimeoutSecs is how long sending observations upstream may take when no timeout is configured.
+const DefaultRequestTimeoutSecs = 30
+
 // Publisher is responsible for sending data upstream.
 type Publisher struct {
+	datastore DataStore
+	cli       PublisherHTTPClient
+
+	station        StationInfo
 	endpointConfig EndpointConfig
-	datastore      DataStore
-	cli            PublisherHTTPClient
-	stopCh         chan struct{}
+	requestTimeout time.Duration
+	publishLogKeep time.Duration
+	configLock     sync.Mutex
+
+	intervalCh chan time.Duration
 }
 
 // PublisherHTTPClient is the http client that will be used by a Publisher.
@@ -36,32 +120,84 @@ type PublisherHTTPClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
-// NewPublisher creates a new Publisher.
-func NewPublisher(store DataStore, config EndpointConfig, cli PublisherHTTPClient) *Publisher {
+// NewPublisher creates a new Publisher which sends observations upstream as sent by station. Each request upstream is
+// given requestTimeout to complete, or DefaultRequestTimeoutSecs if it is zero.
+func NewPublisher(store DataStore, station StationInfo, config EndpointConfig, cli PublisherHTTPClient,
+	requestTimeout time.Duration) *Publisher {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeoutSecs * time.Second
+	}
+
 	return &Publisher{
 		datastore:      store,
+		station:        station,
 		endpointConfig: config,
 		cli:            cli,
-		stopCh:         make(chan struct{}),
+		requestTimeout: requestTimeout,
+		publishLogKeep: DefaultPublishLogKeepDays * 24 * time.Hour,
+		intervalCh:     make(chan time.Duration, 1),
+	}
+}
+
+// SetEndpoints changes the endpoints that observations are sent to and the timeout of each request, taking effect from
+// the next request. A zero requestTimeout uses DefaultRequestTimeoutSecs.
+func (p *Publisher) SetEndpoints(config EndpointConfig, requestTimeout time.Duration) {
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeoutSecs * time.Second
+	}
+
+	p.configLock.Lock()
+	p.endpointConfig = config
+	p.requestTimeout = requestTimeout
+	p.configLock.Unlock()
+}
+
+// SetStation changes the station info sent upstream, taking effect from the next request.
+func (p *Publisher) SetStation(station StationInfo) {
+	p.configLock.Lock()
+	p.station = station
+	p.configLock.Unlock()
+}
+
+// SetPublishLogKeepDays changes how many days attempts are kept in the publish log, older attempts are removed after
+// the next is recorded. Zero uses DefaultPublishLogKeepDays.
+func (p *Publisher) SetPublishLogKeepDays(days int) {
+	p.configLock.Lock()
+	p.publishLogKeep = time.Duration(defaultInt(days, DefaultPublishLogKeepDays)) * 24 * time.Hour
+	p.configLock.Unlock()
+}
+
+// SetInterval changes the interval of a running publisher, the next push happens interval after the change.
+func (p *Publisher) SetInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive but was %s", interval)
 	}
+
+	setLatest(p.intervalCh, interval)
+
+	return nil
 }
 
-// Run starts the publisher send loop.
-func (p *Publisher) Run(interval time.Duration) {
+// Run starts the publisher send loop, returning once ctx is done. A request in progress when ctx is done is given
+// publishShutdownGrace to finish, if it doesn't then its observations are sent again on the next run. The interval
+// can be changed with SetInterval while Run is running.
+func (p *Publisher) Run(ctx context.Context, interval time.Duration) {
 	for {
 		select {
-		case <-p.stopCh:
+		case <-ctx.Done():
 			return
+		case interval = <-p.intervalCh:
+			continue
 		case <-time.After(interval):
 		}
 
-		p.Process()
+		p.Process(ctx)
 	}
 }
 
 // Process is called each Run iteration and is exposed for testing.
-func (p *Publisher) Process() {
-	unpublishedObs, err := p.datastore.ReadUnpublished()
+func (p *Publisher) Process(ctx context.Context) {
+	unpublishedObs, err := p.datastore.ReadUnpublished(ctx)
 	if err != nil {
 		log.WithError(err).
 			WithField("component", "Publisher").
@@ -78,7 +214,33 @@ func (p *Publisher) Process() {
 		return
 	}
 
-	body, err := json.Marshal(unpublishedObs)
+	p.configLock.Lock()
+	station := p.station
+	endpointConfig := p.endpointConfig
+	requestTimeout := p.requestTimeout
+	publishLogKeep := p.publishLogKeep
+	p.configLock.Unlock()
+
+	units, err := endpointConfig.Units.normalize()
+	if err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("invalid units")
+		return
+	}
+
+	envelope := PublishEnvelope{
+		SchemaVersion: PublishSchemaVersion,
+		Station:       station,
+		Units:         units,
+		Observations:  make([]WeatherDataRow, len(unpublishedObs)),
+	}
+	for i, obs := range unpublishedObs {
+		envelope.Observations[i] = units.Convert(obs)
+	}
+
+	body, contentType, err := encodeEnvelope(envelope, endpointConfig.Encoding)
 	if err != nil {
 		log.WithError(err).
 			WithField("component", "Publisher").
@@ -87,13 +249,65 @@ func (p *Publisher) Process() {
 		return
 	}
 
-	req, err := http.NewRequest(
-		p.endpointConfig.SendObservations.Method,
-		(&url.URL{
-			Scheme: "https",
-			Host:   p.endpointConfig.Host,
-			Path:   p.endpointConfig.SendObservations.Path,
-		}).String(),
+	encodedSize := len(body)
+	body, contentEncoding, err := compressBody(body, endpointConfig.Compression,
+		defaultInt(endpointConfig.CompressionThresholdBytes, DefaultCompressionThresholdBytes))
+	if err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("failed to compress unpublished observations")
+		return
+	}
+	log.WithField("component", "Publisher").
+		WithField("event", "Run").
+		WithField("encodedBytes", encodedSize).
+		WithField("sentBytes", len(body)).
+		Debug("encoded unpublished observations")
+
+	attempt := newPublishAttempt(unpublishedObs)
+	start := time.Now()
+	templateData := EndpointTemplateData{
+		StationID:      station.ID,
+		SchemaVersion:  PublishSchemaVersion,
+		Rows:           attempt.Rows,
+		FirstTimestamp: attempt.FirstTimestamp,
+		LastTimestamp:  attempt.LastTimestamp,
+		Time:           start.Unix(),
+	}
+	target, err := endpointConfig.endpointURL(endpointConfig.SendObservations, templateData)
+	if err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("failed to build request url")
+		return
+	}
+
+	// The publish isn't abandoned as soon as the Publisher is stopped, so a response the endpoint has already acted on
+	// is still recorded.
+	publishCtx, cancelPublish := withShutdownGrace(ctx, publishShutdownGrace)
+	defer cancelPublish()
+
+	reqCtx, cancel := context.WithTimeout(publishCtx, requestTimeout)
+	defer cancel()
+
+	proxy, hasProxy, err := endpointConfig.proxyURL()
+	if err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("invalid proxy")
+		return
+	}
+	if hasProxy {
+		reqCtx = withPublisherProxy(reqCtx, proxy)
+	}
+
+	req, err := http.NewRequestWithContext(
+		reqCtx,
+		endpointConfig.SendObservations.Method,
+		target.String(),
 		ioutil.NopCloser(bytes.NewReader(body)),
 	)
 	if err != nil {
@@ -104,29 +318,67 @@ func (p *Publisher) Process() {
 		return
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	if err := endpointConfig.setHeaders(req, templateData); err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("failed to set request headers")
+		return
+	}
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	attempt.Target = redactURL(req.URL)
+	attempt.Bytes = len(body)
+	attempt.Time = start.Unix()
 
 	resp, err := p.cli.Do(req)
+	attempt.LatencyMillis = time.Since(start).Milliseconds()
 	if err != nil {
+		// The client's errors include the URL, which must be redacted too.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = attempt.Target
+		}
+		attempt.Error = err.Error()
+		p.recordAttempt(publishCtx, attempt, publishLogKeep)
 		log.WithError(err).
 			WithField("component", "Publisher").
 			WithField("event", "Run").
 			Error("failed to send http request")
 		return
 	}
+	if resp.Body != nil {
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.WithError(err).
+					WithField("component", "Publisher").
+					WithField("event", "Run").
+					Error("failed to close response body")
+			}
+		}()
+	}
 
-	if resp.StatusCode != http.StatusCreated {
+	attempt.StatusCode = resp.StatusCode
+	if !endpointConfig.succeeded(resp.StatusCode) {
+		attempt.Error = fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+		p.recordAttempt(publishCtx, attempt, publishLogKeep)
 		log.WithField("component", "Publisher").
 			WithField("event", "Run").
 			WithField("statusCode", resp.StatusCode).
 			Error("unexpected status code received")
 		return
 	}
+	p.recordAttempt(publishCtx, attempt, publishLogKeep)
 
-	err = p.datastore.UpdatePublished(
-		unpublishedObs[0].Timestamp,
-		unpublishedObs[len(unpublishedObs)-1].Timestamp,
-	)
+	ids := make([]int64, len(unpublishedObs))
+	for i, obs := range unpublishedObs {
+		ids[i] = obs.ID
+	}
+
+	err = p.datastore.UpdatePublished(publishCtx, ids)
 	if err != nil {
 		log.WithError(err).
 			WithField("component", "Publisher").
@@ -135,8 +387,60 @@ func (p *Publisher) Process() {
 	}
 }
 
-// Stop causes the run loop to be halted, returning once the run loop has completed any work.
-func (p *Publisher) Stop() {
-	p.stopCh <- struct{}{}
-	return
+// recordAttempt adds the attempt to the publish log when the datastore keeps one, removing the attempts older than
+// keep.
+func (p *Publisher) recordAttempt(ctx context.Context, attempt PublishAttempt, keep time.Duration) {
+	history, ok := p.datastore.(PublishHistory)
+	if !ok {
+		return
+	}
+
+	if err := history.RecordPublish(ctx, attempt); err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("failed to record publish attempt")
+		return
+	}
+
+	if _, err := history.PrunePublishLog(ctx, attempt.Time-int64(keep/time.Second)); err != nil {
+		log.WithError(err).
+			WithField("component", "Publisher").
+			WithField("event", "Run").
+			Error("failed to prune publish log")
+	}
 }
+
+// withShutdownGrace returns a context with the values of ctx which is only cancelled grace after ctx is, so that work
+// in progress when ctx is cancelled has a chance to finish.
+func withShutdownGrace(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(detachedContext{ctx})
+	go func() {
+		select {
+		case <-graceCtx.Done():
+			return
+		case <-ctx.Done():
+		}
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-graceCtx.Done():
+		case <-timer.C:
+			cancel()
+		}
+	}()
+
+	return graceCtx, cancel
+}
+
+// detachedContext keeps the values of its parent but not its deadline or cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
@@ -1,12 +1,11 @@
 package weatherstn
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"sync"
 	"time"
 
-	"golang.org/x/sys/unix"
-
 	log "github.com/sirupsen/logrus"
 
 	"github.com/warthog618/gpio"
@@ -15,10 +14,35 @@ import (
 // RainfallMMPerTip is the amount of rain in mm that corresponds to a single bucket sensor tip.
 const RainfallMMPerTip = 0.02794
 
+// Defaults for the SEN08942RainSensorProviderConfig, the pin is the BCM number wired as in the raspberry pi weather
+// station guide.
+const (
+	DefaultSEN08942RainPin          = 6
+	DefaultSEN08942RainIntervalSecs = 5
+)
+
+// SensorTypeSEN08942Rain is the config type of the SEN08942RainSensorProvider.
+const SensorTypeSEN08942Rain = "sen08942-rain"
+
+func init() {
+	RegisterSensorType(SensorTypeSEN08942Rain, func(id string, settings json.RawMessage) (SensorProvider, error) {
+		var config SEN08942RainSensorProviderConfig
+		if err := decodeSensorSettings(settings, &config); err != nil {
+			return nil, err
+		}
+
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+
+		return NewSEN08942RainSensorProvider(config), nil
+	})
+}
+
 // RainSensorProvider provides a way to setup and collect rain data readings.
 type RainSensorProvider interface {
 	SensorProvider
-	Readings() (*RainReadings, error)
+	Readings(ctx context.Context) (*RainReadings, error)
 }
 
 // RainReadings are the sensor readings about measurements such as rainfall.
@@ -43,27 +67,52 @@ type SEN08942RainSensorProvider struct {
 	haltedCh chan struct{}
 }
 
-// SEN08942RainSensorProviderConfig is used for setup of the SEN08942RainSensorProvider.
+// SEN08942RainSensorProviderConfig is used for setup of the SEN08942RainSensorProvider. A zero pin or interval uses
+// its default.
 type SEN08942RainSensorProviderConfig struct {
-	PinNumber int           `json:"pin"`
-	Interval  time.Duration `json:"intervalSecs"`
+	PinNumber int      `json:"pin"`
+	Interval  Duration `json:"intervalSecs"` // seconds, or a string such as "500ms"
+}
+
+// Validate returns an error if any of the settings are out of range.
+func (c SEN08942RainSensorProviderConfig) Validate() error {
+	var problems configProblems
+	problems.notNegative("pin", c.PinNumber)
+	if c.Interval < 0 {
+		problems.add("intervalSecs must not be negative but was %s", c.Interval.Duration())
+	}
+
+	return problems.err()
 }
 
 // NewSEN08942RainSensorProvider returns a new SEN08942RainSensorProvider.
 func NewSEN08942RainSensorProvider(config SEN08942RainSensorProviderConfig) *SEN08942RainSensorProvider {
+	interval := config.Interval.Duration()
+	if interval <= 0 {
+		interval = DefaultSEN08942RainIntervalSecs * time.Second
+	}
+
 	return &SEN08942RainSensorProvider{
-		pinNumber: config.PinNumber,
-		interval:  config.Interval,
+		pinNumber: defaultInt(config.PinNumber, DefaultSEN08942RainPin),
+		interval:  interval,
 
 		haltCh:   make(chan struct{}),
 		haltedCh: make(chan struct{}),
 	}
 }
 
+// Resources returns the GPIO pin used by the rain gauge.
+func (rsp *SEN08942RainSensorProvider) Resources() []string {
+	return []string{gpioResource(rsp.pinNumber)}
+}
+
 // Connect sets up the connections to pins and creates watchers.
-func (rsp *SEN08942RainSensorProvider) Connect() error {
-	err := gpio.Open()
-	if err != nil && !errors.Is(err, gpio.ErrAlreadyOpen) {
+func (rsp *SEN08942RainSensorProvider) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := openGPIO(); err != nil {
 		return err
 	}
 
@@ -71,9 +120,9 @@ func (rsp *SEN08942RainSensorProvider) Connect() error {
 	pin.Input()
 	pin.PullUp()
 
-	err = pin.Watch(gpio.EdgeRising, rsp.onPinHigh)
+	err := pin.Watch(gpio.EdgeRising, rsp.onPinHigh)
 	if err != nil {
-		closeErr := gpio.Close()
+		closeErr := closeGPIO()
 		if closeErr != nil {
 			log.WithError(closeErr).
 				WithField("component", "rain provider").
@@ -98,8 +147,7 @@ func (rsp *SEN08942RainSensorProvider) Disconnect() {
 	rsp.pin.Unwatch()
 	rsp.pinLock.Unlock()
 
-	err := gpio.Close()
-	if err != nil && !errors.Is(err, unix.EINVAL) { // We're using gpio in 2 places and closing it twice causes EINVAL
+	if err := closeGPIO(); err != nil {
 		log.WithError(err).
 			WithField("component", "rain provider").
 			Error("gpio failed to close")
@@ -107,7 +155,11 @@ func (rsp *SEN08942RainSensorProvider) Disconnect() {
 }
 
 // Readings returns the set of RainReadings provided by the SEN08942.
-func (rsp *SEN08942RainSensorProvider) Readings() (*RainReadings, error) {
+func (rsp *SEN08942RainSensorProvider) Readings(ctx context.Context) (*RainReadings, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	rsp.rainfallLock.Lock()
 	totalRainfall := rsp.totalRainfall
 	rsp.rainfallLock.Unlock()
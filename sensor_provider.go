@@ -1,7 +1,157 @@
 package weatherstn
 
-// SensorProvider is the base interface for sensor providers.
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SensorProvider is the base interface for sensor providers. Providers also implement one or more of
+// AtmosphericSensorProvider, WindSensorProvider and RainSensorProvider for the kinds of readings that they provide.
+// Connect and Readings return the context's error once it is done rather than blocking on a hung device.
 type SensorProvider interface {
-	Connect() error
+	Connect(ctx context.Context) error
 	Disconnect()
 }
+
+// ErrNoSample is returned by a provider's Readings when it has nothing new to report yet, e.g. an anemometer which
+// only works out a speed at the end of each of its own intervals. It isn't a failure, the sample is just skipped so
+// that it doesn't drag the aggregated readings towards zero.
+var ErrNoSample = errors.New("no new sample available")
+
+// ResourceUser is implemented by providers which need exclusive use of hardware, such as a GPIO pin or an I2C address,
+// so that sensors configured to use the same hardware are caught when the config is validated.
+type ResourceUser interface {
+	// Resources returns a description of each piece of hardware used, e.g. "gpio 5". Providers using the same
+	// hardware must describe it in the same way.
+	Resources() []string
+}
+
+// callWithContext calls fn, returning early with the context's error if the context is done before fn returns. fn is
+// left to finish in the background so it must not touch any state that the caller reads after an early return.
+func callWithContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SensorFactory creates a SensorProvider from the settings given for a sensor in the config.
+type SensorFactory func(id string, settings json.RawMessage) (SensorProvider, error)
+
+var (
+	sensorTypes     = make(map[string]SensorFactory)
+	sensorTypesLock sync.RWMutex
+)
+
+// RegisterSensorType makes a type of sensor available for use in the config. It panics if called twice for the same
+// type or with a nil factory.
+func RegisterSensorType(sensorType string, factory SensorFactory) {
+	sensorTypesLock.Lock()
+	defer sensorTypesLock.Unlock()
+
+	if factory == nil {
+		panic("weatherstn: RegisterSensorType factory is nil")
+	}
+	if _, ok := sensorTypes[sensorType]; ok {
+		panic("weatherstn: RegisterSensorType called twice for sensor type " + sensorType)
+	}
+
+	sensorTypes[sensorType] = factory
+}
+
+// SensorTypes returns a sorted list of the registered sensor types.
+func SensorTypes() []string {
+	sensorTypesLock.RLock()
+	defer sensorTypesLock.RUnlock()
+
+	return sensorTypeNames()
+}
+
+func sensorTypeNames() []string {
+	types := make([]string, 0, len(sensorTypes))
+	for sensorType := range sensorTypes {
+		types = append(types, sensorType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// Sensor is a SensorProvider along with the ID it was configured with, which identifies its readings when there are
+// several sensors of the same kind.
+type Sensor struct {
+	ID             string
+	Provider       SensorProvider
+	SampleInterval time.Duration // zero to use the SamplingConfig interval for the kind of sensor
+}
+
+// NewSensors creates the Sensors described by the configs using the registered factories. IDs must be unique.
+func NewSensors(configs []SensorConfig) ([]Sensor, error) {
+	sensorTypesLock.RLock()
+	defer sensorTypesLock.RUnlock()
+
+	ids := make(map[string]bool)
+	sensors := make([]Sensor, 0, len(configs))
+	for _, config := range configs {
+		if config.ID == "" {
+			return nil, fmt.Errorf("sensor of type %s has no id", config.Type)
+		}
+		if ids[config.ID] {
+			return nil, fmt.Errorf("duplicate sensor id %s", config.ID)
+		}
+		ids[config.ID] = true
+
+		factory, ok := sensorTypes[config.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s for sensor %s, registered types are %s", config.Type, config.ID,
+				strings.Join(sensorTypeNames(), ", "))
+		}
+
+		provider, err := factory(config.ID, config.Settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings for sensor %s: %w", config.ID, err)
+		}
+
+		sensors = append(sensors, Sensor{
+			ID:             config.ID,
+			Provider:       provider,
+			SampleInterval: time.Duration(config.SampleIntervalSecs) * time.Second,
+		})
+	}
+
+	return sensors, nil
+}
+
+// SensorReadings are the readings of a single sensor, only the kinds of readings that the sensor provides are set.
+type SensorReadings struct {
+	AtmosReadings *AtmoshphericReadings `json:"atmospherics,omitempty"`
+	WindReadings  *WindReadings         `json:"wind,omitempty"`
+	RainReadings  *RainReadings         `json:"rain,omitempty"`
+}
+
+// decodeSensorSettings decodes the settings of a sensor into config, a sensor without settings keeps the defaults.
+// Settings which config doesn't have are returned as a *ConfigError.
+func decodeSensorSettings(settings json.RawMessage, config interface{}) error {
+	if len(settings) == 0 {
+		return nil
+	}
+
+	return decodeStrict(settings, config)
+}
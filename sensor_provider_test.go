@@ -0,0 +1,253 @@
+package weatherstn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maciej/bme280"
+)
+
+type fakeSensorProvider struct{}
+
+func (fsp *fakeSensorProvider) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (fsp *fakeSensorProvider) Disconnect() {}
+
+// fakeAtmosProvider returns each of its readings in turn, starting again from the first once they run out.
+type fakeAtmosProvider struct {
+	fakeSensorProvider
+	readings []AtmoshphericReadings
+	n        int
+}
+
+func (fap *fakeAtmosProvider) Readings(ctx context.Context) (*AtmoshphericReadings, error) {
+	if len(fap.readings) == 0 {
+		return &AtmoshphericReadings{}, nil
+	}
+
+	readings := fap.readings[fap.n%len(fap.readings)]
+	fap.n++
+	return &readings, nil
+}
+
+type fakeWindProvider struct {
+	fakeSensorProvider
+	readings []WindReadings
+	n        int
+}
+
+func (fwp *fakeWindProvider) Readings(ctx context.Context) (*WindReadings, error) {
+	if len(fwp.readings) == 0 {
+		return &WindReadings{}, nil
+	}
+
+	readings := fwp.readings[fwp.n%len(fwp.readings)]
+	fwp.n++
+	return &readings, nil
+}
+
+type fakeRainProvider struct {
+	fakeSensorProvider
+	readings []RainReadings
+	n        int
+}
+
+func (frp *fakeRainProvider) Readings(ctx context.Context) (*RainReadings, error) {
+	if len(frp.readings) == 0 {
+		return &RainReadings{}, nil
+	}
+
+	readings := frp.readings[frp.n%len(frp.readings)]
+	frp.n++
+	return &readings, nil
+}
+
+type fakeAtmosProviderConfig struct {
+	Temperature float64 `json:"temperature"`
+}
+
+func init() {
+	RegisterSensorType("fake-atmos", func(id string, settings json.RawMessage) (SensorProvider, error) {
+		var config fakeAtmosProviderConfig
+		if err := decodeSensorSettings(settings, &config); err != nil {
+			return nil, err
+		}
+
+		return &fakeAtmosProvider{readings: []AtmoshphericReadings{{Temperature: config.Temperature}}}, nil
+	})
+}
+
+func TestNewSensors(t *testing.T) {
+	sensors, err := NewSensors([]SensorConfig{
+		{Type: "fake-atmos", ID: "screen", Settings: json.RawMessage(`{"temperature": 11.5}`)},
+		{Type: "fake-atmos", ID: "ground", SampleIntervalSecs: 5},
+		{Type: SensorTypeBME280, ID: "loft", Settings: json.RawMessage(`{"i2cAddr": 119}`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating sensors: %v", err)
+	}
+
+	if len(sensors) != 3 || sensors[0].ID != "screen" || sensors[1].ID != "ground" || sensors[2].ID != "loft" {
+		t.Fatalf("expected sensors in config order but got %#v", sensors)
+	}
+
+	provider, ok := sensors[0].Provider.(*fakeAtmosProvider)
+	if !ok {
+		t.Fatalf("expected a fakeAtmosProvider but got %T", sensors[0].Provider)
+	}
+	if readings, err := provider.Readings(context.Background()); err != nil || readings.Temperature != 11.5 {
+		t.Fatalf("expected settings to be passed to the factory but got %#v (%v)", readings, err)
+	}
+
+	if sensors[1].SampleInterval.Seconds() != 5 {
+		t.Fatalf("expected a 5 second sample interval but was %s", sensors[1].SampleInterval)
+	}
+
+	bme, ok := sensors[2].Provider.(*BME280SensorProvider)
+	if !ok || bme.i2cAddr != 119 {
+		t.Fatalf("expected a BME280SensorProvider at address 119 but got %#v", sensors[2].Provider)
+	}
+}
+
+func TestNewSensors_Invalid(t *testing.T) {
+	tests := map[string][]SensorConfig{
+		"unknown type":   {{Type: "sht31", ID: "screen"}},
+		"missing id":     {{Type: "fake-atmos"}},
+		"duplicate id":   {{Type: "fake-atmos", ID: "screen"}, {Type: "fake-atmos", ID: "screen"}},
+		"bad settings":   {{Type: "fake-atmos", ID: "screen", Settings: json.RawMessage(`{"temperature": "warm"}`)}},
+		"wrong settings": {{Type: SensorTypeSEN08942Rain, ID: "rain", Settings: json.RawMessage(`[5]`)}},
+	}
+
+	for name, configs := range tests {
+		if _, err := NewSensors(configs); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestProducerConfig_SensorConfigsLegacy(t *testing.T) {
+	config := ProducerConfig{
+		Atmos: BME280SensorProviderConfig{I2cAddr: 118, I2cBusDevice: "/dev/i2c-1"},
+		Rain:  SEN08942RainSensorProviderConfig{PinNumber: 6},
+	}
+
+	configs, err := config.SensorConfigs()
+	if err != nil {
+		t.Fatalf("unexpected error reading sensor configs: %v", err)
+	}
+
+	sensors, err := NewSensors(configs)
+	if err != nil {
+		t.Fatalf("unexpected error creating sensors: %v", err)
+	}
+
+	if len(sensors) != 3 {
+		t.Fatalf("expected a sensor of each kind but got %d", len(sensors))
+	}
+	if _, ok := sensors[0].Provider.(AtmosphericSensorProvider); !ok || sensors[0].ID != "atmos" {
+		t.Fatalf("expected an atmos sensor but got %#v", sensors[0])
+	}
+	if _, ok := sensors[1].Provider.(WindSensorProvider); !ok || sensors[1].ID != "wind" {
+		t.Fatalf("expected a wind sensor but got %#v", sensors[1])
+	}
+	if rain, ok := sensors[2].Provider.(*SEN08942RainSensorProvider); !ok || rain.pinNumber != 6 {
+		t.Fatalf("expected a rain sensor on pin 6 but got %#v", sensors[2])
+	}
+
+	config.Sensors = []SensorConfig{}
+	if configs, err := config.SensorConfigs(); err != nil || len(configs) != 0 {
+		t.Fatalf("expected an empty sensor list to configure no sensors but got %d (%v)", len(configs), err)
+	}
+}
+
+// blockingBME280Driver reads only once release is closed, recording whether it was closed.
+type blockingBME280Driver struct {
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (bd *blockingBME280Driver) Read() (bme280.Response, error) {
+	<-bd.release
+	return bme280.Response{Temperature: 12.5}, nil
+}
+
+func (bd *blockingBME280Driver) Close() error {
+	close(bd.closed)
+	return nil
+}
+
+func TestBME280SensorProvider_HungRead(t *testing.T) {
+	driver := &blockingBME280Driver{release: make(chan struct{}), closed: make(chan struct{})}
+	bme := NewBME280SensorProvider(BME280SensorProviderConfig{})
+	bme.driver = driver
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := bme.Readings(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the read to time out but got %v", err)
+	}
+
+	if _, err := bme.Readings(context.Background()); !errors.Is(err, ErrReadInProgress) {
+		t.Fatalf("expected a read in progress error without waiting but got %v", err)
+	}
+	if err := bme.Connect(context.Background()); !errors.Is(err, ErrReadInProgress) {
+		t.Fatalf("expected reconnecting to fail while the read is in progress but got %v", err)
+	}
+
+	bme.Disconnect()
+	select {
+	case <-driver.closed:
+		t.Fatal("expected the driver not to be closed while it is being read")
+	default:
+	}
+
+	close(driver.release)
+	select {
+	case <-driver.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the driver to be closed once the read returned")
+	}
+}
+
+func TestBME280SensorProvider_HungConnect(t *testing.T) {
+	driver := &blockingBME280Driver{release: make(chan struct{}), closed: make(chan struct{})}
+	opened := 0
+	bme := NewBME280SensorProvider(BME280SensorProviderConfig{})
+	bme.open = func() (bme280Driver, error) {
+		opened++
+		return driver, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := bme.Connect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the check read to time out but got %v", err)
+	}
+	select {
+	case <-driver.closed:
+		t.Fatal("expected the driver not to be closed while it is being read")
+	default:
+	}
+
+	if err := bme.Connect(context.Background()); !errors.Is(err, ErrReadInProgress) {
+		t.Fatalf("expected reconnecting to fail while the check read is in progress but got %v", err)
+	}
+	if opened != 1 {
+		t.Fatalf("expected the device not to be reopened while it is being read but it was opened %d times", opened)
+	}
+
+	close(driver.release)
+	select {
+	case <-driver.closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the driver to be closed once the check read returned")
+	}
+	if _, err := bme.Readings(context.Background()); err == nil || errors.Is(err, ErrReadInProgress) {
+		t.Fatalf("expected the abandoned driver not to be connected but got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package weatherstn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSpoolMaxRows is the number of rows held by a Spool when no limit is configured, a day of observations at the
+// default 30 second interval.
+const DefaultSpoolMaxRows = 2880
+
+// SpoolConfig is the set of configuration properties for setting up the Spool.
+type SpoolConfig struct {
+	MaxRows int    `json:"maxRows"` // defaults to DefaultSpoolMaxRows
+	Path    string `json:"path"`    // optional file that spooled rows are persisted to so that they survive restarts
+}
+
+// SpoolStats are counters describing the activity of a Spool.
+type SpoolStats struct {
+	Spooled  int    `json:"spooled"`  // rows currently waiting to be written
+	Replayed uint64 `json:"replayed"` // rows written to the DataStore from the spool
+	Dropped  uint64 `json:"dropped"`  // rows discarded because the spool was full
+}
+
+// Spool holds observations that could not be written to the DataStore, e.g. because the database is locked, full or
+// corrupt, so that they can be replayed in order once writes succeed again. When full the oldest rows are dropped.
+type Spool struct {
+	rows    []WeatherDataRow
+	maxRows int
+	path    string
+	stats   SpoolStats
+	lock    sync.Mutex
+}
+
+// NewSpool creates a new Spool, loading any rows previously persisted to the configured path.
+func NewSpool(config SpoolConfig) (*Spool, error) {
+	maxRows := config.MaxRows
+	if maxRows <= 0 {
+		maxRows = DefaultSpoolMaxRows
+	}
+
+	spool := &Spool{
+		maxRows: maxRows,
+		path:    config.Path,
+	}
+
+	if err := spool.load(); err != nil {
+		return nil, err
+	}
+
+	return spool, nil
+}
+
+// Push adds the row to the end of the spool, dropping the oldest row if the spool is full.
+func (s *Spool) Push(row WeatherDataRow) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.rows = append(s.rows, row)
+	if len(s.rows) > s.maxRows {
+		dropped := len(s.rows) - s.maxRows
+		s.rows = s.rows[dropped:]
+		s.stats.Dropped += uint64(dropped)
+
+		log.WithField("component", "Spool").
+			WithField("dropped", s.stats.Dropped).
+			Warn("spool full, dropped oldest observation")
+	}
+
+	s.persist()
+}
+
+// Len returns the number of rows waiting in the spool.
+func (s *Spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.rows)
+}
+
+// Stats returns the current SpoolStats.
+func (s *Spool) Stats() SpoolStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.stats
+	stats.Spooled = len(s.rows)
+
+	return stats
+}
+
+// Replay writes the spooled rows to the store in order, stopping at the first failure so that ordering is kept.
+// Rows which the store already holds are discarded.
+func (s *Spool) Replay(ctx context.Context, store DataStore) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.rows) == 0 {
+		return nil
+	}
+
+	var err error
+	written := 0
+	for _, row := range s.rows {
+		err = store.Write(ctx, row)
+		if err != nil && !errors.Is(err, ErrDuplicateObservation) {
+			break
+		}
+		err = nil
+		written++
+	}
+
+	s.rows = s.rows[written:]
+	s.stats.Replayed += uint64(written)
+	s.persist()
+
+	return err
+}
+
+// persist writes the spool to disk, if configured. Must be called with the lock held.
+func (s *Spool) persist() {
+	if s.path == "" {
+		return
+	}
+
+	if err := s.writeFile(); err != nil {
+		log.WithError(err).
+			WithField("component", "Spool").
+			WithField("path", s.path).
+			Error("failed to persist spool")
+	}
+}
+
+// writeFile replaces the spool file, writing to a temporary file first so that a crash never leaves a partial spool.
+func (s *Spool) writeFile() error {
+	if len(s.rows) == 0 {
+		err := os.Remove(s.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	tmp, err := os.Create(filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp"))
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, row := range s.rows {
+		if err = enc.Encode(row); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *Spool) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.WithError(err).WithField("component", "Spool").Error("failed to close spool file")
+		}
+	}()
+
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var row WeatherDataRow
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		s.rows = append(s.rows, row)
+	}
+
+	if len(s.rows) > s.maxRows {
+		s.stats.Dropped += uint64(len(s.rows) - s.maxRows)
+		s.rows = s.rows[len(s.rows)-s.maxRows:]
+	}
+
+	return nil
+}
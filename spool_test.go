@@ -0,0 +1,133 @@
+package weatherstn
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// unavailableDataStore wraps a DataStore, failing all writes while unavailable is set.
+type unavailableDataStore struct {
+	DataStore
+	unavailable bool
+	lock        sync.Mutex
+}
+
+func (uds *unavailableDataStore) setUnavailable(unavailable bool) {
+	uds.lock.Lock()
+	uds.unavailable = unavailable
+	uds.lock.Unlock()
+}
+
+func (uds *unavailableDataStore) Write(ctx context.Context, row WeatherDataRow) error {
+	uds.lock.Lock()
+	defer uds.lock.Unlock()
+
+	if uds.unavailable {
+		return errors.New("database is locked")
+	}
+
+	return uds.DataStore.Write(context.Background(), row)
+}
+
+func TestSpool_ReplayInOrder(t *testing.T) {
+	dataset := loadConformanceDataset(t)
+	store := &unavailableDataStore{DataStore: NewMemoryDataStore(), unavailable: true}
+
+	spool, err := NewSpool(SpoolConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating spool: %v", err)
+	}
+
+	for _, row := range dataset[:5] {
+		spool.Push(row)
+	}
+
+	if err := spool.Replay(context.Background(), store); err == nil {
+		t.Fatalf("expected replay to fail while the store is unavailable")
+	}
+	if spool.Len() != 5 {
+		t.Fatalf("expected 5 spooled rows but got %d", spool.Len())
+	}
+
+	// A row that made it into the store some other way is not a reason to stop replaying.
+	store.setUnavailable(false)
+	if err := store.Write(context.Background(), dataset[2]); err != nil {
+		t.Fatalf("failed to write to data store: %v", err)
+	}
+
+	if err := spool.Replay(context.Background(), store); err != nil {
+		t.Fatalf("failed to replay spool: %v", err)
+	}
+
+	stats := spool.Stats()
+	if stats.Spooled != 0 || stats.Replayed != 5 || stats.Dropped != 0 {
+		t.Fatalf("unexpected stats %#v", stats)
+	}
+
+	assertUnpublished(t, store, dataset[:5])
+}
+
+func TestSpool_DropsOldestWhenFull(t *testing.T) {
+	dataset := loadConformanceDataset(t)
+
+	spool, err := NewSpool(SpoolConfig{MaxRows: 3})
+	if err != nil {
+		t.Fatalf("unexpected error creating spool: %v", err)
+	}
+
+	for _, row := range dataset[:5] {
+		spool.Push(row)
+	}
+
+	stats := spool.Stats()
+	if stats.Spooled != 3 || stats.Dropped != 2 {
+		t.Fatalf("unexpected stats %#v", stats)
+	}
+
+	store := NewMemoryDataStore()
+	if err := spool.Replay(context.Background(), store); err != nil {
+		t.Fatalf("failed to replay spool: %v", err)
+	}
+
+	assertUnpublished(t, store, dataset[2:5])
+}
+
+func TestSpool_Persists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dataset := loadConformanceDataset(t)
+	config := SpoolConfig{Path: filepath.Join(dir, "spool.jsonl")}
+
+	spool, err := NewSpool(config)
+	if err != nil {
+		t.Fatalf("unexpected error creating spool: %v", err)
+	}
+	for _, row := range dataset[:4] {
+		spool.Push(row)
+	}
+
+	reloaded, err := NewSpool(config)
+	if err != nil {
+		t.Fatalf("unexpected error reloading spool: %v", err)
+	}
+
+	store := NewMemoryDataStore()
+	if err := reloaded.Replay(context.Background(), store); err != nil {
+		t.Fatalf("failed to replay spool: %v", err)
+	}
+
+	assertUnpublished(t, store, dataset[:4])
+
+	if _, err := os.Stat(config.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected spool file to be removed once empty but got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package weatherstn
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Version is the version of the weather station software, sent upstream with every payload. It is set when building
+// a release with -ldflags "-X github.com/chvck/weatherstn.Version=v1.2.3".
+var Version = "dev"
+
+// StationConfig describes the station, it is sent upstream with every payload so that observations from several
+// stations can be told apart.
+type StationConfig struct {
+	ID            string             `json:"id"`                      // unique to the station, defaults to the hostname
+	Name          string             `json:"name,omitempty"`          // human readable name
+	Latitude      *float64           `json:"latitude,omitempty"`      // decimal degrees, north is positive
+	Longitude     *float64           `json:"longitude,omitempty"`     // decimal degrees, east is positive
+	Altitude      *float64           `json:"altitude,omitempty"`      // metres above sea level
+	SensorHeights map[string]float64 `json:"sensorHeights,omitempty"` // metres above ground, keyed by sensor ID
+	Timezone      string             `json:"timezone,omitempty"`      // IANA name e.g. Europe/London, defaults to UTC
+}
+
+// StationInfo identifies the station which sent a PublishEnvelope.
+type StationInfo struct {
+	StationConfig
+	Software string `json:"software"` // Version of the software the station runs
+}
+
+// Info returns the StationInfo sent upstream for the station, with the ID and timezone defaults filled in.
+func (sc StationConfig) Info() (StationInfo, error) {
+	if sc.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return StationInfo{}, fmt.Errorf("station id isn't set and the hostname can't be read: %v", err)
+		}
+		sc.ID = hostname
+	}
+	if sc.Timezone == "" {
+		sc.Timezone = time.UTC.String()
+	}
+
+	return StationInfo{StationConfig: sc, Software: Version}, nil
+}
+
+// Validate returns the problems with the station's location and timezone, and the heights of sensors which aren't in
+// sensorIDs.
+func (sc StationConfig) Validate(sensorIDs map[string]bool) error {
+	var problems configProblems
+	if (sc.Latitude == nil) != (sc.Longitude == nil) {
+		problems.add("latitude and longitude must be set together")
+	}
+	if sc.Latitude != nil && (*sc.Latitude < -90 || *sc.Latitude > 90) {
+		problems.add("latitude must be between -90 and 90 but was %f", *sc.Latitude)
+	}
+	if sc.Longitude != nil && (*sc.Longitude < -180 || *sc.Longitude > 180) {
+		problems.add("longitude must be between -180 and 180 but was %f", *sc.Longitude)
+	}
+	if _, err := time.LoadLocation(sc.Timezone); err != nil {
+		problems.add("timezone: %v", err)
+	}
+	for id, height := range sc.SensorHeights {
+		if !sensorIDs[id] {
+			problems.add("sensorHeights.%s: unknown sensor", id)
+		} else if height < 0 {
+			problems.add("sensorHeights.%s must not be negative but was %f", id, height)
+		}
+	}
+
+	return problems.err()
+}
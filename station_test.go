@@ -0,0 +1,29 @@
+package weatherstn
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStationConfig_Info(t *testing.T) {
+	info, err := StationConfig{ID: "allotment", Timezone: "Europe/London"}.Info()
+	if err != nil {
+		t.Fatalf("unexpected error getting station info: %v", err)
+	}
+	if info.ID != "allotment" || info.Timezone != "Europe/London" || info.Software != Version {
+		t.Fatalf("expected configured station info but got %#v", info)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatalf("failed to read hostname: %v", err)
+	}
+
+	info, err = StationConfig{}.Info()
+	if err != nil {
+		t.Fatalf("unexpected error getting station info: %v", err)
+	}
+	if info.ID != hostname || info.Timezone != "UTC" {
+		t.Fatalf("expected station to default to the hostname in UTC but got %#v", info)
+	}
+}
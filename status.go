@@ -0,0 +1,73 @@
+package weatherstn
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StatusConfig is the set of configuration properties for the status API.
+type StatusConfig struct {
+	Listen string `json:"listen"` // address to serve the status API on, e.g. 127.0.0.1:8080, disabled when empty
+	Units  Units  `json:"units"`  // units of the last readings, defaults to metric
+}
+
+// Status is the response of the status API.
+type Status struct {
+	State   SensorState    `json:"state"` // the worst state of any sensor
+	Sensors []SensorStatus `json:"sensors"`
+	Spool   SpoolStats     `json:"spool"`
+	Units   Units          `json:"units"` // units of the sensors' last readings
+}
+
+// NewStatusHandler returns an http.Handler which serves the health of the producer's sensors and its spool as JSON.
+// Readings are given in units unless the request asks for others with a units query parameter, in the form accepted
+// by ParseUnits, e.g. /status?units=imperial.
+func NewStatusHandler(producer *SensorProducer, units Units) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		statusUnits := units
+		if spec := r.URL.Query().Get("units"); spec != "" {
+			var err error
+			if statusUnits, err = ParseUnits(spec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		statusUnits, err := statusUnits.normalize()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		status := Status{
+			State:   SensorStateOK,
+			Sensors: producer.SensorStatus(),
+			Spool:   producer.SpoolStats(),
+			Units:   statusUnits,
+		}
+		for i, sensor := range status.Sensors {
+			if sensor.LastReading != nil {
+				converted := statusUnits.ConvertReadings(*sensor.LastReading)
+				status.Sensors[i].LastReading = &converted
+			}
+			if sensor.State == SensorStateFailed ||
+				(sensor.State == SensorStateDegraded && status.State == SensorStateOK) {
+				status.State = sensor.State
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			log.WithError(err).
+				WithField("component", "StatusHandler").
+				Error("failed to write status")
+		}
+	})
+}
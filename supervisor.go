@@ -0,0 +1,249 @@
+package weatherstn
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxReadFailures is the number of consecutive failed samples after which a sensor is reconnected.
+	DefaultMaxReadFailures = 3
+
+	// DefaultMinBackoffSecs is the delay before the first reconnection attempt after a sensor fails.
+	DefaultMinBackoffSecs = 1
+
+	// DefaultMaxBackoffSecs is the longest delay between reconnection attempts, the delay doubles after each failure.
+	DefaultMaxBackoffSecs = 300
+
+	// DefaultReadTimeoutSecs is how long connecting to or reading a sensor may take before it is treated as failed.
+	DefaultReadTimeoutSecs = 5
+)
+
+// SupervisorConfig is the set of configuration properties for supervising sensors.
+type SupervisorConfig struct {
+	MaxReadFailures int `json:"maxReadFailures"`
+	MinBackoffSecs  int `json:"minBackoffSecs"`
+	MaxBackoffSecs  int `json:"maxBackoffSecs"`
+	ReadTimeoutSecs int `json:"readTimeoutSecs"`
+}
+
+// SensorState is the health of a sensor.
+type SensorState string
+
+const (
+	// SensorStateOK means the sensor is connected and its last sample succeeded.
+	SensorStateOK SensorState = "ok"
+
+	// SensorStateDegraded means the sensor is connected but its recent samples have failed.
+	SensorStateDegraded SensorState = "degraded"
+
+	// SensorStateFailed means the sensor is not connected, either because it could not be connected or because too
+	// many samples failed. Reconnection is retried with backoff.
+	SensorStateFailed SensorState = "failed"
+)
+
+// SensorStatus is the health of a sensor as reported by the status API.
+type SensorStatus struct {
+	ID                  string          `json:"id"`
+	State               SensorState     `json:"state"`
+	ConsecutiveFailures int             `json:"consecutiveFailures"`
+	LastError           string          `json:"lastError,omitempty"`
+	LastErrorTime       *time.Time      `json:"lastErrorTime,omitempty"`
+	LastReadingTime     *time.Time      `json:"lastReadingTime,omitempty"`
+	LastReading         *SensorReadings `json:"lastReading,omitempty"`
+	NextConnectTime     *time.Time      `json:"nextConnectTime,omitempty"`
+}
+
+// sensorSupervisor tracks the health of a sensor, connecting it when needed and reconnecting it with backoff after
+// repeated failures.
+type sensorSupervisor struct {
+	id       string
+	provider SensorProvider
+
+	maxFailures int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	readTimeout time.Duration
+	now         func() time.Time
+
+	connected   bool
+	status      SensorStatus
+	backoff     time.Duration
+	nextConnect time.Time
+	lock        sync.Mutex
+}
+
+func newSensorSupervisor(sensor Sensor, config SupervisorConfig) *sensorSupervisor {
+	ss := &sensorSupervisor{
+		id:          sensor.ID,
+		provider:    sensor.Provider,
+		maxFailures: config.MaxReadFailures,
+		minBackoff:  time.Duration(config.MinBackoffSecs) * time.Second,
+		maxBackoff:  time.Duration(config.MaxBackoffSecs) * time.Second,
+		readTimeout: time.Duration(config.ReadTimeoutSecs) * time.Second,
+		now:         time.Now,
+		status:      SensorStatus{ID: sensor.ID, State: SensorStateFailed, LastError: "not connected"},
+	}
+
+	if ss.maxFailures <= 0 {
+		ss.maxFailures = DefaultMaxReadFailures
+	}
+	if ss.minBackoff <= 0 {
+		ss.minBackoff = DefaultMinBackoffSecs * time.Second
+	}
+	if ss.maxBackoff <= 0 {
+		ss.maxBackoff = DefaultMaxBackoffSecs * time.Second
+	}
+	if ss.readTimeout <= 0 {
+		ss.readTimeout = DefaultReadTimeoutSecs * time.Second
+	}
+	if ss.maxBackoff < ss.minBackoff {
+		ss.maxBackoff = ss.minBackoff
+	}
+
+	return ss
+}
+
+// readContext returns a context for a single connection attempt or read of the sensor.
+func (ss *sensorSupervisor) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, ss.readTimeout)
+}
+
+// ready returns whether the sensor is connected and can be sampled, first attempting to connect it if it isn't and
+// its backoff has elapsed.
+func (ss *sensorSupervisor) ready(ctx context.Context) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.connected {
+		return true
+	}
+
+	now := ss.now()
+	if now.Before(ss.nextConnect) {
+		return false
+	}
+
+	connectCtx, cancel := ss.readContext(ctx)
+	defer cancel()
+	if err := ss.provider.Connect(connectCtx); err != nil {
+		ss.recordError(err, now)
+		ss.scheduleConnect(now)
+		log.WithError(err).
+			WithField("component", "SensorSupervisor").
+			WithField("sensor", ss.id).
+			WithField("retry", ss.nextConnect.Format(time.RFC3339)).
+			Error("failed to connect to sensor")
+		return false
+	}
+
+	ss.connected = true
+	ss.backoff = 0
+	ss.status.ConsecutiveFailures = 0
+	ss.status.NextConnectTime = nil
+	ss.setState(SensorStateOK)
+
+	return true
+}
+
+// succeeded records a successful sample of the sensor.
+func (ss *sensorSupervisor) succeeded(readings SensorReadings) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	now := ss.now()
+	ss.status.ConsecutiveFailures = 0
+	ss.status.LastReadingTime = &now
+	ss.status.LastReading = &readings
+	ss.setState(SensorStateOK)
+}
+
+// failed records a failed sample of the sensor, disconnecting it once there have been too many failures in a row so
+// that it is reconnected.
+func (ss *sensorSupervisor) failed(err error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	now := ss.now()
+	ss.recordError(err, now)
+	if ss.status.ConsecutiveFailures < ss.maxFailures {
+		ss.setState(SensorStateDegraded)
+		return
+	}
+
+	ss.provider.Disconnect()
+	ss.connected = false
+	ss.scheduleConnect(now)
+	log.WithError(err).
+		WithField("component", "SensorSupervisor").
+		WithField("sensor", ss.id).
+		WithField("failures", ss.status.ConsecutiveFailures).
+		Error("too many failed readings, reconnecting sensor")
+}
+
+// disconnect disconnects the sensor if it is connected.
+func (ss *sensorSupervisor) disconnect() {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if !ss.connected {
+		return
+	}
+
+	ss.provider.Disconnect()
+	ss.connected = false
+	ss.status.LastError = "not connected"
+	ss.setState(SensorStateFailed)
+}
+
+// Status returns the current health of the sensor.
+func (ss *sensorSupervisor) Status() SensorStatus {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	return ss.status
+}
+
+func (ss *sensorSupervisor) recordError(err error, now time.Time) {
+	ss.status.ConsecutiveFailures++
+	ss.status.LastError = err.Error()
+	ss.status.LastErrorTime = &now
+}
+
+// scheduleConnect marks the sensor as failed and schedules the next connection attempt, doubling the backoff each time.
+func (ss *sensorSupervisor) scheduleConnect(now time.Time) {
+	if ss.backoff == 0 {
+		ss.backoff = ss.minBackoff
+	} else {
+		ss.backoff *= 2
+		if ss.backoff > ss.maxBackoff {
+			ss.backoff = ss.maxBackoff
+		}
+	}
+
+	ss.nextConnect = now.Add(ss.backoff)
+	next := ss.nextConnect
+	ss.status.NextConnectTime = &next
+	ss.setState(SensorStateFailed)
+}
+
+func (ss *sensorSupervisor) setState(state SensorState) {
+	if ss.status.State == state {
+		return
+	}
+
+	entry := log.WithField("component", "SensorSupervisor").
+		WithField("sensor", ss.id).
+		WithField("from", ss.status.State).
+		WithField("to", state)
+	if state == SensorStateOK {
+		entry.Info("sensor health changed")
+	} else {
+		entry.WithField("error", ss.status.LastError).Warn("sensor health changed")
+	}
+
+	ss.status.State = state
+}
@@ -0,0 +1,245 @@
+package weatherstn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flakyAtmosProvider fails to connect and read while its errors are set.
+type flakyAtmosProvider struct {
+	connectErr   error
+	readErr      error
+	connects     int
+	disconnects  int
+	temperature  float64
+	readingCalls int
+}
+
+func (fap *flakyAtmosProvider) Connect(ctx context.Context) error {
+	fap.connects++
+	return fap.connectErr
+}
+
+func (fap *flakyAtmosProvider) Disconnect() {
+	fap.disconnects++
+}
+
+func (fap *flakyAtmosProvider) Readings(ctx context.Context) (*AtmoshphericReadings, error) {
+	fap.readingCalls++
+	if fap.readErr != nil {
+		return nil, fap.readErr
+	}
+
+	return &AtmoshphericReadings{Temperature: fap.temperature}, nil
+}
+
+func TestSensorSupervisor_Lifecycle(t *testing.T) {
+	provider := &flakyAtmosProvider{connectErr: errors.New("no such device"), temperature: 9.5}
+	supervisor := newSensorSupervisor(Sensor{ID: "screen", Provider: provider}, SupervisorConfig{
+		MaxReadFailures: 2,
+		MinBackoffSecs:  1,
+		MaxBackoffSecs:  3,
+	})
+	now := time.Date(2020, 1, 30, 12, 0, 0, 0, time.UTC)
+	supervisor.now = func() time.Time { return now }
+
+	assertState := func(expected SensorState) {
+		t.Helper()
+		if state := supervisor.Status().State; state != expected {
+			t.Fatalf("expected sensor to be %s but was %s", expected, state)
+		}
+	}
+
+	// Connection attempts back off 1s, 2s and then are capped at 3s.
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		if supervisor.ready(context.Background()) {
+			t.Fatalf("expected sensor not to be ready while it fails to connect")
+		}
+		assertState(SensorStateFailed)
+
+		connects := provider.connects
+		now = now.Add(backoff - time.Millisecond)
+		if supervisor.ready(context.Background()) || provider.connects != connects {
+			t.Fatalf("expected no connection attempt before the %s backoff elapsed", backoff)
+		}
+		now = now.Add(time.Millisecond)
+	}
+
+	provider.connectErr = nil
+	if !supervisor.ready(context.Background()) {
+		t.Fatalf("expected sensor to be ready once connected")
+	}
+	assertState(SensorStateOK)
+
+	sensor := &producerSensor{
+		Sensor:     Sensor{ID: "screen", Provider: provider},
+		atmos:      provider,
+		samples:    newSampleBuffer(),
+		supervisor: supervisor,
+	}
+	sensor.sample(context.Background())
+	status := supervisor.Status()
+	if status.LastReading == nil || status.LastReading.AtmosReadings.Temperature != 9.5 || status.LastReadingTime == nil {
+		t.Fatalf("expected last good reading to be recorded but got %#v", status)
+	}
+
+	// The first failure degrades the sensor and the second disconnects it.
+	provider.readErr = errors.New("i2c read failed")
+	sensor.sample(context.Background())
+	assertState(SensorStateDegraded)
+	sensor.sample(context.Background())
+	assertState(SensorStateFailed)
+	if provider.disconnects != 1 {
+		t.Fatalf("expected sensor to be disconnected after repeated failures but had %d disconnects",
+			provider.disconnects)
+	}
+
+	status = supervisor.Status()
+	if status.LastError != "i2c read failed" || status.LastReading.AtmosReadings.Temperature != 9.5 {
+		t.Fatalf("expected last error and last good reading to be kept but got %#v", status)
+	}
+
+	// Sampling while waiting to reconnect doesn't touch the sensor, after the backoff it is reconnected.
+	reads := provider.readingCalls
+	sensor.sample(context.Background())
+	if provider.readingCalls != reads {
+		t.Fatalf("expected failed sensor not to be read")
+	}
+
+	provider.readErr = nil
+	now = now.Add(time.Second)
+	sensor.sample(context.Background())
+	assertState(SensorStateOK)
+	if samples := sensor.samples.drain()[metricTemperature]; len(samples) != 2 {
+		t.Fatalf("expected 2 successful samples but got %d", len(samples))
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	spool, err := NewSpool(SpoolConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating spool: %v", err)
+	}
+
+	sensors := []Sensor{
+		{ID: "screen", Provider: &flakyAtmosProvider{temperature: 11}},
+		{ID: "ground", Provider: &flakyAtmosProvider{readErr: errors.New("i2c read failed")}},
+	}
+	producer, err := NewSensorProducer(sensors, NewMemoryDataStore(), spool, SamplingConfig{}, SupervisorConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating producer: %v", err)
+	}
+	for _, ps := range producer.sensors {
+		ps.sample(context.Background())
+	}
+
+	handler := NewStatusHandler(producer, MetricUnits())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", recorder.Code)
+	}
+
+	var status Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("unexpected error decoding status: %v", err)
+	}
+
+	if status.State != SensorStateDegraded || len(status.Sensors) != 2 {
+		t.Fatalf("expected degraded status for 2 sensors but got %#v", status)
+	}
+	if status.Sensors[0].State != SensorStateOK || status.Sensors[1].State != SensorStateDegraded ||
+		status.Sensors[1].LastError != "i2c read failed" {
+		t.Fatalf("expected screen to be ok and ground degraded but got %#v", status.Sensors)
+	}
+	if status.Units != MetricUnits() || status.Sensors[0].LastReading.AtmosReadings.Temperature != 11 {
+		t.Fatalf("expected readings in metric but got %#v in %s", status.Sensors[0].LastReading, status.Units)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status?units=imperial", nil))
+	status = Status{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("unexpected error decoding status: %v", err)
+	}
+	if status.Units != ImperialUnits() || status.Sensors[0].LastReading.AtmosReadings.Temperature != 51.8 {
+		t.Fatalf("expected readings in imperial but got %#v in %s", status.Sensors[0].LastReading, status.Units)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status?units=cubits", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for unknown units but got %d", recorder.Code)
+	}
+}
+
+// hungAtmosProvider never returns from Readings until released.
+type hungAtmosProvider struct {
+	fakeSensorProvider
+	release chan struct{}
+}
+
+func (hap *hungAtmosProvider) Readings(ctx context.Context) (*AtmoshphericReadings, error) {
+	var readings *AtmoshphericReadings
+	err := callWithContext(ctx, func() error {
+		<-hap.release
+		readings = &AtmoshphericReadings{}
+		return nil
+	})
+
+	return readings, err
+}
+
+func TestProducerSensor_SampleTimesOut(t *testing.T) {
+	provider := &hungAtmosProvider{release: make(chan struct{})}
+	defer close(provider.release)
+
+	sensor := Sensor{ID: "screen", Provider: provider}
+	supervisor := newSensorSupervisor(sensor, SupervisorConfig{})
+	supervisor.readTimeout = 10 * time.Millisecond
+	ps := &producerSensor{Sensor: sensor, atmos: provider, samples: newSampleBuffer(), supervisor: supervisor}
+
+	start := time.Now()
+	ps.sample(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected sample to give up after the read timeout but took %s", elapsed)
+	}
+
+	status := supervisor.Status()
+	if status.State != SensorStateDegraded || status.LastError != context.DeadlineExceeded.Error() {
+		t.Fatalf("expected sensor to be degraded by the timeout but got %#v", status)
+	}
+}
+
+func TestSensorProducer_RunReturnsWhenDone(t *testing.T) {
+	provider := &flakyAtmosProvider{}
+	producer, err := NewSensorProducer([]Sensor{{ID: "screen", Provider: provider}}, NewMemoryDataStore(), nil,
+		SamplingConfig{}, SupervisorConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error creating producer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		producer.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected Run to return once the context was done")
+	}
+
+	if provider.connects != 1 || provider.disconnects != 1 {
+		t.Fatalf("expected sensor to be connected and disconnected once but got %d and %d", provider.connects,
+			provider.disconnects)
+	}
+}
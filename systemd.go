@@ -0,0 +1,79 @@
+package weatherstn
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SystemdReady tells systemd that the service has finished starting up.
+	SystemdReady = "READY=1"
+
+	// SystemdReloading tells systemd that the service is reloading its config, SystemdReady is sent once it is done.
+	SystemdReloading = "RELOADING=1"
+
+	// SystemdStopping tells systemd that the service is shutting down.
+	SystemdStopping = "STOPPING=1"
+
+	// SystemdWatchdog tells systemd that the service is still healthy, resetting its watchdog timer.
+	SystemdWatchdog = "WATCHDOG=1"
+)
+
+// SystemdNotify sends the state to the service manager over the socket named by $NOTIFY_SOCKET, as done by
+// sd_notify(3). It returns false without error when not run by systemd, or by a unit without Type=notify.
+func SystemdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.WithError(err).
+				WithField("component", "Systemd").
+				Error("failed to close notify socket")
+		}
+	}()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SystemdWatchdogInterval returns the interval within which systemd expects SystemdWatchdog to be sent before it
+// treats the service as hung and restarts it, as done by sd_watchdog_enabled(3). It returns zero when the watchdog
+// isn't enabled for this process.
+func SystemdWatchdogInterval() (time.Duration, error) {
+	usecs := os.Getenv("WATCHDOG_USEC")
+	if usecs == "" {
+		return 0, nil
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		watchdogPID, err := strconv.Atoi(pid)
+		if err != nil {
+			return 0, fmt.Errorf("invalid WATCHDOG_PID %s: %v", pid, err)
+		}
+		if watchdogPID != os.Getpid() {
+			return 0, nil
+		}
+	}
+
+	interval, err := strconv.ParseInt(usecs, 10, 64)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %s", usecs)
+	}
+
+	return time.Duration(interval) * time.Microsecond, nil
+}
@@ -0,0 +1,111 @@
+package weatherstn
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// setenv sets the environment variable for the duration of a test, returning a function restoring its old value.
+func setenv(t *testing.T, key, value string) func() {
+	old, had := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatalf("failed to set %s: %v", key, err)
+	}
+
+	return func() {
+		var err error
+		if had {
+			err = os.Setenv(key, old)
+		} else {
+			err = os.Unsetenv(key)
+		}
+		if err != nil {
+			t.Fatalf("failed to restore %s: %v", key, err)
+		}
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weatherstn-systemd")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen on notify socket: %v", err)
+	}
+	defer conn.Close()
+
+	restore := setenv(t, "NOTIFY_SOCKET", socket)
+	defer restore()
+
+	sent, err := SystemdNotify(SystemdReady)
+	if err != nil || !sent {
+		t.Fatalf("expected notification to be sent but got %t, %v", sent, err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set read deadline: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("failed to read notification: %v", err)
+	}
+	if state := string(buf[:n]); state != SystemdReady {
+		t.Fatalf("expected notification to be %s but was %s", SystemdReady, state)
+	}
+}
+
+func TestSystemdNotify_NotRunBySystemd(t *testing.T) {
+	restore := setenv(t, "NOTIFY_SOCKET", "")
+	defer restore()
+
+	sent, err := SystemdNotify(SystemdReady)
+	if err != nil || sent {
+		t.Fatalf("expected notification to be skipped but got %t, %v", sent, err)
+	}
+}
+
+func TestSystemdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name     string
+		usecs    string
+		pid      string
+		expected time.Duration
+		err      bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", usecs: "60000000", expected: time.Minute},
+		{name: "this process", usecs: "30000000", pid: pid, expected: 30 * time.Second},
+		{name: "another process", usecs: "30000000", pid: "1"},
+		{name: "invalid", usecs: "soon", err: true},
+		{name: "invalid pid", usecs: "30000000", pid: "me", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restoreUsecs := setenv(t, "WATCHDOG_USEC", test.usecs)
+			defer restoreUsecs()
+			restorePID := setenv(t, "WATCHDOG_PID", test.pid)
+			defer restorePID()
+
+			interval, err := SystemdWatchdogInterval()
+			if test.err != (err != nil) {
+				t.Fatalf("expected error to be %t but got %v", test.err, err)
+			}
+			if interval != test.expected {
+				t.Fatalf("expected interval to be %s but was %s", test.expected, interval)
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package weatherstn
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Units are the units that each kind of reading is presented in. Readings are always collected and stored in metric
+// units, Units only affect how they are output or, for imports, how the source recorded them. An empty unit is
+// treated as metric. Humidity is always % and wind direction degrees.
+type Units struct {
+	Temperature string `json:"temperature"` // C or F
+	Pressure    string `json:"pressure"`    // hPa, mbar, inHg or mmHg
+	Speed       string `json:"speed"`       // km/h, m/s, mph or knots
+	Rainfall    string `json:"rainfall"`    // mm, cm or in
+}
+
+// ImportUnits are the units that readings are recorded in, for source formats which don't declare them.
+type ImportUnits = Units
+
+const (
+	// UnitSystemMetric presents readings in °C, hPa, %, km/h and mm.
+	UnitSystemMetric = "metric"
+
+	// UnitSystemImperial presents readings in °F, inHg, %, mph and inches.
+	UnitSystemImperial = "imperial"
+
+	// HumidityUnit is the unit of humidity readings, whatever the Units.
+	HumidityUnit = "%"
+
+	// DirectionUnit is the unit of wind direction readings, whatever the Units.
+	DirectionUnit = "degrees"
+
+	quantityTemperature = "temperature"
+	quantityPressure    = "pressure"
+	quantitySpeed       = "speed"
+	quantityRainfall    = "rainfall"
+	quantityHumidity    = "humidity"
+	quantityDirection   = "direction"
+
+	hPaInInHg   = 33.8638866667
+	hPaInMMHg   = 1.33322387415
+	kmInMiles   = 1.609344
+	kmInNautMi  = 1.852
+	kmhInMetreS = 3.6
+	mmInInch    = 25.4
+	mmInCM      = 10
+)
+
+// MetricUnits returns the units that readings are stored in, °C, hPa, km/h and mm.
+func MetricUnits() Units {
+	return Units{Temperature: "C", Pressure: "hPa", Speed: "km/h", Rainfall: "mm"}
+}
+
+// ImperialUnits returns °F, inHg, mph and inches.
+func ImperialUnits() Units {
+	return Units{Temperature: "F", Pressure: "inHg", Speed: "mph", Rainfall: "in"}
+}
+
+// unitConversion converts readings of a quantity between a unit and the metric unit that readings are stored in.
+type unitConversion struct {
+	quantity   string
+	toMetric   func(float64) float64
+	fromMetric func(float64) float64
+}
+
+func scaleConversion(quantity string, metricPerUnit float64) unitConversion {
+	return unitConversion{
+		quantity:   quantity,
+		toMetric:   func(v float64) float64 { return v * metricPerUnit },
+		fromMetric: func(v float64) float64 { return v / metricPerUnit },
+	}
+}
+
+// unitConversions holds every unit that readings can be converted to or from, keyed by its canonical name.
+var unitConversions = map[string]unitConversion{
+	"C": scaleConversion(quantityTemperature, 1),
+	"F": {
+		quantity:   quantityTemperature,
+		toMetric:   func(v float64) float64 { return (v - 32) * 5 / 9 },
+		fromMetric: func(v float64) float64 { return v*9/5 + 32 },
+	},
+	"hPa":   scaleConversion(quantityPressure, 1),
+	"mbar":  scaleConversion(quantityPressure, 1),
+	"inHg":  scaleConversion(quantityPressure, hPaInInHg),
+	"mmHg":  scaleConversion(quantityPressure, hPaInMMHg),
+	"km/h":  scaleConversion(quantitySpeed, 1),
+	"m/s":   scaleConversion(quantitySpeed, kmhInMetreS),
+	"mph":   scaleConversion(quantitySpeed, kmInMiles),
+	"knots": scaleConversion(quantitySpeed, kmInNautMi),
+	"mm":    scaleConversion(quantityRainfall, 1),
+	"cm":    scaleConversion(quantityRainfall, mmInCM),
+	"in":    scaleConversion(quantityRainfall, mmInInch),
+}
+
+// unitAliases maps alternative spellings of units, as found in other software's exports, to their canonical names.
+var unitAliases = map[string]string{
+	"°C":     "C",
+	"°F":     "F",
+	"mb":     "mbar",
+	"in Hg":  "inHg",
+	"kph":    "km/h",
+	"kmh":    "km/h",
+	"ms":     "m/s",
+	"kts":    "knots",
+	"kn":     "knots",
+	"inch":   "in",
+	"inches": "in",
+}
+
+// lookupUnit returns the canonical name of unit and its conversion.
+func lookupUnit(unit string) (string, unitConversion, error) {
+	if alias, ok := unitAliases[unit]; ok {
+		unit = alias
+	}
+
+	conversion, ok := unitConversions[unit]
+	if !ok {
+		return "", unitConversion{}, fmt.Errorf("unknown unit %s", unit)
+	}
+
+	return unit, conversion, nil
+}
+
+// ConvertToMetric converts a reading in the given unit, e.g. F or mph, into the metric unit used for storage.
+func ConvertToMetric(value float64, unit string) (float64, error) {
+	_, conversion, err := lookupUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+
+	return conversion.toMetric(value), nil
+}
+
+// ConvertFromMetric converts a reading in the metric unit used for storage into the given unit, e.g. F or mph.
+func ConvertFromMetric(value float64, unit string) (float64, error) {
+	_, conversion, err := lookupUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+
+	return conversion.fromMetric(value), nil
+}
+
+// ParseUnits returns the Units described by spec, a comma separated list starting with an optional unit system,
+// metric or imperial, followed by any quantity=unit overrides for mixed units, e.g. "metric,speed=knots". The
+// quantities are temperature, pressure, speed and rainfall. An empty spec is metric.
+func ParseUnits(spec string) (Units, error) {
+	units := MetricUnits()
+	for i, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) == 1 {
+			if i > 0 {
+				return Units{}, fmt.Errorf("unit system %s must come before any quantity=unit overrides", part)
+			}
+
+			switch part {
+			case UnitSystemMetric:
+			case UnitSystemImperial:
+				units = ImperialUnits()
+			default:
+				return Units{}, fmt.Errorf("unknown unit system %s, valid systems are %s and %s", part,
+					UnitSystemMetric, UnitSystemImperial)
+			}
+			continue
+		}
+
+		unit, ok := units.field(strings.TrimSpace(kv[0]))
+		if !ok {
+			return Units{}, fmt.Errorf("unknown quantity %s, valid quantities are %s, %s, %s and %s", kv[0],
+				quantityTemperature, quantityPressure, quantitySpeed, quantityRainfall)
+		}
+		*unit = strings.TrimSpace(kv[1])
+	}
+
+	return units.normalize()
+}
+
+// field returns a pointer to the unit of the named quantity.
+func (u *Units) field(quantity string) (*string, bool) {
+	switch quantity {
+	case quantityTemperature:
+		return &u.Temperature, true
+	case quantityPressure:
+		return &u.Pressure, true
+	case quantitySpeed:
+		return &u.Speed, true
+	case quantityRainfall:
+		return &u.Rainfall, true
+	default:
+		return nil, false
+	}
+}
+
+// normalize returns the units with empty units replaced by metric and aliases by canonical names. An error is
+// returned if any unit is unknown or measures the wrong quantity.
+func (u Units) normalize() (Units, error) {
+	metric := MetricUnits()
+	for _, quantity := range []string{quantityTemperature, quantityPressure, quantitySpeed, quantityRainfall} {
+		unit, _ := u.field(quantity)
+		if *unit == "" {
+			metricUnit, _ := metric.field(quantity)
+			*unit = *metricUnit
+			continue
+		}
+
+		name, conversion, err := lookupUnit(*unit)
+		if err != nil {
+			return Units{}, err
+		}
+		if conversion.quantity != quantity {
+			return Units{}, fmt.Errorf("%s is a unit of %s not %s", *unit, conversion.quantity, quantity)
+		}
+		*unit = name
+	}
+
+	return u, nil
+}
+
+// String returns the units as a spec which ParseUnits accepts.
+func (u Units) String() string {
+	switch u {
+	case MetricUnits():
+		return UnitSystemMetric
+	case ImperialUnits():
+		return UnitSystemImperial
+	}
+
+	parts := []string{UnitSystemMetric}
+	metric := MetricUnits()
+	for _, quantity := range []string{quantityTemperature, quantityPressure, quantitySpeed, quantityRainfall} {
+		unit, _ := u.field(quantity)
+		metricUnit, _ := metric.field(quantity)
+		if *unit != "" && *unit != *metricUnit {
+			parts = append(parts, quantity+"="+*unit)
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// UnmarshalJSON accepts either a spec string, as read by ParseUnits, or an object with a unit for each quantity.
+func (u *Units) UnmarshalJSON(data []byte) error {
+	var spec string
+	if err := json.Unmarshal(data, &spec); err == nil {
+		units, err := ParseUnits(spec)
+		if err != nil {
+			return err
+		}
+		*u = units
+		return nil
+	}
+
+	var declared map[string]string
+	if err := json.Unmarshal(data, &declared); err != nil {
+		return fmt.Errorf("units must be a string or an object of units but was %s", data)
+	}
+
+	units, err := unitsFromDeclared(declared)
+	if err != nil {
+		return err
+	}
+	*u = units
+
+	return nil
+}
+
+// unitsFromDeclared returns the Units with the unit of each quantity in declared. Humidity and direction may be
+// declared too, as they are by MarshalJSON, but only in their fixed units.
+func unitsFromDeclared(declared map[string]string) (Units, error) {
+	var units Units
+	quantities := make([]string, 0, len(declared))
+	for quantity := range declared {
+		quantities = append(quantities, quantity)
+	}
+	sort.Strings(quantities)
+	for _, quantity := range quantities {
+		unit := declared[quantity]
+		switch {
+		case quantity == quantityHumidity && unit == HumidityUnit, quantity == quantityDirection && unit == DirectionUnit:
+			continue
+		}
+
+		field, ok := units.field(quantity)
+		if !ok {
+			return Units{}, fmt.Errorf("unknown quantity %s with unit %s", quantity, unit)
+		}
+		*field = unit
+	}
+
+	return units.normalize()
+}
+
+// declaredUnits is how Units are written, declaring the unit of every reading.
+type declaredUnits struct {
+	Temperature string `json:"temperature"`
+	Pressure    string `json:"pressure"`
+	Humidity    string `json:"humidity"`
+	Speed       string `json:"speed"`
+	Direction   string `json:"direction"`
+	Rainfall    string `json:"rainfall"`
+}
+
+func (u Units) declared() (declaredUnits, error) {
+	units, err := u.normalize()
+	if err != nil {
+		return declaredUnits{}, err
+	}
+
+	return declaredUnits{units.Temperature, units.Pressure, HumidityUnit, units.Speed, DirectionUnit, units.Rainfall},
+		nil
+}
+
+// MarshalJSON writes the units as an object declaring the unit of every reading, including humidity and direction.
+func (u Units) MarshalJSON() ([]byte, error) {
+	declared, err := u.declared()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(declared)
+}
+
+// converter returns a function which converts metric readings of quantity into the units.
+func (u Units) converter(quantity string) func(float64) float64 {
+	unit, _ := u.field(quantity)
+	if _, conversion, err := lookupUnit(*unit); err == nil {
+		return conversion.fromMetric
+	}
+
+	return func(v float64) float64 { return v }
+}
+
+// metricConverter returns a function which converts readings of quantity in the units into metric.
+func (u Units) metricConverter(quantity string) func(float64) float64 {
+	unit, _ := u.field(quantity)
+	if _, conversion, err := lookupUnit(*unit); err == nil {
+		return conversion.toMetric
+	}
+
+	return func(v float64) float64 { return v }
+}
+
+// Convert returns a copy of the row with its readings, including those of additional sensors, converted from metric
+// into the units.
+func (u Units) Convert(row WeatherDataRow) WeatherDataRow {
+	units, err := u.normalize()
+	if err != nil {
+		units = MetricUnits()
+	}
+
+	return convertRow(row, units.converter)
+}
+
+// ToMetric returns a copy of the row with its readings, including those of additional sensors, converted from the
+// units into metric, as received from a station which publishes in the units.
+func (u Units) ToMetric(row WeatherDataRow) WeatherDataRow {
+	units, err := u.normalize()
+	if err != nil {
+		units = MetricUnits()
+	}
+
+	return convertRow(row, units.metricConverter)
+}
+
+// ConvertReadings returns a copy of the readings of a single sensor converted from metric into the units.
+func (u Units) ConvertReadings(readings SensorReadings) SensorReadings {
+	return convertReadings(readings, u.converter)
+}
+
+// quantityConverter returns the function converting readings of a quantity.
+type quantityConverter func(quantity string) func(float64) float64
+
+func convertRow(row WeatherDataRow, converter quantityConverter) WeatherDataRow {
+	row.AtmosReadings = *convertAtmos(&row.AtmosReadings, converter)
+	row.WindReadings = *convertWind(&row.WindReadings, converter)
+	row.RainReadings = *convertRain(&row.RainReadings, converter)
+	if row.SensorReadings != nil {
+		sensorReadings := make(map[string]SensorReadings, len(row.SensorReadings))
+		for id, readings := range row.SensorReadings {
+			sensorReadings[id] = convertReadings(readings, converter)
+		}
+		row.SensorReadings = sensorReadings
+	}
+
+	return row
+}
+
+func convertReadings(readings SensorReadings, converter quantityConverter) SensorReadings {
+	if readings.AtmosReadings != nil {
+		readings.AtmosReadings = convertAtmos(readings.AtmosReadings, converter)
+	}
+	if readings.WindReadings != nil {
+		readings.WindReadings = convertWind(readings.WindReadings, converter)
+	}
+	if readings.RainReadings != nil {
+		readings.RainReadings = convertRain(readings.RainReadings, converter)
+	}
+
+	return readings
+}
+
+func convertAtmos(readings *AtmoshphericReadings, converter quantityConverter) *AtmoshphericReadings {
+	temperature := converter(quantityTemperature)
+	converted := *readings
+	converted.Temperature = temperature(readings.Temperature)
+	if min := readings.TemperatureMin; min != nil {
+		convertedMin := temperature(*min)
+		converted.TemperatureMin = &convertedMin
+	}
+	if max := readings.TemperatureMax; max != nil {
+		convertedMax := temperature(*max)
+		converted.TemperatureMax = &convertedMax
+	}
+	converted.Pressure = converter(quantityPressure)(readings.Pressure)
+
+	return &converted
+}
+
+func convertWind(readings *WindReadings, converter quantityConverter) *WindReadings {
+	speed := converter(quantitySpeed)
+	converted := *readings
+	converted.Speed = speed(readings.Speed)
+	converted.Gust = speed(readings.Gust)
+
+	return &converted
+}
+
+func convertRain(readings *RainReadings, converter quantityConverter) *RainReadings {
+	converted := *readings
+	converted.Rainfall = converter(quantityRainfall)(readings.Rainfall)
+
+	return &converted
+}
+
+// unit returns the unit of an exported quantity, which also includes humidity and direction.
+func (u Units) unit(quantity string) string {
+	switch quantity {
+	case quantityHumidity:
+		return HumidityUnit
+	case quantityDirection:
+		return DirectionUnit
+	}
+
+	units, err := u.normalize()
+	if err != nil {
+		units = MetricUnits()
+	}
+	unit, ok := units.field(quantity)
+	if !ok {
+		return ""
+	}
+
+	return *unit
+}
@@ -0,0 +1,168 @@
+package weatherstn
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// closeTo reports whether a is within a millionth of b, relative to b.
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestUnitConversions(t *testing.T) {
+	// Each unit converts 1 metric reading into value, and back again.
+	tests := []struct {
+		unit   string
+		metric float64
+		value  float64
+	}{
+		{"C", 20, 20},
+		{"F", 20, 68},
+		{"F", -40, -40},
+		{"hPa", 1013.25, 1013.25},
+		{"mbar", 1013.25, 1013.25},
+		{"inHg", 1013.25, 29.9212553},
+		{"mmHg", 1013.25, 760},
+		{"km/h", 36, 36},
+		{"m/s", 36, 10},
+		{"mph", 16.09344, 10},
+		{"knots", 18.52, 10},
+		{"mm", 25.4, 25.4},
+		{"cm", 25.4, 2.54},
+		{"in", 25.4, 1},
+	}
+	for _, test := range tests {
+		from, err := ConvertFromMetric(test.metric, test.unit)
+		if err != nil {
+			t.Fatalf("unexpected error converting to %s: %v", test.unit, err)
+		}
+		if !closeTo(from, test.value) {
+			t.Fatalf("expected %f metric to be %f %s but was %f", test.metric, test.value, test.unit, from)
+		}
+
+		to, err := ConvertToMetric(test.value, test.unit)
+		if err != nil {
+			t.Fatalf("unexpected error converting from %s: %v", test.unit, err)
+		}
+		if !closeTo(to, test.metric) {
+			t.Fatalf("expected %f %s to be %f metric but was %f", test.value, test.unit, test.metric, to)
+		}
+	}
+
+	for unit := range unitConversions {
+		found := false
+		for _, test := range tests {
+			found = found || test.unit == unit
+		}
+		if !found {
+			t.Fatalf("expected conversion to %s to be tested", unit)
+		}
+	}
+
+	if _, err := ConvertFromMetric(1, "furlongs"); err == nil {
+		t.Fatalf("expected unknown unit to be rejected")
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	tests := map[string]Units{
+		"":                                      MetricUnits(),
+		"metric":                                MetricUnits(),
+		"imperial":                              ImperialUnits(),
+		"metric,speed=knots":                    {Temperature: "C", Pressure: "hPa", Speed: "knots", Rainfall: "mm"},
+		"speed=m/s":                             {Temperature: "C", Pressure: "hPa", Speed: "m/s", Rainfall: "mm"},
+		"imperial, pressure=mmHg":               {Temperature: "F", Pressure: "mmHg", Speed: "mph", Rainfall: "in"},
+		"metric,temperature=°F,rainfall=inches": {Temperature: "F", Pressure: "hPa", Speed: "km/h", Rainfall: "in"},
+	}
+	for spec, expected := range tests {
+		units, err := ParseUnits(spec)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", spec, err)
+		}
+		if units != expected {
+			t.Fatalf("expected %q to be %#v but was %#v", spec, expected, units)
+		}
+
+		reparsed, err := ParseUnits(units.String())
+		if err != nil || reparsed != units {
+			t.Fatalf("expected %q to round trip through %q but got %#v, %v", spec, units, reparsed, err)
+		}
+	}
+
+	for _, spec := range []string{"nautical", "speed=knots,imperial", "wind=knots", "speed=inHg", "speed=warp"} {
+		if _, err := ParseUnits(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestUnits_JSON(t *testing.T) {
+	data, err := json.Marshal(Units{Speed: "kts"})
+	if err != nil {
+		t.Fatalf("unexpected error marshalling units: %v", err)
+	}
+
+	expected := `{"temperature":"C","pressure":"hPa","humidity":"%","speed":"knots","direction":"degrees",` +
+		`"rainfall":"mm"}`
+	if string(data) != expected {
+		t.Fatalf("expected units to declare every reading as %s but was %s", expected, data)
+	}
+
+	for _, encoded := range []string{string(data), `"metric,speed=knots"`, `{"speed": "knots"}`} {
+		var units Units
+		if err := json.Unmarshal([]byte(encoded), &units); err != nil {
+			t.Fatalf("unexpected error unmarshalling %s: %v", encoded, err)
+		}
+		if units != (Units{Temperature: "C", Pressure: "hPa", Speed: "knots", Rainfall: "mm"}) {
+			t.Fatalf("expected %s to be knots but was %#v", encoded, units)
+		}
+	}
+
+	var units Units
+	if err := json.Unmarshal([]byte(`{"humidity": "g/m3"}`), &units); err == nil {
+		t.Fatalf("expected unsupported humidity unit to be rejected")
+	}
+}
+
+func TestUnits_Convert(t *testing.T) {
+	min, max := 10.0, 30.0
+	row := WeatherDataRow{
+		AtmosReadings: AtmoshphericReadings{Temperature: 20, Pressure: 1013.25, Humidity: 50, TemperatureMin: &min,
+			TemperatureMax: &max},
+		WindReadings: WindReadings{Speed: 16.09344, Direction: 90, Gust: 32.18688},
+		RainReadings: RainReadings{Rainfall: 25.4},
+		SensorReadings: map[string]SensorReadings{
+			"ground": {AtmosReadings: &AtmoshphericReadings{Temperature: 0}},
+		},
+	}
+
+	converted := ImperialUnits().Convert(row)
+
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	expectedMin, expectedMax := 50.0, 86.0
+	expected := WeatherDataRow{
+		AtmosReadings: AtmoshphericReadings{Temperature: 68, Pressure: 29.921, Humidity: 50,
+			TemperatureMin: &expectedMin, TemperatureMax: &expectedMax},
+		WindReadings: WindReadings{Speed: 10, Direction: 90, Gust: 20},
+		RainReadings: RainReadings{Rainfall: 1},
+		SensorReadings: map[string]SensorReadings{
+			"ground": {AtmosReadings: &AtmoshphericReadings{Temperature: 32}},
+		},
+	}
+	actual := converted
+	actual.AtmosReadings.Pressure = round(actual.AtmosReadings.Pressure)
+	actual.WindReadings.Speed = round(actual.WindReadings.Speed)
+	actual.WindReadings.Gust = round(actual.WindReadings.Gust)
+	actual.RainReadings.Rainfall = round(actual.RainReadings.Rainfall)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected row to be converted to %#v but was %#v", expected, actual)
+	}
+
+	if row.AtmosReadings.Temperature != 20 || *row.AtmosReadings.TemperatureMin != 10 ||
+		row.SensorReadings["ground"].AtmosReadings.Temperature != 0 {
+		t.Fatalf("expected original row to be unchanged but was %#v", row)
+	}
+}
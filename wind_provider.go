@@ -1,14 +1,14 @@
 package weatherstn
 
 import (
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/sys/unix"
 
 	"github.com/warthog618/gpio"
 	"github.com/warthog618/gpio/spi/mcp3w0c"
@@ -31,6 +31,35 @@ const (
 	secsInHour = 3600
 )
 
+// Defaults for the SEN08942WindSensorProviderConfig, the pins are BCM numbers wired as in the raspberry pi weather
+// station guide with the wind vane read through an MCP3008.
+const (
+	DefaultSEN08942AnemPin          = 5
+	DefaultSEN08942AnemIntervalSecs = 5
+	DefaultSEN08942VaneClkPin       = 11
+	DefaultSEN08942VaneCSPin        = 8
+	DefaultSEN08942VaneDinPin       = 10
+	DefaultSEN08942VaneDoutPin      = 9
+)
+
+// SensorTypeSEN08942Wind is the config type of the SEN08942WindSensorProvider.
+const SensorTypeSEN08942Wind = "sen08942-wind"
+
+func init() {
+	RegisterSensorType(SensorTypeSEN08942Wind, func(id string, settings json.RawMessage) (SensorProvider, error) {
+		var config SEN08942WindSensorProviderConfig
+		if err := decodeSensorSettings(settings, &config); err != nil {
+			return nil, err
+		}
+
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+
+		return NewSEN08942WindSensorProvider(config), nil
+	})
+}
+
 var voltsToDegrees = map[string]float32{
 	"0.4": 0.0,
 	"1.4": 22.5,
@@ -53,7 +82,7 @@ var voltsToDegrees = map[string]float32{
 // WindSensorProvider provides a way to setup and collect wind data readings.
 type WindSensorProvider interface {
 	SensorProvider
-	Readings() (*WindReadings, error)
+	Readings(ctx context.Context) (*WindReadings, error)
 }
 
 // WindReadings are the sensor readings about measurements such as wind speed.
@@ -89,27 +118,51 @@ type SEN08942WindSensorProvider struct {
 	haltedCh chan struct{}
 }
 
-// SEN08942WindSensorProviderConfig is used for setup of the SEN08942.
+// SEN08942WindSensorProviderConfig is used for setup of the SEN08942. Pins which are zero use their defaults and the
+// anemometer is counted over intervals of DefaultSEN08942AnemIntervalSecs when AnemInterval is zero.
 type SEN08942WindSensorProviderConfig struct {
-	AnemPinNumber int           `json:"anemPin"`
-	AnemInterval  time.Duration `json:"anemIntervalSecs"`
+	AnemPinNumber int      `json:"anemPin"`
+	AnemInterval  Duration `json:"anemIntervalSecs"` // seconds, or a string such as "500ms"
 
 	VaneClkPinNumber  int `json:"vaneClkPin"`
 	VaneCSPinNumber   int `json:"vaneCSPin"`
 	VaneDInPinNumber  int `json:"vaneDinPin"`
 	VaneDOutPinNumber int `json:"vaneDoutPin"`
-	VaneChannel       int `json:"vaneChannel"`
+	VaneChannel       int `json:"vaneChannel"` // MCP3008 channel from 0 to 7
+}
+
+// Validate returns an error if any of the settings are out of range.
+func (c SEN08942WindSensorProviderConfig) Validate() error {
+	var problems configProblems
+	problems.notNegative("anemPin", c.AnemPinNumber)
+	problems.notNegative("vaneClkPin", c.VaneClkPinNumber)
+	problems.notNegative("vaneCSPin", c.VaneCSPinNumber)
+	problems.notNegative("vaneDinPin", c.VaneDInPinNumber)
+	problems.notNegative("vaneDoutPin", c.VaneDOutPinNumber)
+	if c.AnemInterval < 0 {
+		problems.add("anemIntervalSecs must not be negative but was %s", c.AnemInterval.Duration())
+	}
+	if c.VaneChannel < 0 || c.VaneChannel > 7 {
+		problems.add("vaneChannel must be from 0 to 7 but was %d", c.VaneChannel)
+	}
+
+	return problems.err()
 }
 
 // NewSEN08942WindSensorProvider returns a new SEN08942WindSensorProvider.
 func NewSEN08942WindSensorProvider(config SEN08942WindSensorProviderConfig) *SEN08942WindSensorProvider {
+	anemInterval := config.AnemInterval.Duration()
+	if anemInterval <= 0 {
+		anemInterval = DefaultSEN08942AnemIntervalSecs * time.Second
+	}
+
 	return &SEN08942WindSensorProvider{
-		anemPinNumber:     config.AnemPinNumber,
-		anemInterval:      config.AnemInterval,
-		vaneClkPinNumber:  config.VaneClkPinNumber,
-		vaneCSPinNumber:   config.VaneCSPinNumber,
-		vaneDInPinNumber:  config.VaneDInPinNumber,
-		vaneDOutPinNumber: config.VaneDOutPinNumber,
+		anemPinNumber:     defaultInt(config.AnemPinNumber, DefaultSEN08942AnemPin),
+		anemInterval:      anemInterval,
+		vaneClkPinNumber:  defaultInt(config.VaneClkPinNumber, DefaultSEN08942VaneClkPin),
+		vaneCSPinNumber:   defaultInt(config.VaneCSPinNumber, DefaultSEN08942VaneCSPin),
+		vaneDInPinNumber:  defaultInt(config.VaneDInPinNumber, DefaultSEN08942VaneDinPin),
+		vaneDOutPinNumber: defaultInt(config.VaneDOutPinNumber, DefaultSEN08942VaneDoutPin),
 		vaneChannel:       config.VaneChannel,
 
 		haltCh:   make(chan struct{}),
@@ -117,19 +170,37 @@ func NewSEN08942WindSensorProvider(config SEN08942WindSensorProviderConfig) *SEN
 	}
 }
 
+// Resources returns the GPIO pins used by the anemometer and the wind vane's ADC.
+func (wr *SEN08942WindSensorProvider) Resources() []string {
+	return []string{
+		gpioResource(wr.anemPinNumber),
+		gpioResource(wr.vaneClkPinNumber),
+		gpioResource(wr.vaneCSPinNumber),
+		gpioResource(wr.vaneDInPinNumber),
+		gpioResource(wr.vaneDOutPinNumber),
+	}
+}
+
+func gpioResource(pin int) string {
+	return fmt.Sprintf("gpio %d", pin)
+}
+
 // Connect sets up the connections to pins and creates watchers.
-func (wr *SEN08942WindSensorProvider) Connect() error {
-	err := gpio.Open()
-	if err != nil && !errors.Is(err, gpio.ErrAlreadyOpen) {
+func (wr *SEN08942WindSensorProvider) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := openGPIO(); err != nil {
 		return err
 	}
 
 	pin := gpio.NewPin(wr.anemPinNumber)
 	pin.Input()
 	pin.PullUp()
-	err = pin.Watch(gpio.EdgeRising, wr.onPinHigh)
+	err := pin.Watch(gpio.EdgeRising, wr.onPinHigh)
 	if err != nil {
-		closeErr := gpio.Close()
+		closeErr := closeGPIO()
 		if closeErr != nil {
 			log.WithError(closeErr).
 				WithField("component", "wind provider").
@@ -163,16 +234,27 @@ func (wr *SEN08942WindSensorProvider) Disconnect() {
 	wr.pin.Unwatch()
 	wr.pinLock.Unlock()
 	wr.adc.Close()
-	err := gpio.Close()
-	if err != nil && !errors.Is(err, unix.EINVAL) { // We're using gpio in 2 places and closing it twice causes EINVAL
+	if err := closeGPIO(); err != nil {
 		log.WithError(err).
 			WithField("component", "wind provider").
 			Error("gpio failed to close")
 	}
 }
 
-// Readings returns the set of WindReadings provided by the SEN08942.
-func (wr *SEN08942WindSensorProvider) Readings() (*WindReadings, error) {
+// Readings returns the set of WindReadings provided by the SEN08942. The speed is only worked out once every anemometer
+// interval so ErrNoSample is returned if none has been since the last readings, rather than reporting a speed of 0.
+func (wr *SEN08942WindSensorProvider) Readings(ctx context.Context) (*WindReadings, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	wr.speedsLock.Lock()
+	speedsSeen := wr.speedsRead
+	wr.speedsLock.Unlock()
+	if speedsSeen == 0 {
+		return nil, ErrNoSample
+	}
+
 	dirReading := wr.adc.Read(wr.vaneChannel)
 	voltage := float64(dirReading) / SEN08942NumADCValues * SEN08942Voltage
 	voltageString := fmt.Sprintf("%.1f", voltage)
@@ -188,7 +270,7 @@ func (wr *SEN08942WindSensorProvider) Readings() (*WindReadings, error) {
 
 	wr.speedsLock.Lock()
 	totalSpeed := wr.totalSpeed
-	speedsSeen := wr.speedsRead
+	speedsSeen = wr.speedsRead
 	gust := wr.maxGust
 	wr.speedsLock.Unlock()
 	wr.resetSpeeds()
@@ -243,7 +325,7 @@ func (wr *SEN08942WindSensorProvider) monitorWindSpeed() {
 
 		rotations := float64(highs) / 2 // Anemometer triggers twice per rotation
 		distance := (SEN08942AnemCircum * rotations) / cmInKM
-		speed := (distance / (float64(wr.anemInterval / time.Second))) * secsInHour * SEN08942AnemFactor
+		speed := (distance / wr.anemInterval.Seconds()) * secsInHour * SEN08942AnemFactor
 
 		wr.speedsLock.Lock()
 		wr.totalSpeed += speed